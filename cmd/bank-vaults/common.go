@@ -15,10 +15,15 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
 
 	"emperror.dev/errors"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"github.com/spf13/cast"
 	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
 
 	internalVault "github.com/banzaicloud/bank-vaults/internal/vault"
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
@@ -32,9 +37,14 @@ import (
 	"github.com/banzaicloud/bank-vaults/pkg/kv/gcs"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/hsm"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/k8s"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/localcrypt"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/multi"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/s3"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/shamir"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/sql"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/transit"
 	kvvault "github.com/banzaicloud/bank-vaults/pkg/kv/vault"
+	"github.com/banzaicloud/bank-vaults/pkg/sdk/vault"
 )
 
 func vaultConfigForConfig(c *viper.Viper) internalVault.Config {
@@ -75,7 +85,31 @@ func correctValues(flags, choices []string) bool {
 	return true
 }
 
+// k8sSecretLabelsForConfig returns the K8S Secret labels stored in the config,
+// falling back to the labels bound to the global flag (see k8sSecretLabels).
+func k8sSecretLabelsForConfig(cfg *viper.Viper) map[string]string {
+	switch labels := cfg.Get(cfgK8SLabels).(type) {
+	case map[string]string:
+		return labels
+	case map[string]interface{}:
+		return cast.ToStringMapString(labels)
+	}
+
+	return k8sSecretLabels
+}
+
+// kvStoreForConfig creates the key store selected by the mode, instrumented with latency metrics
+// and the operation timeout of the backend
 func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
+	store, err := newKVStoreForMode(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return newInstrumentedKVStore(store, cfg.GetString(cfgMode), cfg.GetDuration(cfgKVTimeout)), nil
+}
+
+func newKVStoreForMode(cfg *viper.Viper) (kv.Service, error) {
 	switch mode := cfg.GetString(cfgMode); mode {
 	case cfgModeValueGoogleCloudKMSGCS:
 		gcs, err := gcs.New(
@@ -174,7 +208,10 @@ func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
 			}
 		}
 
-		return multi.New(services), nil
+		return multi.NewWithConfig(services, multi.Config{
+			Quorum: cfg.GetBool(cfgMultiQuorumReads),
+			Repair: cfg.GetBool(cfgMultiRepair),
+		}), nil
 
 	case cfgModeValueAzureKeyVault:
 		akv, err := azurekv.New(cfg.GetString(cfgAzureKeyVaultName))
@@ -239,7 +276,7 @@ func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
 		k8s, err := k8s.New(
 			cfg.GetString(cfgK8SNamespace),
 			cfg.GetString(cfgK8SSecret),
-			k8sSecretLabels,
+			k8sSecretLabelsForConfig(cfg),
 		)
 		if err != nil {
 			return nil, errors.Wrap(err, "error creating K8S Secret kv store")
@@ -252,7 +289,7 @@ func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
 		k8s, err := k8s.New(
 			cfg.GetString(cfgK8SNamespace),
 			cfg.GetString(cfgK8SSecret),
-			k8sSecretLabels,
+			k8sSecretLabelsForConfig(cfg),
 		)
 		if err != nil {
 			return nil, errors.Wrap(err, "error creating K8S Secret with with kv store")
@@ -306,7 +343,172 @@ func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
 
 		return file, nil
 
+	case cfgModeValueSQL:
+		sql, err := sql.New(
+			cfg.GetString(cfgSQLDriver),
+			cfg.GetString(cfgSQLDSN),
+			cfg.GetString(cfgSQLTable),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating SQL kv store")
+		}
+
+		return sql, nil
+
+	// BANK_VAULTS_LOCALCRYPT_PASSPHRASE=banzai bank-vaults unseal --mode localcrypt-k8s --k8s-secret-name vault-unseal-keys
+	case cfgModeValueLocalCryptK8S:
+		k8s, err := k8s.New(
+			cfg.GetString(cfgK8SNamespace),
+			cfg.GetString(cfgK8SSecret),
+			k8sSecretLabelsForConfig(cfg),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating K8S Secret kv store")
+		}
+
+		return localCryptForConfig(cfg, k8s)
+
+	case cfgModeValueLocalCryptFile:
+		file, err := file.New(cfg.GetString(cfgFilePath))
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating File kv store")
+		}
+
+		return localCryptForConfig(cfg, file)
+
+	case cfgModeValueTransitK8S:
+		k8s, err := k8s.New(
+			cfg.GetString(cfgK8SNamespace),
+			cfg.GetString(cfgK8SSecret),
+			k8sSecretLabelsForConfig(cfg),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating K8S Secret kv store")
+		}
+
+		return transitForConfig(cfg, k8s)
+
+	case cfgModeValueTransitS3:
+		var region, bucket string
+		if regions := cfg.GetStringSlice(cfgAWSS3Region); len(regions) > 0 {
+			region = regions[0]
+		}
+		if buckets := cfg.GetStringSlice(cfgAWSS3Bucket); len(buckets) > 0 {
+			bucket = buckets[0]
+		}
+
+		s3Service, err := s3.New(region, bucket, cfg.GetString(cfgAWSS3Prefix), "", "")
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating AWS S3 kv store")
+		}
+
+		return transitForConfig(cfg, s3Service)
+
+	case cfgModeValueShamir:
+		backends, err := shamirBackendConfigs(cfg.GetString(cfgShamirBackends))
+		if err != nil {
+			return nil, err
+		}
+
+		var services []kv.Service
+		for i, backend := range backends {
+			if backend.GetString(cfgMode) == cfgModeValueShamir {
+				return nil, errors.Errorf("backend #%d of the Shamir kv store can't be a Shamir kv store", i)
+			}
+
+			service, err := kvStoreForConfig(backend)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error creating backend #%d of the Shamir kv store", i)
+			}
+
+			services = append(services, service)
+		}
+
+		shamir, err := shamir.New(services, cfg.GetInt(cfgShamirThreshold))
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating Shamir kv store")
+		}
+
+		return shamir, nil
+
 	default:
 		return nil, errors.Errorf("unsupported backend mode: '%s'", mode)
 	}
 }
+
+func localCryptForConfig(cfg *viper.Viper, store kv.Service) (kv.Service, error) {
+	passphrase := cfg.GetString(cfgLocalCryptPassphrase)
+	keyFile := cfg.GetString(cfgLocalCryptKeyFile)
+
+	var localCrypt kv.Service
+	var err error
+
+	switch {
+	case passphrase != "" && keyFile != "":
+		return nil, errors.Errorf("specify either a passphrase or a key file for local encryption, not both")
+	case passphrase != "":
+		localCrypt, err = localcrypt.NewWithPassphrase(store, passphrase)
+	case keyFile != "":
+		localCrypt, err = localcrypt.NewWithKeyFile(store, keyFile)
+	default:
+		return nil, errors.Errorf("a passphrase or a key file is required for local encryption")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating local encryption kv store")
+	}
+
+	return localCrypt, nil
+}
+
+func transitForConfig(cfg *viper.Viper, store kv.Service) (kv.Service, error) {
+	transit, err := transit.New(
+		store,
+		cfg.GetString(cfgTransitMountPath),
+		cfg.GetString(cfgTransitKeyName),
+		vault.ClientURL(cfg.GetString(cfgVaultAddress)),
+		vault.ClientRole(cfg.GetString(cfgVaultRole)),
+		vault.ClientAuthPath(cfg.GetString(cfgVaultAuthPath)),
+		vault.ClientTokenPath(cfg.GetString(cfgVaultTokenPath)),
+		vault.ClientToken(cfg.GetString(cfgVaultToken)),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Vault transit kv store")
+	}
+
+	return transit, nil
+}
+
+// shamirBackendConfigs reads the list of backend configurations from a YAML file,
+// every backend is configured with the same keys as the command line flags, for example:
+//
+//   - mode: k8s
+//     k8s-secret-namespace: vault
+//     k8s-secret-name: vault-unseal-keys
+//   - mode: google-cloud-kms-gcs
+//     google-cloud-kms-project: ...
+func shamirBackendConfigs(backendsFile string) ([]*viper.Viper, error) {
+	if backendsFile == "" {
+		return nil, errors.Errorf("the backends file of the Shamir kv store must be specified")
+	}
+
+	data, err := ioutil.ReadFile(backendsFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading the backends file of the Shamir kv store")
+	}
+
+	var backends []map[string]interface{}
+	if err := yaml.Unmarshal(data, &backends); err != nil {
+		return nil, errors.Wrap(err, "error parsing the backends file of the Shamir kv store")
+	}
+
+	configs := make([]*viper.Viper, 0, len(backends))
+	for _, backend := range backends {
+		config := viper.New()
+		for key, value := range backend {
+			config.Set(key, value)
+		}
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
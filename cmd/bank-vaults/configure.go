@@ -15,19 +15,21 @@
 package main
 
 import (
-	"io/ioutil"
+	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"emperror.dev/errors"
 	"github.com/fsnotify/fsnotify"
 	"github.com/jpillora/backoff"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
-	"github.com/banzaicloud/bank-vaults/internal/configuration"
 	internalVault "github.com/banzaicloud/bank-vaults/internal/vault"
 	"github.com/banzaicloud/bank-vaults/pkg/sdk/vault"
 )
@@ -36,6 +38,18 @@ const (
 	cfgVaultConfigFile = "vault-config-file"
 	cfgFatal           = "fatal"
 	cfgDisableMetrics  = "disable-metrics"
+	cfgDryRun          = "dry-run"
+	cfgDryRunOutput    = "dry-run-output"
+
+	cfgReconcileInterval    = "reconcile-interval"
+	cfgReconcileAutoCorrect = "reconcile-auto-correct"
+
+	cfgRotationCheckInterval = "rotation-check-interval"
+)
+
+const (
+	dryRunOutputText = "text"
+	dryRunOutputJSON = "json"
 )
 
 var configureCmd = &cobra.Command{
@@ -45,11 +59,16 @@ var configureCmd = &cobra.Command{
 			https://www.vaultproject.io/docs/configuration/index.html. With this it is possible to
 			configure secret engines, auth methods, etc...`,
 	Run: func(cmd *cobra.Command, args []string) {
-		var unsealConfig unsealCfg
+		ctx := cmd.Context()
+
+		var configureConfig configureCfg
 
-		runOnce := c.GetBool(cfgOnce)
-		errorFatal := c.GetBool(cfgFatal)
-		unsealConfig.unsealPeriod = c.GetDuration(cfgUnsealPeriod)
+		configureConfig.runOnce = c.GetBool(cfgOnce)
+		configureConfig.errorFatal = c.GetBool(cfgFatal)
+		configureConfig.unsealPeriod = c.GetDuration(cfgUnsealPeriod)
+		configureConfig.reconcileInterval = c.GetDuration(cfgReconcileInterval)
+		configureConfig.reconcileAutoCorrect = c.GetBool(cfgReconcileAutoCorrect)
+		configureConfig.rotationCheckInterval = c.GetDuration(cfgRotationCheckInterval)
 		vaultConfigFiles := c.GetStringSlice(cfgVaultConfigFile)
 		disableMetrics := c.GetBool(cfgDisableMetrics)
 
@@ -68,98 +87,260 @@ var configureCmd = &cobra.Command{
 			logrus.Fatalf("error creating vault helper: %s", err.Error())
 		}
 
+		if c.GetBool(cfgDryRun) {
+			if err := planConfigurations(ctx, v, vaultConfigFiles, c.GetString(cfgDryRunOutput)); err != nil {
+				logrus.Fatalf("error planning vault configuration: %s", err.Error())
+			}
+
+			return
+		}
+
 		if !disableMetrics {
-			metrics := prometheusExporter{Vault: v, Mode: "configure"}
+			health.watch("configure", store)
+
+			metrics := prometheusExporter{Vault: v, Mode: "configure", Address: c.GetString(cfgListenAddress)}
 			go func() {
-				err := metrics.Run()
+				err := metrics.Run(ctx)
 				if err != nil {
 					logrus.Fatalf("error creating prometheus exporter: %s", err.Error())
 				}
 			}()
 		}
 
-		configurations := make(chan *viper.Viper, len(vaultConfigFiles))
-
-		for i, vaultConfigFile := range vaultConfigFiles {
-			vaultConfigFiles[i] = filepath.Clean(vaultConfigFile)
-			configurations <- parseConfiguration(vaultConfigFile)
+		apply := func(ctx context.Context) {
+			applyConfigurations(ctx, v, vaultConfigFiles, configureConfig)
 		}
 
-		if !runOnce {
-			go watchConfigurations(vaultConfigFiles, configurations)
-		} else {
-			close(configurations)
+		if !c.GetBool(cfgLeaderElect) {
+			configurerLeader.Set(1)
+			apply(ctx)
+
+			return
 		}
 
-		// Handle backoff for configuration errors
-		b := &backoff.Backoff{
-			Min:    500 * time.Millisecond,
-			Max:    60 * time.Second,
-			Factor: 2,
-			Jitter: false,
+		if err := runLeaderElected(ctx, c, configureConfig.runOnce, apply); err != nil {
+			logrus.Fatalf("error electing leader: %s", err.Error())
 		}
+	},
+}
 
-		for config := range configurations {
+type configureCfg struct {
+	unsealPeriod         time.Duration
+	runOnce              bool
+	errorFatal           bool
+	reconcileInterval    time.Duration
+	reconcileAutoCorrect bool
 
-			logrus.Infoln("applying config file :", config.ConfigFileUsed())
+	rotationCheckInterval time.Duration
+}
+
+// applyConfigurations applies the configuration files merged into a single configuration until the context
+// is done, unless runOnce is set the files are applied again whenever any of them changes, and checked
+// for drift from the live state every reconcileInterval, and the credentials due for rotation are
+// rotated every rotationCheckInterval (if set).
+func applyConfigurations(ctx context.Context, v internalVault.Vault, vaultConfigFiles []string, configureConfig configureCfg) {
+	configurations := make(chan *viper.Viper, 1)
 
-			func() {
-				for {
-					logrus.Infof("checking if vault is sealed...")
-					sealed, err := v.Sealed()
-					if err != nil {
-						logrus.Errorf("error checking if vault is sealed: %s, waiting %s before trying again...", err.Error(), unsealConfig.unsealPeriod)
-						time.Sleep(unsealConfig.unsealPeriod)
+	for i, vaultConfigFile := range vaultConfigFiles {
+		vaultConfigFiles[i] = filepath.Clean(vaultConfigFile)
+	}
+	configurations <- parseConfiguration(vaultConfigFiles)
 
-						continue
-					}
+	if !configureConfig.runOnce {
+		go watchConfigurations(ctx, vaultConfigFiles, configurations)
+	} else {
+		close(configurations)
+	}
 
-					// If vault is sealed, we stop here and wait another unsealPeriod
-					if sealed {
-						logrus.Infof("vault is sealed, waiting %s before trying again...", unsealConfig.unsealPeriod)
-						time.Sleep(unsealConfig.unsealPeriod)
+	// A nil channel never fires, so the drift detection is disabled without an interval
+	var reconcile <-chan time.Time
+	if configureConfig.reconcileInterval > 0 && !configureConfig.runOnce {
+		ticker := time.NewTicker(configureConfig.reconcileInterval)
+		defer ticker.Stop()
 
-						continue
-					}
+		reconcile = ticker.C
+	}
 
-					logrus.Info("vault is unsealed, configuring...")
+	var rotate <-chan time.Time
+	if configureConfig.rotationCheckInterval > 0 && !configureConfig.runOnce {
+		ticker := time.NewTicker(configureConfig.rotationCheckInterval)
+		defer ticker.Stop()
 
-					if err = v.Configure(config); err != nil {
-						logrus.Errorf("error configuring vault: %s", err.Error())
-						if errorFatal {
-							os.Exit(1)
-						}
+		rotate = ticker.C
+	}
 
-						failedConfigurationsCount++
-						// Failed configuration handler - Increase the backoff sleep
-						go handleConfigurationError(config.ConfigFileUsed(), configurations, b.Duration())
+	// Handle backoff for configuration errors
+	b := &backoff.Backoff{
+		Min:    500 * time.Millisecond,
+		Max:    60 * time.Second,
+		Factor: 2,
+		Jitter: false,
+	}
 
-						return
-					}
+	// apply returns true if the configuration was applied successfully
+	apply := func(config *viper.Viper) bool {
+		logrus.Infoln("applying config file :", config.ConfigFileUsed())
+
+		for {
+			logrus.Infof("checking if vault is sealed...")
+			sealed, err := v.Sealed()
+			if err != nil {
+				logrus.Errorf("error checking if vault is sealed: %s, waiting %s before trying again...", err.Error(), configureConfig.unsealPeriod)
+				if !sleep(ctx, configureConfig.unsealPeriod) {
+					return false
+				}
 
-					// On *any* successful configuration reset the backoff
-					b.Reset()
-					successfulConfigurationsCount++
-					logrus.Info("successfully configured vault")
+				continue
+			}
 
-					return
+			// If vault is sealed, we stop here and wait another unsealPeriod
+			if sealed {
+				logrus.Infof("vault is sealed, waiting %s before trying again...", configureConfig.unsealPeriod)
+				if !sleep(ctx, configureConfig.unsealPeriod) {
+					return false
 				}
-			}()
+
+				continue
+			}
+
+			logrus.Info("vault is unsealed, configuring...")
+
+			start := time.Now()
+			err = v.Configure(ctx, config)
+
+			// Interrupted by shutdown after the section in progress, not a failed configuration
+			if err != nil && ctx.Err() != nil {
+				logrus.Warnf("configuration of %s interrupted: %s", config.ConfigFileUsed(), err.Error())
+
+				return false
+			}
+
+			health.configureAttempted(err)
+
+			if err != nil {
+				configureDuration.WithLabelValues("failure").Observe(time.Since(start).Seconds())
+				logrus.Errorf("error configuring vault: %s", err.Error())
+				if configureConfig.errorFatal {
+					os.Exit(1)
+				}
+
+				failedConfigurationsCount++
+				// Failed configuration handler - Increase the backoff sleep
+				go handleConfigurationError(ctx, vaultConfigFiles, configurations, b.Duration())
+
+				return false
+			}
+
+			configureDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
+
+			// On *any* successful configuration reset the backoff
+			b.Reset()
+			successfulConfigurationsCount++
+			logrus.Info("successfully configured vault")
+
+			return true
 		}
-	},
+	}
+
+	drift := newDriftDetector(v)
+
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Info("stopped configuring vault")
+
+			return
+		case config, ok := <-configurations:
+			if !ok {
+				return
+			}
+
+			apply(config)
+		case <-reconcile:
+			config := parseConfiguration(vaultConfigFiles)
+			if drift.detect(ctx, config) && configureConfig.reconcileAutoCorrect {
+				logrus.Infof("correcting drift of config file %s", config.ConfigFileUsed())
+				if apply(config) {
+					reconciliations.WithLabelValues("corrected").Inc()
+				}
+			}
+		case <-rotate:
+			config := parseConfiguration(vaultConfigFiles)
+			if err := v.RotateCredentials(ctx, config); err != nil {
+				logrus.Errorf("error rotating credentials of config file %s: %s", config.ConfigFileUsed(), err.Error())
+			}
+		}
+	}
 }
 
-func handleConfigurationError(vaultConfigFile string, configurations chan *viper.Viper, sleepTime time.Duration) {
+// planConfigurations prints the changes the configuration files merged into a single configuration
+// would make to Vault, without applying them.
+func planConfigurations(ctx context.Context, v internalVault.Vault, vaultConfigFiles []string, output string) error {
+	if output != dryRunOutputText && output != dryRunOutputJSON {
+		return errors.Errorf("unsupported dry-run output format: '%s'", output)
+	}
+
+	sealed, err := v.Sealed()
+	if err != nil {
+		return errors.Wrap(err, "error checking if vault is sealed")
+	}
+	if sealed {
+		return errors.New("vault is sealed, can't compare configuration with the live state")
+	}
+
+	config := parseConfiguration(vaultConfigFiles)
+
+	plan, err := v.Plan(ctx, config)
+	if err != nil {
+		return errors.Wrapf(err, "error planning config file %s", config.ConfigFileUsed())
+	}
+
+	if output == dryRunOutputJSON {
+		data, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "error marshaling plan")
+		}
+
+		_, err = fmt.Fprintln(os.Stdout, string(data))
+
+		return errors.Wrap(err, "error writing plan")
+	}
+
+	return plan.WriteText(os.Stdout)
+}
+
+func handleConfigurationError(ctx context.Context, vaultConfigFiles []string, configurations chan *viper.Viper, sleepTime time.Duration) {
 	// This handler will sleep for a exponential backoff amount of time and re-inject the failed configuration into the
 	// configurations channel to be re-applied to vault
 	// Eventually consistent model - all recovarable errors (5xx and configs that depend on other configs) will be eventually fixed
 	// non recovarable errors will be retried and keep failing every MAX BACKOFF seconds, increasing the error counters ont he vault-configurator pod.
-	logrus.Infof("Failed applying configuration file: %s , sleeping for %s before trying again", vaultConfigFile, sleepTime)
-	time.Sleep(sleepTime)
-	configurations <- parseConfiguration(vaultConfigFile)
+	logrus.Infof("Failed applying configuration files: %s , sleeping for %s before trying again", strings.Join(vaultConfigFiles, ","), sleepTime)
+	if !sleep(ctx, sleepTime) {
+		return
+	}
+
+	queueConfiguration(ctx, configurations, vaultConfigFiles)
+}
+
+// queueConfiguration parses the configuration files and queues them to be applied, unless the context is done
+func queueConfiguration(ctx context.Context, configurations chan *viper.Viper, vaultConfigFiles []string) {
+	select {
+	case <-ctx.Done():
+	case configurations <- parseConfiguration(vaultConfigFiles):
+	}
+}
+
+// sleep waits for the duration, it returns false if the context is done earlier
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
 }
 
-func watchConfigurations(vaultConfigFiles []string, configurations chan *viper.Viper) {
+func watchConfigurations(ctx context.Context, vaultConfigFiles []string, configurations chan *viper.Viper) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		logrus.Fatal(err)
@@ -169,11 +350,17 @@ func watchConfigurations(vaultConfigFiles []string, configurations chan *viper.V
 
 	// Map used to match on kubernetes ..data to files inside of directory
 	configFileDirs := make(map[string][]string)
+	// Config directories are reparsed when any of their files change
+	configDirs := make(map[string]bool)
 
 	for _, vaultConfigFile := range vaultConfigFiles {
 		// we have to watch the entire directory to pick up renames/atomic saves in a cross-platform way
 		configFile := vaultConfigFile
 		configDir, _ := filepath.Split(configFile)
+		if info, err := os.Stat(configFile); err == nil && info.IsDir() {
+			configDir = configFile + "/"
+			configDirs[configFile] = true
+		}
 		configDirTrimmed := strings.TrimRight(configDir, "/")
 
 		files := make([]string, 0)
@@ -193,17 +380,22 @@ func watchConfigurations(vaultConfigFiles []string, configurations chan *viper.V
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case event := <-watcher.Events:
 			// we only care about the config file or the ConfigMap directory (if in Kubernetes)
 			// For real Files we only need to watch the WRITE Event # TODO: Sometimes it triggers 2 WRITE when a file is edited and saved
 			// For Kubernetes configMaps we need to watch for CREATE on the "..data"
 			if event.Op&fsnotify.Write == fsnotify.Write && stringInSlice(vaultConfigFiles, filepath.Clean(event.Name)) {
 				logrus.Infof("file has changed: %s", event.Name)
-				configurations <- parseConfiguration(filepath.Clean(event.Name))
+				queueConfiguration(ctx, configurations, vaultConfigFiles)
+			} else if configDirs[filepath.Dir(event.Name)] && !strings.HasPrefix(filepath.Base(event.Name), ".") {
+				logrus.Infof("file has changed in config directory: %s", event.Name)
+				queueConfiguration(ctx, configurations, vaultConfigFiles)
 			} else if event.Op&fsnotify.Create == fsnotify.Create && filepath.Base(event.Name) == "..data" {
-				for _, fileName := range configFileDirs[filepath.Dir(event.Name)] {
-					logrus.Infof("ConfigMap has changed, reparsing: %s", fileName)
-					configurations <- parseConfiguration(fileName)
+				if _, ok := configFileDirs[filepath.Dir(event.Name)]; ok {
+					logrus.Infof("ConfigMap has changed, reparsing: %s", filepath.Dir(event.Name))
+					queueConfiguration(ctx, configurations, vaultConfigFiles)
 				}
 			}
 		case err := <-watcher.Errors:
@@ -212,26 +404,11 @@ func watchConfigurations(vaultConfigFiles []string, configurations chan *viper.V
 	}
 }
 
-func parseConfiguration(vaultConfigFile string) *viper.Viper {
-	config := viper.New()
-
-	vaultConfig, err := ioutil.ReadFile(vaultConfigFile)
-	if err != nil {
-		logrus.Fatalf("error reading vault config template: %s", err.Error())
-	}
-
-	templater := configuration.NewTemplater(configuration.DefaultLeftDelimiter, configuration.DefaultRightDelimiter)
-
-	buffer, err := templater.EnvTemplate(string(vaultConfig))
+// parseConfiguration loads the configuration files merged into a single configuration
+func parseConfiguration(vaultConfigFiles []string) *viper.Viper {
+	config, err := internalVault.LoadConfig(vaultConfigFiles...)
 	if err != nil {
-		logrus.Fatalf("error executing vault config template: %s", err.Error())
-	}
-
-	config.SetConfigFile(vaultConfigFile)
-
-	err = config.ReadConfig(buffer)
-	if err != nil {
-		logrus.Fatalf("error parsing vault config file: %s", err.Error())
+		logrus.Fatalf("error loading vault config: %s", err.Error())
 	}
 
 	return config
@@ -248,8 +425,16 @@ func stringInSlice(list []string, match string) bool {
 
 func init() {
 	configBoolVar(configureCmd, cfgFatal, false, "Make configuration errors fatal to the configurator")
-	configStringSliceVar(configureCmd, cfgVaultConfigFile, []string{internalVault.DefaultConfigFile}, "The filename of the YAML/JSON Vault configuration")
-	configBoolVar(configureCmd, cfgDisableMetrics, false, "Disable configurer metrics")
+	configStringSliceVar(configureCmd, cfgVaultConfigFile, []string{internalVault.DefaultConfigFile}, "The filenames of the YAML/JSON (or other viper supported format) Vault configuration, or directories of YAML/JSON files, all merged into a single configuration")
+	configBoolVar(configureCmd, cfgDisableMetrics, false, "Disable configurer metrics and health check endpoints")
+	configBoolVar(configureCmd, cfgDryRun, false, "Print the changes the configuration would make to Vault without applying them")
+	configStringVar(configureCmd, cfgDryRunOutput, dryRunOutputText, fmt.Sprintf("Output format of the dry-run plan ('%s' or '%s')", dryRunOutputText, dryRunOutputJSON))
+
+	configDurationVar(configureCmd, cfgReconcileInterval, 0, "How often to compare the configuration with the live state of Vault to detect drift, 0 disables drift detection")
+	configBoolVar(configureCmd, cfgReconcileAutoCorrect, false, "Apply the configuration again when drift is detected")
+	configDurationVar(configureCmd, cfgRotationCheckInterval, time.Hour, "How often to check the secret engine credentials with a rotation_period for being due for rotation, 0 disables the periodic rotation")
+
+	configLeaderElectionVars()
 
 	rootCmd.AddCommand(configureCmd)
 }
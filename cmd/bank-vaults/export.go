@@ -0,0 +1,96 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	internalVault "github.com/banzaicloud/bank-vaults/internal/vault"
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/sdk/vault"
+)
+
+const cfgExportFile = "export-file"
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the configuration of a running Vault into the YAML format used by configure",
+	Long: `It reads the auth methods (with their roles and config), policies, secrets engines
+(with their config and roles), audit devices, identity groups and group aliases from Vault
+and prints them in the same format as the configure command expects.
+
+The token in the VAULT_TOKEN environment variable is used if set, otherwise the root token
+is read from the configured key store. Values which Vault never returns (passwords, secret keys,
+JWTs) are missing from the export, add them manually before applying it with configure.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		exportFile := c.GetString(cfgExportFile)
+
+		cl, err := vault.NewRawClient()
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		var store kv.Service
+		if cl.Token() == "" {
+			store, err = kvStoreForConfig(c)
+			if err != nil {
+				logrus.Fatalf("error creating kv store: %s", err.Error())
+			}
+		}
+
+		v, err := internalVault.New(store, cl, vaultConfigForConfig(c))
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		sealed, err := v.Sealed()
+		if err != nil {
+			logrus.Fatalf("error checking if vault is sealed: %s", err.Error())
+		}
+		if sealed {
+			logrus.Fatal("vault is sealed, can't export its configuration")
+		}
+
+		exported, err := v.Export(cmd.Context())
+		if err != nil {
+			logrus.Fatalf("error exporting vault configuration: %s", err.Error())
+		}
+
+		data, err := yaml.Marshal(exported)
+		if err != nil {
+			logrus.Fatalf("error marshaling vault configuration: %s", err.Error())
+		}
+
+		if exportFile == "" {
+			_, err = os.Stdout.Write(data)
+		} else {
+			err = ioutil.WriteFile(exportFile, data, 0600)
+		}
+		if err != nil {
+			logrus.Fatalf("error writing vault configuration: %s", err.Error())
+		}
+	},
+}
+
+func init() {
+	configStringVar(exportCmd, cfgExportFile, "", "The filename to write the exported configuration to (defaults to stdout)")
+
+	rootCmd.AddCommand(exportCmd)
+}
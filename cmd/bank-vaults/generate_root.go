@@ -0,0 +1,64 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	internalVault "github.com/banzaicloud/bank-vaults/internal/vault"
+	"github.com/banzaicloud/bank-vaults/pkg/sdk/vault"
+)
+
+const cfgGenerateRootAgeRecipient = "generate-root-age-recipient"
+
+var generateRootCmd = &cobra.Command{
+	Use:   "generate-root",
+	Short: "Rotates the root token of the target Vault instance",
+	Long: `This command will generate a new root token using the unseal keys (or
+recovery keys when Vault is auto-unsealed) stored in the given backend.
+
+The new root token replaces the stored one, which is revoked afterwards.
+The stored keys have to be unencrypted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := kvStoreForConfig(c)
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		cl, err := vault.NewRawClient()
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		config := vaultConfigForConfig(c)
+		config.RootTokenAgeRecipient = c.GetString(cfgGenerateRootAgeRecipient)
+
+		v, err := internalVault.New(store, cl, config)
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		if err = v.GenerateRoot(cmd.Context()); err != nil {
+			logrus.Fatalf("error generating root token: %s", err.Error())
+		}
+	},
+}
+
+func init() {
+	configStringVar(generateRootCmd, cfgGenerateRootAgeRecipient, "", "age recipient (age1...) to encrypt the new root token to before storing it, the stored encrypted root token can't be used by configure, plan and export")
+
+	rootCmd.AddCommand(generateRootCmd)
+}
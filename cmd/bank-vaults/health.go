@@ -0,0 +1,215 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+const (
+	healthKVStoreTimeout = 5 * time.Second
+	healthProbeKey       = "vault-test"
+)
+
+// health tracks the state reported by the /healthz and /readyz endpoints
+var health = &healthState{}
+
+type healthState struct {
+	mu sync.Mutex
+
+	mode  string
+	store kv.Service
+
+	lastUnsealAttempt time.Time
+	lastUnsealError   error
+
+	lastConfigureAttempt time.Time
+	lastConfigureSuccess time.Time
+	lastConfigureError   error
+
+	// nil unless the configurer takes part in a leader election
+	leader *bool
+}
+
+type healthStatus struct {
+	Mode  string `json:"mode"`
+	Ready bool   `json:"ready"`
+
+	KVStoreReachable *bool  `json:"kvStoreReachable,omitempty"`
+	KVStoreError     string `json:"kvStoreError,omitempty"`
+
+	LastUnsealAttempt *time.Time `json:"lastUnsealAttempt,omitempty"`
+	LastUnsealError   string     `json:"lastUnsealError,omitempty"`
+
+	LastConfigureAttempt *time.Time `json:"lastConfigureAttempt,omitempty"`
+	LastConfigureSuccess *time.Time `json:"lastConfigureSuccess,omitempty"`
+	LastConfigureError   string     `json:"lastConfigureError,omitempty"`
+
+	Leader *bool `json:"leader,omitempty"`
+}
+
+// watch sets the mode and the key store (nil if no key store is used) to report on
+func (h *healthState) watch(mode string, store kv.Service) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.mode = mode
+	h.store = store
+}
+
+// unsealAttempted records the result of sending the unseal keys to Vault
+func (h *healthState) unsealAttempted(err error) {
+	h.unsealChecked(err)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	unsealAttempts.WithLabelValues(result).Inc()
+}
+
+// unsealChecked records the result of an unseal loop iteration which didn't need to send keys,
+// e.g. vault was already unsealed or its seal status couldn't be checked
+func (h *healthState) unsealChecked(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastUnsealAttempt = time.Now()
+	h.lastUnsealError = err
+}
+
+func (h *healthState) configureAttempted(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastConfigureAttempt = time.Now()
+	h.lastConfigureError = err
+	if err == nil {
+		h.lastConfigureSuccess = h.lastConfigureAttempt
+	}
+}
+
+// leading records whether the configurer is the elected leader, standby instances are ready
+// as long as the kv store is reachable
+func (h *healthState) leading(leader bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.leader = &leader
+}
+
+// status collects the current state, if probe is set the key store is probed with
+// a read of a test key (a missing key counts as reachable).
+func (h *healthState) status(probe bool) healthStatus {
+	h.mu.Lock()
+	status := healthStatus{
+		Mode:            h.mode,
+		LastUnsealError: errorString(h.lastUnsealError),
+
+		LastConfigureError: errorString(h.lastConfigureError),
+	}
+	if !h.lastUnsealAttempt.IsZero() {
+		lastUnsealAttempt := h.lastUnsealAttempt
+		status.LastUnsealAttempt = &lastUnsealAttempt
+	}
+	if !h.lastConfigureAttempt.IsZero() {
+		lastConfigureAttempt := h.lastConfigureAttempt
+		status.LastConfigureAttempt = &lastConfigureAttempt
+	}
+	if !h.lastConfigureSuccess.IsZero() {
+		lastConfigureSuccess := h.lastConfigureSuccess
+		status.LastConfigureSuccess = &lastConfigureSuccess
+	}
+	if h.leader != nil {
+		leader := *h.leader
+		status.Leader = &leader
+	}
+	store := h.store
+	h.mu.Unlock()
+
+	kvStoreReachable := true
+	if store != nil && probe {
+		err := probeKVStore(store)
+		kvStoreReachable = err == nil
+		status.KVStoreReachable = &kvStoreReachable
+		status.KVStoreError = errorString(err)
+	}
+
+	switch status.Mode {
+	case "unseal":
+		status.Ready = kvStoreReachable && status.LastUnsealAttempt != nil && status.LastUnsealError == ""
+	case "configure":
+		standby := status.Leader != nil && !*status.Leader
+		status.Ready = kvStoreReachable && (standby || status.LastConfigureSuccess != nil && status.LastConfigureError == "")
+	}
+
+	return status
+}
+
+func probeKVStore(store kv.Service) error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := store.Get(healthProbeKey)
+		if kv.IsNotFoundError(err) {
+			err = nil
+		}
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(healthKVStoreTimeout):
+		return errors.Errorf("kv store didn't respond in %s", healthKVStoreTimeout)
+	}
+}
+
+// healthzHandler reports that the process is alive, with the last known state for diagnostics
+func (h *healthState) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthStatus(w, http.StatusOK, h.status(false))
+}
+
+// readyzHandler reports whether the kv store is reachable and the last unseal or configure attempt succeeded
+func (h *healthState) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	status := h.status(true)
+
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	writeHealthStatus(w, code, status)
+}
+
+func writeHealthStatus(w http.ResponseWriter, code int, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
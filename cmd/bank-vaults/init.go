@@ -15,6 +15,11 @@
 package main
 
 import (
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+
+	"emperror.dev/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
@@ -26,6 +31,11 @@ const (
 	cfgInitRootToken   = "init-root-token"
 	cfgStoreRootToken  = "store-root-token"
 	cfgPreFlightChecks = "pre-flight-checks"
+
+	cfgInitPGPKeys               = "init-pgp-keys"
+	cfgInitRootTokenPGPKey       = "init-root-token-pgp-key"
+	cfgInitAgeRecipients         = "init-age-recipients"
+	cfgInitRootTokenAgeRecipient = "init-root-token-age-recipient"
 )
 
 var initCmd = &cobra.Command{
@@ -35,7 +45,11 @@ var initCmd = &cobra.Command{
 run "vault init" against the target Vault instance, before encrypting and
 storing the keys in the given backend.
 
-It will not unseal the Vault instance after initialising.`,
+It will not unseal the Vault instance after initialising.
+
+The key shares can be encrypted to named custodians, one PGP key or age recipient
+per share (in the order of the shares), in which case bank-vaults can't unseal
+the instance on its own anymore, the custodians have to decrypt and submit their shares.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		store, err := kvStoreForConfig(c)
 		if err != nil {
@@ -47,12 +61,30 @@ It will not unseal the Vault instance after initialising.`,
 			logrus.Fatalf("error connecting to vault: %s", err.Error())
 		}
 
-		v, err := internalVault.New(store, cl, vaultConfigForConfig(c))
+		config := vaultConfigForConfig(c)
+
+		config.PGPKeys, err = readPGPKeys(c.GetStringSlice(cfgInitPGPKeys))
+		if err != nil {
+			logrus.Fatalf("error reading PGP keys: %s", err.Error())
+		}
+
+		if rootTokenPGPKey := c.GetString(cfgInitRootTokenPGPKey); rootTokenPGPKey != "" {
+			keys, err := readPGPKeys([]string{rootTokenPGPKey})
+			if err != nil {
+				logrus.Fatalf("error reading root token PGP key: %s", err.Error())
+			}
+			config.RootTokenPGPKey = keys[0]
+		}
+
+		config.AgeRecipients = c.GetStringSlice(cfgInitAgeRecipients)
+		config.RootTokenAgeRecipient = c.GetString(cfgInitRootTokenAgeRecipient)
+
+		v, err := internalVault.New(store, cl, config)
 		if err != nil {
 			logrus.Fatalf("error creating vault helper: %s", err.Error())
 		}
 
-		if err = v.Init(); err != nil {
+		if err = v.Init(cmd.Context()); err != nil {
 			logrus.Fatalf("error initialising vault: %s", err.Error())
 		}
 	},
@@ -63,5 +95,43 @@ func init() {
 	configBoolVar(rootCmd, cfgStoreRootToken, true, "should the root token be stored in the key store")
 	configBoolVar(rootCmd, cfgPreFlightChecks, true, "should the key store be tested first to validate access rights")
 
+	configStringSliceVar(initCmd, cfgInitPGPKeys, nil, "PGP public key files (binary or base64) or keybase:<username> entries, one for each key share, to encrypt the shares with")
+	configStringVar(initCmd, cfgInitRootTokenPGPKey, "", "PGP public key file (binary or base64) or keybase:<username> to encrypt the root token with, the stored encrypted root token can't be used by configure, plan and export")
+	configStringSliceVar(initCmd, cfgInitAgeRecipients, nil, "age recipients (age1...), one for each key share, to encrypt the shares to before storing them")
+	configStringVar(initCmd, cfgInitRootTokenAgeRecipient, "", "age recipient (age1...) to encrypt the root token to before storing it, the stored encrypted root token can't be used by configure, plan and export")
+
 	rootCmd.AddCommand(initCmd)
 }
+
+// readPGPKeys converts PGP public key files to the base64 format expected by Vault,
+// keybase:<username> entries are passed as is.
+func readPGPKeys(paths []string) ([]string, error) {
+	keys := make([]string, 0, len(paths))
+
+	for _, path := range paths {
+		if strings.HasPrefix(path, "keybase:") {
+			keys = append(keys, path)
+
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading PGP key file '%s'", path)
+		}
+
+		if strings.HasPrefix(string(data), "-----BEGIN PGP") {
+			return nil, errors.Errorf("PGP key file '%s' is ASCII armored, export it in binary or base64 format", path)
+		}
+
+		// The file may contain the already base64 encoded key
+		encoded := strings.TrimSpace(string(data))
+		if _, err := base64.StdEncoding.DecodeString(encoded); err != nil {
+			encoded = base64.StdEncoding.EncodeToString(data)
+		}
+
+		keys = append(keys, encoded)
+	}
+
+	return keys, nil
+}
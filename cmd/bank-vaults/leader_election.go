@@ -0,0 +1,170 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	crconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+const (
+	cfgLeaderElect              = "leader-elect"
+	cfgLeaderElectNamespace     = "leader-elect-namespace"
+	cfgLeaderElectLeaseName     = "leader-elect-lease-name"
+	cfgLeaderElectIdentity      = "leader-elect-identity"
+	cfgLeaderElectLeaseDuration = "leader-elect-lease-duration"
+	cfgLeaderElectRenewDeadline = "leader-elect-renew-deadline"
+	cfgLeaderElectRetryPeriod   = "leader-elect-retry-period"
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// runLeaderElected calls run whenever this instance acquires the Lease, the context passed to run is canceled
+// when the leadership is lost. The instance stays a candidate until ctx is done, or until run returns
+// if exitWithLeader is set (e.g. when configuring only once).
+func runLeaderElected(ctx context.Context, cfg *viper.Viper, exitWithLeader bool, run func(ctx context.Context)) error {
+	lock, err := leaseLockForConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	log := logrus.WithFields(logrus.Fields{"lease": lock.Describe(), "identity": lock.Identity()})
+
+	for ctx.Err() == nil {
+		electionCtx, cancel := context.WithCancel(ctx)
+		started := make(chan context.Context, 1)
+
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   cfg.GetDuration(cfgLeaderElectLeaseDuration),
+			RenewDeadline:   cfg.GetDuration(cfgLeaderElectRenewDeadline),
+			RetryPeriod:     cfg.GetDuration(cfgLeaderElectRetryPeriod),
+			ReleaseOnCancel: true,
+			Name:            lock.Identity(),
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) {
+					log.Info("acquired leadership, applying configuration")
+					health.leading(true)
+					configurerLeader.Set(1)
+					started <- leaderCtx
+				},
+				OnStoppedLeading: func() {
+					log.Info("stopped leading")
+					health.leading(false)
+					configurerLeader.Set(0)
+				},
+				OnNewLeader: func(identity string) {
+					if identity != lock.Identity() {
+						log.Infof("standing by, current leader is %s", identity)
+					}
+				},
+			},
+		})
+		if err != nil {
+			cancel()
+
+			return errors.Wrap(err, "error creating leader elector")
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			elector.Run(electionCtx)
+			close(stopped)
+		}()
+
+		log.Info("waiting for leadership...")
+
+		select {
+		case <-stopped:
+			// the leadership was not acquired before ctx was done
+			cancel()
+
+		case leaderCtx := <-started:
+			run(leaderCtx)
+			finished := leaderCtx.Err() == nil
+
+			// release the Lease and wait for the elector to stop before standing for election again
+			cancel()
+			<-stopped
+
+			if finished && exitWithLeader {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+func leaseLockForConfig(cfg *viper.Viper) (*resourcelock.LeaseLock, error) {
+	namespace := cfg.GetString(cfgLeaderElectNamespace)
+	if namespace == "" {
+		namespaceBytes, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading k8s namespace, set --%s", cfgLeaderElectNamespace)
+		}
+		namespace = strings.TrimSpace(string(namespaceBytes))
+	}
+
+	identity := cfg.GetString(cfgLeaderElectIdentity)
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading hostname, set --%s", cfgLeaderElectIdentity)
+		}
+		identity = hostname
+	}
+
+	k8sConfig, err := crconfig.GetConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating k8s config")
+	}
+
+	client, err := kubernetes.NewForConfig(k8sConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating k8s client")
+	}
+
+	return &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      cfg.GetString(cfgLeaderElectLeaseName),
+		},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}, nil
+}
+
+func configLeaderElectionVars() {
+	configBoolVar(configureCmd, cfgLeaderElect, false, "Elect a leader with a Kubernetes Lease, so only one of the configurer replicas applies the configuration")
+	configStringVar(configureCmd, cfgLeaderElectNamespace, "", "Namespace of the leader election Lease, defaults to the namespace of the service account")
+	configStringVar(configureCmd, cfgLeaderElectLeaseName, "bank-vaults-configurer", "Name of the leader election Lease")
+	configStringVar(configureCmd, cfgLeaderElectIdentity, "", "Identity of this instance in the leader election, defaults to the hostname")
+	configDurationVar(configureCmd, cfgLeaderElectLeaseDuration, 15*time.Second, "Duration the standby instances wait before taking over the leadership")
+	configDurationVar(configureCmd, cfgLeaderElectRenewDeadline, 10*time.Second, "Duration the leader retries to renew the leadership before giving it up")
+	configDurationVar(configureCmd, cfgLeaderElectRetryPeriod, 2*time.Second, "Duration the leader election clients wait between actions")
+}
@@ -15,8 +15,10 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -47,6 +49,12 @@ const (
 	cfgModeValueHSM               = "hsm"
 	cfgModeValueDev               = "dev"
 	cfgModeValueFile              = "file"
+	cfgModeValueLocalCryptK8S     = "localcrypt-k8s"
+	cfgModeValueLocalCryptFile    = "localcrypt-file"
+	cfgModeValueShamir            = "shamir"
+	cfgModeValueTransitK8S        = "transit-k8s"
+	cfgModeValueTransitS3         = "transit-s3"
+	cfgModeValueSQL               = "sql"
 )
 
 const (
@@ -73,6 +81,12 @@ const (
 	cfgAWS3SSEAlgo = "aws-s3-sse-algo"
 )
 
+const (
+	cfgMultiQuorumReads    = "multi-quorum-reads"
+	cfgMultiRepair         = "multi-repair"
+	cfgMultiRepairInterval = "multi-repair-interval"
+)
+
 const cfgAzureKeyVaultName = "azure-key-vault-name"
 
 const (
@@ -110,11 +124,39 @@ const (
 
 const cfgFilePath = "file-path"
 
+const (
+	cfgSQLDriver = "sql-driver"
+	cfgSQLDSN    = "sql-dsn"
+	cfgSQLTable  = "sql-table"
+)
+
+const (
+	cfgTransitMountPath = "transit-mount-path"
+	cfgTransitKeyName   = "transit-key-name"
+)
+
+const (
+	cfgShamirThreshold = "shamir-threshold"
+	cfgShamirBackends  = "shamir-backends"
+)
+
+const cfgKVTimeout = "kv-timeout"
+
+const (
+	cfgLocalCryptPassphrase = "localcrypt-passphrase"
+	cfgLocalCryptKeyFile    = "localcrypt-key-file"
+)
+
 const (
 	cfgUnsealPeriod = "unseal-period"
 	cfgOnce         = "once"
+
+	cfgListenAddress = "listen-address"
 )
 
+// shutdownTimeout is the time the HTTP servers get to finish the requests in progress on shutdown
+const shutdownTimeout = 5 * time.Second
+
 // We need to pre-create a value and bind the the flag to this until
 // https://github.com/spf13/viper/issues/608 gets fixed.
 var k8sSecretLabels map[string]string
@@ -126,20 +168,53 @@ var rootCmd = &cobra.Command{
 }
 
 func execute() {
-	// Handle signals to prevent bad exit codes on `docker stop`.
-	// TODO: probably a more sophisticated exit procedure should be implemented in the future.
-	sigs := make(chan os.Signal, 1)
+	// The first signal cancels the context of the command, so the key store and Vault calls
+	// in progress are canceled and the command can exit cleanly on `docker stop`.
+	// A second signal exits immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 2)
 	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGABRT)
 	go func() {
+		sig := <-sigs
+		logrus.Infof("received %s, shutting down...", sig)
+		cancel()
+
 		<-sigs
-		os.Exit(0)
+		logrus.Warn("received another signal, exiting immediately")
+		os.Exit(1)
 	}()
 
-	if err := rootCmd.Execute(); err != nil {
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		logrus.Fatalf("error executing command: %s", err.Error())
 	}
 }
 
+// listenAndServe serves on the server until the context is done, then shuts it down gracefully.
+// TLS is used if a certificate or a key file is set.
+func listenAndServe(ctx context.Context, server *http.Server, certFile, keyFile string) error {
+	errs := make(chan error, 1)
+	go func() {
+		if certFile != "" || keyFile != "" {
+			errs <- server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return server.Shutdown(shutdownCtx)
+}
+
 func configBoolVar(cmd *cobra.Command, key string, defaultValue bool, description string) {
 	cmd.PersistentFlags().Bool(key, defaultValue, description)
 	_ = c.BindPFlag(key, cmd.PersistentFlags().Lookup(key))
@@ -176,10 +251,20 @@ func init() {
 	c.SetEnvKeyReplacer(replacer)
 	c.AutomaticEnv()
 
+	configKVStoreVars(rootCmd, "", &k8sSecretLabels)
+
+	// Misc common flags
+	configBoolVar(rootCmd, cfgOnce, false, "Run configure/unsela only once")
+	configStringVar(rootCmd, cfgListenAddress, ":9091", "Listen address of the metrics and the /healthz, /readyz endpoints")
+	configDurationVar(configureCmd, cfgUnsealPeriod, time.Second*5, "How often to attempt to unseal the Vault instance")
+}
+
+// configKVStoreVars registers the flags read by kvStoreForConfig on the command, every flag name is prefixed with prefix.
+func configKVStoreVars(cmd *cobra.Command, prefix string, k8sLabels *map[string]string) {
 	// Select mode
 	configStringVar(
-		rootCmd,
-		cfgMode,
+		cmd,
+		prefix+cfgMode,
 		cfgModeValueK8S,
 		fmt.Sprintf(`Select the mode to use:
 						'%s' => Google Cloud Storage using Google KMS encryption;
@@ -191,7 +276,13 @@ func init() {
 						'%s' => Kubernetes Secrets encrypted with HSM;
 						'%s' => HSM object on device, using HSM encryption;
 						'%s' => Dev (vault server -dev) mode
-						'%s' => File mode`,
+						'%s' => File mode
+						'%s' => Kubernetes Secrets encrypted with a local passphrase or age key;
+						'%s' => File mode encrypted with a local passphrase or age key;
+						'%s' => Shamir secret sharing across multiple backends;
+						'%s' => Kubernetes Secrets encrypted with the transit engine of a remote Vault;
+						'%s' => AWS S3 Object Storage encrypted with the transit engine of a remote Vault;
+						'%s' => SQL database table (PostgreSQL or MySQL)`,
 			cfgModeValueGoogleCloudKMSGCS,
 			cfgModeValueAWSKMS3,
 			cfgModeValueAzureKeyVault,
@@ -202,75 +293,101 @@ func init() {
 			cfgModeValueHSM,
 			cfgModeValueDev,
 			cfgModeValueFile,
+			cfgModeValueLocalCryptK8S,
+			cfgModeValueLocalCryptFile,
+			cfgModeValueShamir,
+			cfgModeValueTransitK8S,
+			cfgModeValueTransitS3,
+			cfgModeValueSQL,
 		),
 	)
 
 	// Secret config
-	configIntVar(rootCmd, cfgSecretShares, 5, "Total count of secret shares that exist")
-	configIntVar(rootCmd, cfgSecretThreshold, 3, "Minimum required secret shares to unseal")
+	configIntVar(cmd, prefix+cfgSecretShares, 5, "Total count of secret shares that exist")
+	configIntVar(cmd, prefix+cfgSecretThreshold, 3, "Minimum required secret shares to unseal")
 
 	// Google Cloud KMS flags
-	configStringVar(rootCmd, cfgGoogleCloudKMSProject, "", "The Google Cloud KMS project to use")
-	configStringVar(rootCmd, cfgGoogleCloudKMSLocation, "", "The Google Cloud KMS location to use (eg. 'global', 'europe-west1')")
-	configStringVar(rootCmd, cfgGoogleCloudKMSKeyRing, "", "The name of the Google Cloud KMS key ring to use")
-	configStringVar(rootCmd, cfgGoogleCloudKMSCryptoKey, "", "The name of the Google Cloud KMS crypt key to use")
+	configStringVar(cmd, prefix+cfgGoogleCloudKMSProject, "", "The Google Cloud KMS project to use")
+	configStringVar(cmd, prefix+cfgGoogleCloudKMSLocation, "", "The Google Cloud KMS location to use (eg. 'global', 'europe-west1')")
+	configStringVar(cmd, prefix+cfgGoogleCloudKMSKeyRing, "", "The name of the Google Cloud KMS key ring to use")
+	configStringVar(cmd, prefix+cfgGoogleCloudKMSCryptoKey, "", "The name of the Google Cloud KMS crypt key to use")
 
 	// Google Cloud Storage flags
-	configStringVar(rootCmd, cfgGoogleCloudStorageBucket, "", "The name of the Google Cloud Storage bucket to store values in")
-	configStringVar(rootCmd, cfgGoogleCloudStoragePrefix, "", "The prefix to use for values store in Google Cloud Storage")
+	configStringVar(cmd, prefix+cfgGoogleCloudStorageBucket, "", "The name of the Google Cloud Storage bucket to store values in")
+	configStringVar(cmd, prefix+cfgGoogleCloudStoragePrefix, "", "The prefix to use for values store in Google Cloud Storage")
 
 	// AWS KMS flags
-	configStringSliceVar(rootCmd, cfgAWSKMSRegion, nil, "The region of the AWS KMS key to encrypt values")
-	configStringSliceVar(rootCmd, cfgAWSKMSKeyID, nil, "The ID or ARN of the AWS KMS key to encrypt values")
+	configStringSliceVar(cmd, prefix+cfgAWSKMSRegion, nil, "The region of the AWS KMS key to encrypt values")
+	configStringSliceVar(cmd, prefix+cfgAWSKMSKeyID, nil, "The ID or ARN of the AWS KMS key to encrypt values")
 
 	// AWS S3 Object Storage flags
-	configStringSliceVar(rootCmd, cfgAWSS3Region, []string{"us-east-1"}, "The region to use for storing values in AWS S3")
-	configStringSliceVar(rootCmd, cfgAWSS3Bucket, nil, "The name of the AWS S3 bucket to store values in")
-	configStringVar(rootCmd, cfgAWSS3Prefix, "", "The prefix to use for storing values in AWS S3")
-	configStringSliceVar(rootCmd, cfgAWS3SSEAlgo, []string{""}, "The algorithm to use for the S3 SSE")
+	configStringSliceVar(cmd, prefix+cfgAWSS3Region, []string{"us-east-1"}, "The region to use for storing values in AWS S3")
+	configStringSliceVar(cmd, prefix+cfgAWSS3Bucket, nil, "The name of the AWS S3 bucket to store values in")
+	configStringVar(cmd, prefix+cfgAWSS3Prefix, "", "The prefix to use for storing values in AWS S3")
+	configStringSliceVar(cmd, prefix+cfgAWS3SSEAlgo, []string{""}, "The algorithm to use for the S3 SSE")
+
+	// Multiple AWS S3 buckets flags
+	configBoolVar(cmd, prefix+cfgMultiQuorumReads, false, "Read values from all AWS S3 buckets and use the value held by the majority of them")
+	configBoolVar(cmd, prefix+cfgMultiRepair, false, "Write the majority value back to the AWS S3 buckets with a stale or missing value during quorum reads")
+	configDurationVar(cmd, prefix+cfgMultiRepairInterval, 0, "How often to read every key from all AWS S3 buckets and write the majority value back to the stale or missing ones in unseal mode (0 disables it)")
 
 	// Azure Key Vault flags
-	configStringVar(rootCmd, cfgAzureKeyVaultName, "", "The name of the Azure Key Vault to encrypt and store values in")
+	configStringVar(cmd, prefix+cfgAzureKeyVaultName, "", "The name of the Azure Key Vault to encrypt and store values in")
 
 	// Alibaba Access Key flags
-	configStringVar(rootCmd, cfgAlibabaAccessKeyID, "", "The Alibaba AccessKeyID to use")
-	configStringVar(rootCmd, cfgAlibabaAccessKeySecret, "", "The Alibaba AccessKeySecret to use")
+	configStringVar(cmd, prefix+cfgAlibabaAccessKeyID, "", "The Alibaba AccessKeyID to use")
+	configStringVar(cmd, prefix+cfgAlibabaAccessKeySecret, "", "The Alibaba AccessKeySecret to use")
 
 	// Alibaba KMS flags
-	configStringVar(rootCmd, cfgAlibabaKMSRegion, "", "The region where the Alibaba KMS key relies")
-	configStringVar(rootCmd, cfgAlibabaKMSKeyID, "", "The ID of the Alibaba KMS key to encrypt values")
+	configStringVar(cmd, prefix+cfgAlibabaKMSRegion, "", "The region where the Alibaba KMS key relies")
+	configStringVar(cmd, prefix+cfgAlibabaKMSKeyID, "", "The ID of the Alibaba KMS key to encrypt values")
 
 	// Alibaba Object Storage Service flags
-	configStringVar(rootCmd, cfgAlibabaOSSEndpoint, "", "The name of the Alibaba OSS endpoint to store values in")
-	configStringVar(rootCmd, cfgAlibabaOSSBucket, "", "The name of the Alibaba OSS bucket to store values in")
-	configStringVar(rootCmd, cfgAlibabaOSSPrefix, "", "The prefix to use for values store in Alibaba OSS")
+	configStringVar(cmd, prefix+cfgAlibabaOSSEndpoint, "", "The name of the Alibaba OSS endpoint to store values in")
+	configStringVar(cmd, prefix+cfgAlibabaOSSBucket, "", "The name of the Alibaba OSS bucket to store values in")
+	configStringVar(cmd, prefix+cfgAlibabaOSSPrefix, "", "The prefix to use for values store in Alibaba OSS")
 
 	// Vault Service Flags
-	configStringVar(rootCmd, cfgVaultAddress, "", "The URL of the remote Vault to use as KV. Example: https://vault.myvault.org:8200")
-	configStringVar(rootCmd, cfgVaultUnsealKeysPath, "", "Path at the remote URL to store Unseal Keys")
-	configStringVar(rootCmd, cfgVaultRole, "", "Vault Role to authenticate as")
-	configStringVar(rootCmd, cfgVaultAuthPath, "", "Auth path for Kubernetes auth type")
-	configStringVar(rootCmd, cfgVaultTokenPath, "", "Path to file containing Vault token")
-	configStringVar(rootCmd, cfgVaultToken, "", "Vault token")
+	configStringVar(cmd, prefix+cfgVaultAddress, "", "The URL of the remote Vault to use as KV. Example: https://vault.myvault.org:8200")
+	configStringVar(cmd, prefix+cfgVaultUnsealKeysPath, "", "Path at the remote URL to store Unseal Keys")
+	configStringVar(cmd, prefix+cfgVaultRole, "", "Vault Role to authenticate as")
+	configStringVar(cmd, prefix+cfgVaultAuthPath, "", "Auth path for Kubernetes auth type")
+	configStringVar(cmd, prefix+cfgVaultTokenPath, "", "Path to file containing Vault token")
+	configStringVar(cmd, prefix+cfgVaultToken, "", "Vault token")
+
+	// Vault transit flags, the remote Vault is configured with the Vault Service Flags
+	configStringVar(cmd, prefix+cfgTransitMountPath, "transit", "The mount path of the transit secrets engine in the remote Vault")
+	configStringVar(cmd, prefix+cfgTransitKeyName, "", "The name of the transit key in the remote Vault to encrypt values with")
 
 	// K8S Secret Storage flags
-	configStringVar(rootCmd, cfgK8SNamespace, "", "The namespace of the K8S Secret to store values in")
-	configStringVar(rootCmd, cfgK8SSecret, "", "The name of the K8S Secret to store values in")
-	configStringMapVar(rootCmd, cfgK8SLabels, &k8sSecretLabels, "The labels of the K8S Secret to store values in")
+	configStringVar(cmd, prefix+cfgK8SNamespace, "", "The namespace of the K8S Secret to store values in")
+	configStringVar(cmd, prefix+cfgK8SSecret, "", "The name of the K8S Secret to store values in")
+	configStringMapVar(cmd, prefix+cfgK8SLabels, k8sLabels, "The labels of the K8S Secret to store values in")
 
 	// HSM flags
-	configStringVar(rootCmd, cfgHSMModulePath, "", "The library path of the HSM device")
-	configIntVar(rootCmd, cfgHSMSlotID, 0, "The ID of the HSM slot")
-	configStringVar(rootCmd, cfgHSMTokenLabel, "", "The label of the token in a HSM slot")
-	configStringVar(rootCmd, cfgHSMPin, "", "The pin of the HSM token to login with")
-	configStringVar(rootCmd, cfgHSMKeyLabel, "bank-vaults", "The label of the HSM private key")
+	configStringVar(cmd, prefix+cfgHSMModulePath, "", "The library path of the HSM device")
+	configIntVar(cmd, prefix+cfgHSMSlotID, 0, "The ID of the HSM slot")
+	configStringVar(cmd, prefix+cfgHSMTokenLabel, "", "The label of the token in a HSM slot")
+	configStringVar(cmd, prefix+cfgHSMPin, "", "The pin of the HSM token to login with")
+	configStringVar(cmd, prefix+cfgHSMKeyLabel, "bank-vaults", "The label of the HSM private key")
 
 	// File flags
-	configStringVar(rootCmd, cfgFilePath, "", "The path prefix of the files where to store values in")
+	configStringVar(cmd, prefix+cfgFilePath, "", "The path prefix of the files where to store values in")
 
-	// Misc common flags
-	configBoolVar(rootCmd, cfgOnce, false, "Run configure/unsela only once")
-	configDurationVar(configureCmd, cfgUnsealPeriod, time.Second*5, "How often to attempt to unseal the Vault instance")
+	// SQL flags
+	configStringVar(cmd, prefix+cfgSQLDriver, "postgres", "The SQL database driver to use ('postgres' or 'mysql')")
+	configStringVar(cmd, prefix+cfgSQLDSN, "", "The data source name of the SQL database to store values in")
+	configStringVar(cmd, prefix+cfgSQLTable, "bank_vaults", "The name of the SQL table to store values in, it's created if missing")
+
+	// Local encryption flags
+	configStringVar(cmd, prefix+cfgLocalCryptPassphrase, "", "The passphrase protecting the local data encryption key")
+	configStringVar(cmd, prefix+cfgLocalCryptKeyFile, "", "The age X25519 key file (see age-keygen) protecting the local data encryption key")
+
+	// Shamir flags
+	configIntVar(cmd, prefix+cfgShamirThreshold, 2, "Minimum required backends to reconstruct a value split with Shamir's secret sharing")
+	configStringVar(cmd, prefix+cfgShamirBackends, "", "The YAML file listing the configuration of each backend storing a Shamir share, with the flag names as keys")
+
+	configDurationVar(cmd, prefix+cfgKVTimeout, 0, "Timeout of a single key store read or write on the backends supporting cancellation, it applies per key store so with the multi and shamir modes it covers all backends of the operation together, 0 means no timeout")
 }
 
 func main() {
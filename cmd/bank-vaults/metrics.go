@@ -15,13 +15,17 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 
 	internalVault "github.com/banzaicloud/bank-vaults/internal/vault"
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/multi"
 )
 
 const prometheusNS = "vault"
@@ -54,11 +58,51 @@ var (
 		"Number of configurations files applied that failed",
 		nil, nil,
 	)
+
+	unsealAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNS,
+		Subsystem: "unseal",
+		Name:      "attempts_total",
+		Help:      "Number of unseal attempts by result.",
+	}, []string{"result"})
+	kvOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: prometheusNS,
+		Subsystem: "kv",
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of the key store operations by backend mode.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"backend", "operation", "result"})
+	configureDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: prometheusNS,
+		Subsystem: "config",
+		Name:      "duration_seconds",
+		Help:      "Time spent applying a configuration file by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"result"})
+	configDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: prometheusNS,
+		Subsystem: "config",
+		Name:      "drift",
+		Help:      "Resources of the configuration which differ from the live state of Vault by action.",
+	}, []string{"config_file", "namespace", "section", "name", "action"})
+	reconciliations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNS,
+		Subsystem: "config",
+		Name:      "reconciliations_total",
+		Help:      "Number of drift checks of a configuration file by result.",
+	}, []string{"result"})
+	configurerLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: prometheusNS,
+		Subsystem: "config",
+		Name:      "leader",
+		Help:      "Is this configurer instance the leader applying the configuration.",
+	})
 )
 
 type prometheusExporter struct {
-	Vault internalVault.Vault
-	Mode  string
+	Vault   internalVault.Vault
+	Mode    string
+	Address string
 }
 
 func (e *prometheusExporter) Describe(ch chan<- *prometheus.Desc) {
@@ -112,11 +156,115 @@ func (e *prometheusExporter) Collect(ch chan<- prometheus.Metric) {
 	}
 }
 
-func (e prometheusExporter) Run() error {
+// Run serves the metrics and the health check endpoints on the address until an error occurs or the context is done
+func (e prometheusExporter) Run(ctx context.Context) error {
 	metricsPath := "/metrics"
-	metricsAddr := ":9091"
-	logrus.Infof("vault metrics exporter enabled: %s%s", metricsAddr, metricsPath)
-	prometheus.MustRegister(&e)
+	logrus.Infof("vault metrics exporter enabled: %s%s", e.Address, metricsPath)
+	prometheus.MustRegister(&e, unsealAttempts, kvOperationDuration, configureDuration, configurerLeader, configDrift, reconciliations)
+	if err := multi.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		return err
+	}
+	if err := internalVault.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		return err
+	}
 	http.DefaultServeMux.Handle(metricsPath, promhttp.Handler())
-	return http.ListenAndServe(metricsAddr, http.DefaultServeMux)
+	http.DefaultServeMux.HandleFunc("/healthz", health.healthzHandler)
+	http.DefaultServeMux.HandleFunc("/readyz", health.readyzHandler)
+
+	server := &http.Server{
+		Addr:              e.Address,
+		Handler:           http.DefaultServeMux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return listenAndServe(ctx, server, "", "")
+}
+
+// instrumentedKVStore records the latency of the operations of a key store,
+// and bounds the Get and Set operations with the timeout if it is set
+type instrumentedKVStore struct {
+	store   kv.Service
+	mode    string
+	timeout time.Duration
+}
+
+var (
+	_ kv.Service        = &instrumentedKVStore{}
+	_ kv.ContextService = &instrumentedKVStore{}
+	_ multi.Repairer    = &instrumentedKVStore{}
+)
+
+func newInstrumentedKVStore(store kv.Service, mode string, timeout time.Duration) kv.Service {
+	return &instrumentedKVStore{store: store, mode: mode, timeout: timeout}
+}
+
+func (s *instrumentedKVStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+func (s *instrumentedKVStore) observe(operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil && !kv.IsNotFoundError(err) {
+		result = "failure"
+	}
+
+	kvOperationDuration.WithLabelValues(s.mode, operation, result).Observe(time.Since(start).Seconds())
+}
+
+func (s *instrumentedKVStore) Get(key string) ([]byte, error) {
+	return s.GetWithContext(context.Background(), key)
+}
+
+func (s *instrumentedKVStore) GetWithContext(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	start := time.Now()
+	value, err := kv.GetWithContext(ctx, s.store, key)
+	s.observe("get", start, err)
+
+	return value, err // nolint:wrapcheck
+}
+
+func (s *instrumentedKVStore) Set(key string, value []byte) error {
+	return s.SetWithContext(context.Background(), key, value)
+}
+
+func (s *instrumentedKVStore) SetWithContext(ctx context.Context, key string, value []byte) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	start := time.Now()
+	err := kv.SetWithContext(ctx, s.store, key, value)
+	s.observe("set", start, err)
+
+	return err // nolint:wrapcheck
+}
+
+func (s *instrumentedKVStore) List(prefix string) ([]string, error) {
+	start := time.Now()
+	keys, err := kv.List(s.store, prefix)
+	s.observe("list", start, err)
+
+	return keys, err // nolint:wrapcheck
+}
+
+func (s *instrumentedKVStore) Delete(key string) error {
+	start := time.Now()
+	err := kv.Delete(s.store, key)
+	s.observe("delete", start, err)
+
+	return err // nolint:wrapcheck
+}
+
+func (s *instrumentedKVStore) Repair(ctx context.Context, prefix string) error {
+	start := time.Now()
+	err := multi.Repair(ctx, s.store, prefix)
+	s.observe("repair", start, err)
+
+	return err // nolint:wrapcheck
 }
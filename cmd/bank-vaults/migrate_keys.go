@@ -0,0 +1,106 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	internalVault "github.com/banzaicloud/bank-vaults/internal/vault"
+)
+
+const (
+	cfgMigrateFromPrefix   = "from-"
+	cfgMigrateToPrefix     = "to-"
+	cfgMigrateDeleteSource = "delete-source"
+)
+
+// Same workaround as k8sSecretLabels, for the prefixed flags.
+var (
+	migrateFromK8SSecretLabels map[string]string
+	migrateToK8SSecretLabels   map[string]string
+)
+
+var migrateKeysCmd = &cobra.Command{
+	Use:   "migrate-keys",
+	Short: "Migrates the unseal keys and the root token between key stores",
+	Long: `This command copies the unseal keys, recovery keys, the root token and the credential
+rotation state of the secret engines from one key store to another, for example when moving
+from Kubernetes Secrets to a cloud KMS.
+
+Both key stores accept the same flags as the other commands, prefixed with "from-" and "to-",
+e.g. --from-mode k8s --from-k8s-secret-name vault-unseal-keys --to-mode google-cloud-kms-gcs ...
+
+Every key is read back from the destination and compared to the original, the keys are
+removed from the source only if --delete-source is set and the whole migration succeeded.
+The migration fails if an unseal or recovery key is missing while keys with higher indexes exist.
+The credential rotation state is only migrated from the key stores which can list their keys.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fromConfig := kvStoreConfigWithPrefix(cfgMigrateFromPrefix, migrateFromK8SSecretLabels)
+		toConfig := kvStoreConfigWithPrefix(cfgMigrateToPrefix, migrateToK8SSecretLabels)
+
+		if reflect.DeepEqual(fromConfig.AllSettings(), toConfig.AllSettings()) {
+			logrus.Fatal("the source and the destination key store configurations are the same")
+		}
+
+		from, err := kvStoreForConfig(fromConfig)
+		if err != nil {
+			logrus.Fatalf("error creating source kv store: %s", err.Error())
+		}
+
+		to, err := kvStoreForConfig(toConfig)
+		if err != nil {
+			logrus.Fatalf("error creating destination kv store: %s", err.Error())
+		}
+
+		keys, err := internalVault.MigrateKeys(cmd.Context(), from, to, c.GetBool(cfgMigrateDeleteSource))
+		for _, key := range keys {
+			logrus.WithField("key", key).Info("key migrated to the destination key store")
+		}
+		if err != nil {
+			logrus.Fatalf("error migrating keys: %s", err.Error())
+		}
+
+		logrus.Infof("successfully migrated %d keys from %s to %s", len(keys), fromConfig.GetString(cfgMode), toConfig.GetString(cfgMode))
+	},
+}
+
+// kvStoreConfigWithPrefix collects the kv store settings registered with the given prefix into a
+// standalone config which can be passed to kvStoreForConfig.
+func kvStoreConfigWithPrefix(prefix string, k8sLabels map[string]string) *viper.Viper {
+	cfg := viper.New()
+
+	for _, key := range c.AllKeys() {
+		if strings.HasPrefix(key, prefix) {
+			cfg.Set(strings.TrimPrefix(key, prefix), c.Get(key))
+		}
+	}
+
+	cfg.Set(cfgK8SLabels, k8sLabels)
+
+	return cfg
+}
+
+func init() {
+	configKVStoreVars(migrateKeysCmd, cfgMigrateFromPrefix, &migrateFromK8SSecretLabels)
+	configKVStoreVars(migrateKeysCmd, cfgMigrateToPrefix, &migrateToK8SSecretLabels)
+	configBoolVar(migrateKeysCmd, cfgMigrateDeleteSource, false, "Delete the keys from the source key store after a successful migration")
+
+	rootCmd.AddCommand(migrateKeysCmd)
+}
@@ -0,0 +1,98 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	internalVault "github.com/banzaicloud/bank-vaults/internal/vault"
+)
+
+// driftDetector compares the configuration files with the live state of Vault,
+// and reports the differences as log events and metrics
+type driftDetector struct {
+	vault internalVault.Vault
+
+	// the drifted resources last reported per config file, to clear them once they are in sync again
+	reported map[string]map[string]prometheus.Labels
+}
+
+func newDriftDetector(v internalVault.Vault) *driftDetector {
+	return &driftDetector{vault: v, reported: map[string]map[string]prometheus.Labels{}}
+}
+
+// detect returns true if the live state of Vault differs from the configuration
+func (d *driftDetector) detect(ctx context.Context, config *viper.Viper) bool {
+	configFile := config.ConfigFileUsed()
+	log := logrus.WithField("configFile", configFile)
+
+	sealed, err := d.vault.Sealed()
+	if err != nil || sealed {
+		log.Debug("vault is sealed or unreachable, skipping drift detection")
+		reconciliations.WithLabelValues("skipped").Inc()
+
+		return false
+	}
+
+	plan, err := d.vault.Plan(ctx, config)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Errorf("error detecting configuration drift: %s", err.Error())
+			reconciliations.WithLabelValues("failure").Inc()
+		}
+
+		return false
+	}
+
+	reported := make(map[string]prometheus.Labels, len(plan.Items))
+	for _, item := range plan.Items {
+		labels := prometheus.Labels{"config_file": configFile, "namespace": item.Namespace, "section": item.Section, "name": item.Name, "action": string(item.Action)}
+		reported[strings.Join([]string{item.Namespace, item.Section, item.Name, string(item.Action)}, "/")] = labels
+		configDrift.With(labels).Set(1)
+
+		log.WithFields(logrus.Fields{
+			"namespace": item.Namespace,
+			"section":   item.Section,
+			"name":      item.Name,
+			"action":    item.Action,
+			"fields":    item.Fields,
+		}).Warn("configuration drift detected")
+	}
+
+	for key, labels := range d.reported[configFile] {
+		if _, ok := reported[key]; !ok {
+			configDrift.Delete(labels)
+		}
+	}
+	d.reported[configFile] = reported
+
+	if plan.Empty() {
+		log.Debug("no configuration drift detected")
+		reconciliations.WithLabelValues("in_sync").Inc()
+
+		return false
+	}
+
+	add, change, remove := plan.Summary()
+	log.Warnf("live state of vault drifted from the configuration: %d to add, %d to change, %d to remove", add, change, remove)
+	reconciliations.WithLabelValues("drift").Inc()
+
+	return true
+}
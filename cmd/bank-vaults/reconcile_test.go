@@ -0,0 +1,84 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	internalVault "github.com/banzaicloud/bank-vaults/internal/vault"
+)
+
+// fakeVault returns the queued plans, the rest of the interface is not used by the drift detection
+type fakeVault struct {
+	internalVault.Vault
+
+	sealed bool
+	plans  []*internalVault.Plan
+}
+
+func (v *fakeVault) Sealed() (bool, error) {
+	return v.sealed, nil
+}
+
+func (v *fakeVault) Plan(ctx context.Context, config *viper.Viper) (*internalVault.Plan, error) {
+	plan := v.plans[0]
+	v.plans = v.plans[1:]
+
+	return plan, nil
+}
+
+func TestDriftDetector(t *testing.T) {
+	configDrift.Reset()
+
+	policy := internalVault.PlanItem{Section: "policies", Name: "allow_secrets", Action: internalVault.PlanActionChange, Fields: []string{"rules"}}
+	mount := internalVault.PlanItem{Namespace: "team-a", Section: "secrets", Name: "secret/", Action: internalVault.PlanActionAdd}
+
+	v := &fakeVault{plans: []*internalVault.Plan{
+		{Items: []internalVault.PlanItem{policy, mount}},
+		{Items: []internalVault.PlanItem{mount}},
+		{},
+	}}
+	d := newDriftDetector(v)
+
+	config := viper.New()
+	config.SetConfigFile("vault-config.yml")
+
+	assert.True(t, d.detect(context.Background(), config))
+	assert.Len(t, d.reported["vault-config.yml"], 2)
+	assert.Contains(t, d.reported["vault-config.yml"], "/policies/allow_secrets/change")
+	assert.Contains(t, d.reported["vault-config.yml"], "team-a/secrets/secret//add")
+	assert.Equal(t, 2, testutil.CollectAndCount(configDrift))
+
+	// The resources back in sync are cleared from the metrics
+	assert.True(t, d.detect(context.Background(), config))
+	assert.Len(t, d.reported["vault-config.yml"], 1)
+	assert.Contains(t, d.reported["vault-config.yml"], "team-a/secrets/secret//add")
+	assert.Equal(t, 1, testutil.CollectAndCount(configDrift))
+
+	assert.False(t, d.detect(context.Background(), config))
+	assert.Empty(t, d.reported["vault-config.yml"])
+	assert.Equal(t, 0, testutil.CollectAndCount(configDrift))
+
+	// A sealed vault is skipped and keeps the bookkeeping as is
+	v.sealed = true
+	assert.False(t, d.detect(context.Background(), config))
+	assert.Contains(t, d.reported, "vault-config.yml")
+	assert.Empty(t, v.plans)
+}
@@ -0,0 +1,69 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	internalVault "github.com/banzaicloud/bank-vaults/internal/vault"
+	"github.com/banzaicloud/bank-vaults/pkg/sdk/vault"
+)
+
+const cfgRekeyAgeRecipients = "rekey-age-recipients"
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Rotates the unseal keys of the target Vault instance",
+	Long: `This command will generate new unseal keys (or recovery keys when Vault
+is auto-unsealed) using the keys stored in the given backend, with the number of
+shares and threshold configured by --secret-shares and --secret-threshold.
+
+The new keys are staged in the backend before they are activated in Vault,
+then they replace the stored keys.
+
+The new keys can be encrypted to named custodians, one age recipient per share,
+in which case bank-vaults can't unseal the instance on its own anymore. The
+stored keys have to be unencrypted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := kvStoreForConfig(c)
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		cl, err := vault.NewRawClient()
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		config := vaultConfigForConfig(c)
+		config.AgeRecipients = c.GetStringSlice(cfgRekeyAgeRecipients)
+
+		v, err := internalVault.New(store, cl, config)
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		if err = v.Rekey(cmd.Context()); err != nil {
+			logrus.Fatalf("error rekeying vault: %s", err.Error())
+		}
+	},
+}
+
+func init() {
+	configStringSliceVar(rekeyCmd, cfgRekeyAgeRecipients, nil, "age recipients (age1...), one for each new key share, to encrypt the new shares to before storing them")
+
+	rootCmd.AddCommand(rekeyCmd)
+}
@@ -0,0 +1,161 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	json "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	internalVault "github.com/banzaicloud/bank-vaults/internal/vault"
+)
+
+const (
+	cfgCustodianAddress    = "custodian-address"
+	cfgCustodianToken      = "custodian-token"
+	cfgCustodianCACertFile = "custodian-ca-cert-file"
+	cfgShareFile           = "share-file"
+)
+
+var submitShareCmd = &cobra.Command{
+	Use:   "submit-share",
+	Short: "Submits an unseal key share to a bank-vaults unseal process running in custodian mode",
+	Long: `This command reads an unseal key share from the standard input (or from --share-file)
+and submits it to the custodian intake of "bank-vaults unseal --custodian", authenticated
+with the token of the custodian. The unseal progress is printed after the submission.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		share, err := readShare(c.GetString(cfgShareFile))
+		if err != nil {
+			logrus.Fatalf("error reading share: %s", err.Error())
+		}
+
+		status, err := submitShare(c, share)
+		if err != nil {
+			logrus.Fatalf("error submitting share: %s", err.Error())
+		}
+
+		if !status.Sealed {
+			logrus.Info("share accepted, vault is unsealed")
+
+			return
+		}
+
+		logrus.WithField("submitted", status.Submitted).Infof("share accepted, unseal progress %d/%d", status.Progress, status.Threshold)
+	},
+}
+
+// readShare reads the first line of the file, "-" stands for the standard input
+func readShare(path string) (string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", errors.Wrap(err, "error opening share file")
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	share, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", errors.Wrap(err, "error reading share")
+	}
+
+	share = strings.TrimSpace(share)
+	if share == "" {
+		return "", errors.New("the share is empty")
+	}
+
+	return share, nil
+}
+
+func submitShare(cfg *viper.Viper, share string) (*internalVault.CustodianStatus, error) {
+	token := cfg.GetString(cfgCustodianToken)
+	if token == "" {
+		return nil, errors.Errorf("--%s is required", cfgCustodianToken)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	if caCertFile := cfg.GetString(cfgCustodianCACertFile); caCertFile != "" {
+		caCert, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading CA certificate")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("no certificates found in the CA certificate file")
+		}
+
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}
+	}
+
+	body, err := json.Marshal(internalVault.CustodianShareRequest{Key: share})
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding share")
+	}
+
+	url := strings.TrimSuffix(cfg.GetString(cfgCustodianAddress), "/") + internalVault.CustodianSharePath
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating request")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error sending share")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return nil, errors.Errorf("share rejected (%s): %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	var status internalVault.CustodianStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, errors.Wrap(err, "error decoding unseal progress")
+	}
+
+	return &status, nil
+}
+
+func init() {
+	configStringVar(submitShareCmd, cfgCustodianAddress, "http://127.0.0.1:9093", "Address of the custodian share intake")
+	configStringVar(submitShareCmd, cfgCustodianToken, "", "Token of the custodian")
+	configStringVar(submitShareCmd, cfgCustodianCACertFile, "", "CA certificate file to verify the custodian share intake with")
+	configStringVar(submitShareCmd, cfgShareFile, "-", "File to read the share from, - for the standard input")
+
+	rootCmd.AddCommand(submitShareCmd)
+}
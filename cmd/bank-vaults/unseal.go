@@ -15,13 +15,22 @@
 package main
 
 import (
+	"context"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"time"
 
+	"emperror.dev/errors"
+	"github.com/hashicorp/vault/api"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
 
 	internalVault "github.com/banzaicloud/bank-vaults/internal/vault"
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/multi"
 	"github.com/banzaicloud/bank-vaults/pkg/sdk/vault"
 )
 
@@ -32,6 +41,12 @@ const (
 	cfgRaftLeaderAddress = "raft-leader-address"
 	cfgRaftSecondary     = "raft-secondary"
 	cfgRaftHAStorage     = "raft-ha-storage"
+
+	cfgCustodian              = "custodian"
+	cfgCustodianListenAddress = "custodian-listen-address"
+	cfgCustodianTokensFile    = "custodian-tokens-file"
+	cfgCustodianTLSCertFile   = "custodian-tls-cert-file"
+	cfgCustodianTLSKeyFile    = "custodian-tls-key-file"
 )
 
 type unsealCfg struct {
@@ -43,6 +58,7 @@ type unsealCfg struct {
 	raftLeaderAddress string
 	raftSecondary     bool
 	raftHAStorage     bool
+	custodian         bool
 }
 
 var unsealCmd = &cobra.Command{
@@ -54,8 +70,14 @@ from one of the followings:
 - AWS KMS keyring (backed by S3)
 - Azure Key Vault
 - Alibaba KMS (backed by OSS)
-- Kubernetes Secrets (should be used only for development purposes)`,
+- Kubernetes Secrets (should be used only for development purposes)
+
+With --custodian no keys are read from the key store, instead an HTTP endpoint is exposed where
+the key custodians submit their shares one by one (see the submit-share command). The shares are
+passed to Vault as they arrive and are never persisted.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+
 		var unsealConfig unsealCfg
 
 		unsealConfig.unsealPeriod = c.GetDuration(cfgUnsealPeriod)
@@ -66,10 +88,16 @@ from one of the followings:
 		unsealConfig.raftLeaderAddress = c.GetString(cfgRaftLeaderAddress)
 		unsealConfig.raftSecondary = c.GetBool(cfgRaftSecondary)
 		unsealConfig.raftHAStorage = c.GetBool(cfgRaftHAStorage)
+		unsealConfig.custodian = c.GetBool(cfgCustodian)
 
-		store, err := kvStoreForConfig(c)
-		if err != nil {
-			logrus.Fatalf("error creating kv store: %s", err.Error())
+		// The key store is only needed for initialization in custodian mode
+		var store internalVault.KVService
+		if !unsealConfig.custodian || unsealConfig.proceedInit {
+			var err error
+			store, err = kvStoreForConfig(c)
+			if err != nil {
+				logrus.Fatalf("error creating kv store: %s", err.Error())
+			}
 		}
 
 		cl, err := vault.NewRawClient()
@@ -77,14 +105,34 @@ from one of the followings:
 			logrus.Fatalf("error connecting to vault: %s", err.Error())
 		}
 
+		var intake *internalVault.CustodianIntake
+		if unsealConfig.custodian {
+			intake, err = custodianIntakeForConfig(c, cl)
+			if err != nil {
+				logrus.Fatalf("error creating custodian intake: %s", err.Error())
+			}
+
+			go func() {
+				if err := serveCustodianIntake(ctx, c, intake); err != nil {
+					logrus.Fatalf("error serving custodian intake: %s", err.Error())
+				}
+			}()
+		}
+
 		v, err := internalVault.New(store, cl, vaultConfigForConfig(c))
 		if err != nil {
 			logrus.Fatalf("error creating vault helper: %s", err.Error())
 		}
 
-		metrics := prometheusExporter{Vault: v, Mode: "unseal"}
+		health.watch("unseal", store)
+
+		if interval := c.GetDuration(cfgMultiRepairInterval); store != nil && interval > 0 {
+			go repairKVStore(ctx, store, interval)
+		}
+
+		metrics := prometheusExporter{Vault: v, Mode: "unseal", Address: c.GetString(cfgListenAddress)}
 		go func() {
-			err := metrics.Run()
+			err := metrics.Run(ctx)
 			if err != nil {
 				logrus.Fatalf("error creating prometheus exporter: %s", err.Error())
 			}
@@ -93,7 +141,7 @@ from one of the followings:
 		if unsealConfig.proceedInit && unsealConfig.raft {
 			logrus.Info("joining leader vault...")
 
-			initialized, err := v.RaftInitialized()
+			initialized, err := v.RaftInitialized(ctx)
 			if err != nil {
 				sealed, sErr := v.Sealed()
 				if sErr != nil || sealed {
@@ -105,7 +153,7 @@ from one of the followings:
 			// If this is the first instance we have to init it, this happens once in the clusters lifetime
 			if !initialized && !unsealConfig.raftSecondary {
 				logrus.Info("initializing vault...")
-				if err := v.Init(); err != nil {
+				if err := v.Init(ctx); err != nil {
 					logrus.Fatalf("error initializing vault: %s", err.Error())
 				}
 			} else {
@@ -116,15 +164,20 @@ from one of the followings:
 			}
 		} else if unsealConfig.proceedInit {
 			logrus.Info("initializing vault...")
-			if err := v.Init(); err != nil {
+			if err := v.Init(ctx); err != nil {
 				logrus.Fatalf("error initializing vault: %s", err.Error())
 			}
 		}
 
 		raftEstablished := false
+		lastProgress := -1
 		for {
-			if !unsealConfig.auto {
-				unseal(unsealConfig, v)
+			if unsealConfig.custodian {
+				lastProgress = custodianProgress(unsealConfig, intake, lastProgress)
+			} else if !unsealConfig.auto {
+				unseal(ctx, unsealConfig, v)
+			} else {
+				health.unsealChecked(autoUnsealed(v))
 			}
 
 			if unsealConfig.raftHAStorage && !raftEstablished {
@@ -132,16 +185,21 @@ from one of the followings:
 			}
 
 			// wait unsealPeriod before trying again
-			time.Sleep(unsealConfig.unsealPeriod)
+			if !sleep(ctx, unsealConfig.unsealPeriod) {
+				logrus.Info("stopped unsealing vault")
+
+				return
+			}
 		}
 	},
 }
 
-func unseal(unsealConfig unsealCfg, v internalVault.Vault) {
+func unseal(ctx context.Context, unsealConfig unsealCfg, v internalVault.Vault) {
 	logrus.Debug("checking if vault is sealed...")
 	sealed, err := v.Sealed()
 	if err != nil {
 		logrus.Errorf("error checking if vault is sealed: %s", err.Error())
+		health.unsealChecked(errors.Wrap(err, "error checking if vault is sealed"))
 		exitIfNecessary(unsealConfig, 1)
 		return
 	}
@@ -149,23 +207,126 @@ func unseal(unsealConfig unsealCfg, v internalVault.Vault) {
 	// If vault is not sealed, we stop here and wait for another unsealPeriod
 	if !sealed {
 		logrus.Debug("vault is not sealed")
+		health.unsealChecked(nil)
 		exitIfNecessary(unsealConfig, 0)
 		return
 	}
 
 	logrus.Info("vault is sealed, unsealing")
 
-	if err = v.Unseal(); err != nil {
+	if err = v.Unseal(ctx); err != nil {
+		// Interrupted by shutdown, not a failed attempt
+		if ctx.Err() != nil {
+			return
+		}
+
 		logrus.Errorf("error unsealing vault: %s", err.Error())
+		health.unsealAttempted(err)
 		exitIfNecessary(unsealConfig, 1)
 		return
 	}
 
 	logrus.Info("successfully unsealed vault")
+	health.unsealAttempted(nil)
 
 	exitIfNecessary(unsealConfig, 0)
 }
 
+// repairKVStore writes the majority value of every key back to the stale or missing
+// key stores of a multi key store, at startup and then periodically
+func repairKVStore(ctx context.Context, store kv.Service, interval time.Duration) {
+	for {
+		err := multi.Repair(ctx, store, "")
+		if errors.Is(err, kv.ErrNotSupported) {
+			logrus.Errorf("the %s mode doesn't support repairing the key store", c.GetString(cfgMode))
+
+			return
+		}
+		if err != nil {
+			logrus.Warnf("error repairing the key store: %s", err.Error())
+		}
+
+		if !sleep(ctx, interval) {
+			return
+		}
+	}
+}
+
+// autoUnsealed returns an error if vault is still sealed in auto-unseal mode
+func autoUnsealed(v internalVault.Vault) error {
+	sealed, err := v.Sealed()
+	if err != nil {
+		return errors.Wrap(err, "error checking if vault is sealed")
+	}
+	if sealed {
+		return errors.New("vault is sealed, waiting for auto-unseal")
+	}
+
+	return nil
+}
+
+// custodianProgress logs the progress of the custodian unseal whenever it changes
+func custodianProgress(unsealConfig unsealCfg, intake *internalVault.CustodianIntake, lastProgress int) int {
+	status, err := intake.Status()
+	if err != nil {
+		logrus.Errorf("error checking unseal progress: %s", err.Error())
+		health.unsealChecked(err)
+		return lastProgress
+	}
+
+	if !status.Sealed {
+		logrus.Debug("vault is not sealed")
+		health.unsealChecked(nil)
+		exitIfNecessary(unsealConfig, 0)
+		return -1
+	}
+
+	health.unsealChecked(errors.Errorf("waiting for custodians to submit their shares, progress %d/%d", status.Progress, status.Threshold))
+
+	if status.Progress != lastProgress {
+		logrus.WithField("submitted", status.Submitted).Infof("vault is sealed, waiting for custodians to submit their shares, progress %d/%d", status.Progress, status.Threshold)
+	}
+
+	return status.Progress
+}
+
+func custodianIntakeForConfig(cfg *viper.Viper, cl *api.Client) (*internalVault.CustodianIntake, error) {
+	tokensFile := cfg.GetString(cfgCustodianTokensFile)
+	if tokensFile == "" {
+		return nil, errors.Errorf("--%s is required in custodian mode", cfgCustodianTokensFile)
+	}
+
+	data, err := ioutil.ReadFile(tokensFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading custodian tokens file")
+	}
+
+	var tokens map[string]string
+	if err := yaml.Unmarshal(data, &tokens); err != nil {
+		return nil, errors.Wrap(err, "error parsing custodian tokens file")
+	}
+
+	return internalVault.NewCustodianIntake(cl, tokens)
+}
+
+func serveCustodianIntake(ctx context.Context, cfg *viper.Viper, intake *internalVault.CustodianIntake) error {
+	server := &http.Server{
+		Addr:              cfg.GetString(cfgCustodianListenAddress),
+		Handler:           intake,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	certFile, keyFile := cfg.GetString(cfgCustodianTLSCertFile), cfg.GetString(cfgCustodianTLSKeyFile)
+
+	logrus.Infof("custodian share intake listening on %s", server.Addr)
+
+	if certFile == "" && keyFile == "" {
+		logrus.Warn("custodian share intake is served without TLS, expose it only through a secure channel (e.g. kubectl port-forward)")
+	}
+
+	return listenAndServe(ctx, server, certFile, keyFile)
+}
+
 func raftJoin(v internalVault.Vault) bool {
 	leaderAddress, err := v.LeaderAddress()
 	if err != nil {
@@ -201,6 +362,11 @@ func init() {
 	configBoolVar(unsealCmd, cfgStoreRootToken, true, "Should the root token be stored in the key store (only if -init=true)")
 	configBoolVar(unsealCmd, cfgPreFlightChecks, true, "should the key store be tested first to validate access rights")
 	configBoolVar(unsealCmd, cfgAuto, false, "Run in auto-unseal mode")
+	configBoolVar(unsealCmd, cfgCustodian, false, "Wait for key custodians to submit their shares over HTTP instead of reading the keys from the key store")
+	configStringVar(unsealCmd, cfgCustodianListenAddress, "127.0.0.1:9093", "Listen address of the custodian share intake")
+	configStringVar(unsealCmd, cfgCustodianTokensFile, "", "YAML file mapping custodian names to their tokens")
+	configStringVar(unsealCmd, cfgCustodianTLSCertFile, "", "TLS certificate file of the custodian share intake")
+	configStringVar(unsealCmd, cfgCustodianTLSKeyFile, "", "TLS key file of the custodian share intake")
 
 	rootCmd.AddCommand(unsealCmd)
 }
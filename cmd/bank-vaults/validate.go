@@ -0,0 +1,84 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	internalVault "github.com/banzaicloud/bank-vaults/internal/vault"
+)
+
+const cfgPrintSchema = "print-schema"
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [files...]",
+	Short: "Validates Vault configuration files offline",
+	Long: `It checks the YAML/JSON Vault configuration files (or directories of them) used by configure
+against the JSON Schema of the configuration, without connecting to Vault, and reports unknown keys,
+missing required keys, values of the wrong type and unsupported auth method types with their line
+numbers. Template expressions are accepted for any value. The files are merged like configure merges
+them, and conflicting values are reported. The other formats accepted by configure (like TOML) can't
+be validated.
+
+The policies are linted for dangerous rules, which are reported as warnings, and their tests are
+evaluated against their rules.
+
+The JSON Schema itself is printed with --print-schema, to be used by editors.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if c.GetBool(cfgPrintSchema) {
+			data, err := json.MarshalIndent(internalVault.ConfigSchema(), "", "  ")
+			if err != nil {
+				logrus.Fatalf("error marshaling vault configuration schema: %s", err.Error())
+			}
+			fmt.Println(string(data))
+
+			return
+		}
+
+		vaultConfigFiles := args
+		if len(vaultConfigFiles) == 0 {
+			vaultConfigFiles = []string{internalVault.DefaultConfigFile}
+		}
+
+		validationErrors, err := internalVault.ValidateConfig(vaultConfigFiles...)
+		if err != nil {
+			logrus.Fatalf("error validating vault configuration: %s", err.Error())
+		}
+
+		invalid := 0
+		for _, validationError := range validationErrors {
+			fmt.Println(validationError.Error())
+			if !validationError.Warning {
+				invalid++
+			}
+		}
+
+		if invalid > 0 {
+			logrus.Fatalf("vault configuration is invalid, found %d errors", invalid)
+		}
+
+		logrus.Info("vault configuration is valid")
+	},
+}
+
+func init() {
+	configBoolVar(validateCmd, cfgPrintSchema, false, "Print the JSON Schema of the Vault configuration instead of validating files")
+
+	rootCmd.AddCommand(validateCmd)
+}
@@ -4,6 +4,7 @@ require (
 	cloud.google.com/go/kms v1.1.0
 	cloud.google.com/go/storage v1.10.0
 	emperror.dev/errors v0.8.0
+	filippo.io/age v1.0.0
 	github.com/Azure/azure-sdk-for-go v46.4.0+incompatible
 	github.com/Azure/go-autorest/autorest v0.11.12
 	github.com/Azure/go-autorest/autorest/azure/auth v0.5.3
@@ -17,6 +18,7 @@ require (
 	github.com/banzaicloud/k8s-objectmatcher v1.5.0
 	github.com/cristalhq/jwt/v3 v3.0.14
 	github.com/fsnotify/fsnotify v1.5.1
+	github.com/go-sql-driver/mysql v1.6.0
 	github.com/google/go-cmp v0.5.6
 	github.com/google/go-containerregistry v0.5.1
 	github.com/google/go-containerregistry/pkg/authn/k8schain v0.0.0-20210521160948-0233fcda5d53
@@ -26,6 +28,8 @@ require (
 	github.com/imdario/mergo v0.3.12
 	github.com/jpillora/backoff v1.0.0
 	github.com/json-iterator/go v1.1.12
+	github.com/lib/pq v1.10.4
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/miekg/pkcs11 v1.0.3
 	github.com/mitchellh/mapstructure v1.4.3
 	github.com/patrickmn/go-cache v2.1.0+incompatible
@@ -41,6 +45,7 @@ require (
 	golang.org/x/oauth2 v0.0.0-20211104180415-d3ed0bb246c8
 	google.golang.org/api v0.63.0
 	google.golang.org/genproto v0.0.0-20211208223120-3a66f561d7aa
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 	k8s.io/api v0.21.1
 	k8s.io/apiextensions-apiserver v0.21.1
 	k8s.io/apimachinery v0.21.1
@@ -50,6 +55,7 @@ require (
 	k8s.io/utils v0.0.0-20210527160623-6fdb442a123b
 	logur.dev/adapter/logrus v0.5.0
 	sigs.k8s.io/controller-runtime v0.9.0
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace github.com/banzaicloud/bank-vaults/pkg/sdk => ./pkg/sdk
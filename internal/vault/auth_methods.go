@@ -387,7 +387,8 @@ func (v *vault) configureAWSCrossAccountRoles(path string, crossAccountRoles []i
 			return errors.Wrap(err, "error converting cross account aws roles for aws")
 		}
 
-		stsAccount := fmt.Sprint(crossAccountRole["sts_account"])
+		// account IDs are decoded as float64 numbers if they aren't quoted
+		stsAccount := cast.ToString(crossAccountRole["sts_account"])
 
 		_, err = v.writeWithWarningCheck(fmt.Sprintf("auth/%s/config/sts/%s", path, stsAccount), crossAccountRole)
 		if err != nil {
@@ -475,3 +476,109 @@ func (v *vault) configureGenericAuthRoles(method, path, roleSubPath string, role
 
 	return nil
 }
+
+// authRolesPath returns the path where the roles of the given auth method are configured.
+func authRolesPath(authMethod auth) string {
+	switch authMethod.Type {
+	case "kubernetes", "aws", "gcp", "oci", "approle", "jwt", "oidc", "azure":
+		return fmt.Sprintf("auth/%s/role", authMethod.Path)
+	case "cert":
+		return fmt.Sprintf("auth/%s/certs", authMethod.Path)
+	case "token":
+		return "auth/token/roles"
+	default:
+		return ""
+	}
+}
+
+// authConfigPath returns the path where the configuration of the given auth method is stored.
+func authConfigPath(authMethod auth) string {
+	switch authMethod.Type {
+	case "aws":
+		return fmt.Sprintf("auth/%s/config/client", authMethod.Path)
+	case "kubernetes", "github", "gcp", "oci", "jwt", "oidc", "cert", "ldap", "okta", "azure":
+		return fmt.Sprintf("auth/%s/config", authMethod.Path)
+	default:
+		return ""
+	}
+}
+
+func (v *vault) planAuthMethods(plan *Plan, config externalConfig) error {
+	existingAuths, err := v.getExistingAuthMethods()
+	if err != nil {
+		return err
+	}
+
+	for _, authMethod := range config.Auth {
+		if authMethod.Path == "" {
+			authMethod.Path = authMethod.Type
+		}
+
+		if existingAuths[authMethod.Type] == nil {
+			plan.add("auth", authMethod.Path)
+			continue
+		}
+
+		changes, err := v.planAuthMethod(authMethod)
+		if err != nil {
+			return errors.Wrapf(err, "error planning %s auth method", authMethod.Path)
+		}
+
+		if len(changes) > 0 {
+			plan.change("auth", authMethod.Path, changes...)
+		}
+	}
+
+	if config.PurgeUnmanagedConfig.Enabled && !config.PurgeUnmanagedConfig.Exclude.Auths {
+		for authMethod := range v.getUnmanagedAuthMethods(config.Auth) {
+			plan.remove("auth", authMethod)
+		}
+	}
+
+	return nil
+}
+
+func (v *vault) planAuthMethod(authMethod auth) ([]string, error) {
+	var changes []string
+
+	if authMethod.Options != nil {
+		mountConfig, err := v.cl.Sys().MountConfig(fmt.Sprintf("auth/%s", authMethod.Path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s auth method mount config", authMethod.Path)
+		}
+
+		if len(mountConfigChanges(authMethod.Options, *mountConfig)) > 0 {
+			changes = append(changes, "options")
+		}
+	}
+
+	if configPath := authConfigPath(authMethod); configPath != "" && len(authMethod.Config) > 0 {
+		exists, fields, err := v.planPath(configPath, authMethod.Config)
+		if err != nil {
+			return nil, err
+		}
+		if !exists || len(fields) > 0 {
+			changes = append(changes, "config")
+		}
+	}
+
+	if rolesPath := authRolesPath(authMethod); rolesPath != "" {
+		for _, roleInterface := range authMethod.Roles {
+			role, err := cast.ToStringMapE(roleInterface)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error converting roles for %s", authMethod.Type)
+			}
+
+			roleName := cast.ToString(role["name"])
+			exists, fields, err := v.planPath(fmt.Sprintf("%s/%s", rolesPath, roleName), role)
+			if err != nil {
+				return nil, err
+			}
+			if !exists || len(fields) > 0 {
+				changes = append(changes, fmt.Sprintf("role/%s", roleName))
+			}
+		}
+	}
+
+	return changes, nil
+}
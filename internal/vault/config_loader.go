@@ -0,0 +1,478 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
+
+	"github.com/banzaicloud/bank-vaults/internal/configuration"
+)
+
+// configIncludeKey lists further files, directories or glob patterns to load with a configuration file,
+// relative paths are resolved from the directory of the including file.
+const configIncludeKey = "include"
+
+// configListKeys identifies the items of the list sections when the files of a configuration are merged,
+// items with the same key are deep-merged, the items of other lists are concatenated in loading order.
+var configListKeys = map[string]func(item map[string]interface{}) string{
+	"auth":           mountKey,
+	"secrets":        mountKey,
+	"audit":          mountKey,
+	"policies":       nameKey,
+	"groups":         nameKey,
+	"group-aliases":  aliasKey,
+	"entities":       nameKey,
+	"entity-aliases": aliasKey,
+
+	"passwordpolicies": nameKey,
+	"rgp":              nameKey,
+	"egp":              nameKey,
+
+	"namespaces": nameKey,
+	"quotas":     quotaKey,
+}
+
+// configNestedListKeys identifies the items of the list sections within the items of a list section,
+// the namespaces carry the same sections as the root namespace.
+var configNestedListKeys = map[string]map[string]func(item map[string]interface{}) string{
+	"namespaces": configListKeys,
+}
+
+// nestedListKey returns the list key of a key within the item of a top level list section
+func nestedListKey(itemPath, key string) func(item map[string]interface{}) string {
+	i := strings.Index(itemPath, "[")
+	if i < 0 || strings.Count(itemPath, "[") != 1 || !strings.HasSuffix(itemPath, "]") {
+		return nil
+	}
+
+	return configNestedListKeys[itemPath[:i]][strings.ToLower(key)]
+}
+
+func mountKey(item map[string]interface{}) string {
+	path := cast.ToString(item["path"])
+	if path == "" {
+		path = cast.ToString(item["type"])
+	}
+
+	return strings.Trim(path, "/")
+}
+
+func nameKey(item map[string]interface{}) string {
+	return cast.ToString(item["name"])
+}
+
+// quotaKey identifies a quota by its type and name, the same name can be used by both types
+func quotaKey(item map[string]interface{}) string {
+	name := cast.ToString(item["name"])
+	if name == "" {
+		return ""
+	}
+
+	return cast.ToString(item["type"]) + "/" + name
+}
+
+// aliasKey identifies an alias by its name and auth mount, the same name is usually used on multiple mounts
+func aliasKey(item map[string]interface{}) string {
+	name := cast.ToString(item["name"])
+	if name == "" {
+		return ""
+	}
+
+	return name + "@" + strings.Trim(cast.ToString(item["mountpath"]), "/")
+}
+
+// configDocument is a single file of a configuration
+type configDocument struct {
+	file   string
+	values map[string]interface{}
+}
+
+type configLoader struct {
+	templater configuration.Templater
+	documents []configDocument
+	loaded    map[string]bool
+}
+
+// LoadConfig loads the externalConfig from YAML or JSON files (or any other format supported by viper),
+// or from all of the YAML and JSON files in directories (in lexical order), together with the files
+// listed under the include key of each file. Every file is rendered as a template first, and is loaded
+// only once. The files of all paths are merged into a single configuration: maps are deep-merged, the
+// items of the auth, secrets, audit, policies, groups, entities and alias lists are merged by their path
+// or name, and other lists are concatenated. Different values set for the same key in multiple files
+// are reported as a conflict.
+func LoadConfig(paths ...string) (*viper.Viper, error) {
+	loader := &configLoader{
+		templater: configuration.NewTemplater(configuration.DefaultLeftDelimiter, configuration.DefaultRightDelimiter),
+		loaded:    map[string]bool{},
+	}
+
+	for _, path := range paths {
+		if err := loader.load(path); err != nil {
+			return nil, err
+		}
+	}
+
+	merged, err := mergeConfigDocuments(loader.documents)
+	if err != nil {
+		return nil, err
+	}
+
+	config := viper.New()
+	config.SetConfigFile(strings.Join(paths, ","))
+
+	if err := config.MergeConfigMap(merged); err != nil {
+		return nil, errors.Wrapf(err, "error loading vault config %s", strings.Join(paths, ","))
+	}
+
+	return config, nil
+}
+
+func (l *configLoader) load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "error reading vault config")
+	}
+
+	if !info.IsDir() {
+		return l.loadFile(path)
+	}
+
+	files, err := configDirectoryFiles(path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := l.loadFile(file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configDirectoryFiles lists the YAML and JSON files of a directory, hidden files
+// (like the ..data directory of Kubernetes ConfigMap volumes) are skipped.
+func configDirectoryFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading vault config directory")
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || !isConfigFile(entry.Name()) {
+			continue
+		}
+
+		file := filepath.Join(dir, entry.Name())
+
+		// ConfigMap volumes contain symlinks to the files
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading vault config")
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		files = append(files, file)
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func isConfigFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yml", ".yaml", ".json":
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *configLoader) loadFile(file string) error {
+	file = filepath.Clean(file)
+	if l.loaded[file] {
+		logrus.Debugf("vault config file %s is already loaded, skipping it", file)
+
+		return nil
+	}
+	l.loaded[file] = true
+
+	values, err := l.parseFile(file)
+	if err != nil {
+		return err
+	}
+
+	var includes []string
+	if include, ok := values[configIncludeKey]; ok {
+		// a single include may contain spaces, which would be split by cast
+		if single, ok := include.(string); ok {
+			includes = []string{single}
+		} else if includes, err = cast.ToStringSliceE(include); err != nil {
+			return errors.Wrapf(err, "invalid %s in vault config file %s", configIncludeKey, file)
+		}
+		delete(values, configIncludeKey)
+	}
+
+	l.documents = append(l.documents, configDocument{file: file, values: values})
+
+	return loadIncludes(file, includes, l.load)
+}
+
+// loadIncludes resolves the include patterns of a config file relative to its directory,
+// and loads every matching file or directory with load.
+func loadIncludes(file string, includes []string, load func(path string) error) error {
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(file), include)
+		}
+
+		matches, err := filepath.Glob(include)
+		if err != nil {
+			return errors.Wrapf(err, "invalid include pattern in vault config file %s", file)
+		}
+		if len(matches) == 0 {
+			return errors.Errorf("include %s of vault config file %s doesn't match any files", include, file)
+		}
+
+		for _, match := range matches {
+			if err := load(match); err != nil {
+				return errors.Wrapf(err, "error loading include of vault config file %s", file)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseFile renders the file as a template and decodes it the same way as viper does
+func (l *configLoader) parseFile(file string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading vault config file")
+	}
+
+	buffer, err := l.templater.EnvTemplate(string(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error executing vault config template %s", file)
+	}
+
+	values := map[string]interface{}{}
+
+	switch ext := filepath.Ext(file); ext {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(buffer.Bytes(), &values)
+	case ".json":
+		err = json.Unmarshal(buffer.Bytes(), &values)
+	default:
+		// the other formats of viper (TOML, HCL, etc.) are still accepted for single files
+		values, err = parseViperFile(strings.TrimPrefix(ext, "."), buffer)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing vault config file %s", file)
+	}
+
+	// viper keys are case insensitive
+	lowercased := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		lowercased[strings.ToLower(key)] = value
+	}
+
+	return lowercased, nil
+}
+
+// parseViperFile decodes the other configuration formats of viper, viper silently
+// ignores the unknown ones when the config type is set explicitly
+func parseViperFile(configType string, in io.Reader) (map[string]interface{}, error) {
+	supported := false
+	for _, ext := range viper.SupportedExts {
+		supported = supported || ext == configType
+	}
+	if !supported {
+		return nil, errors.WithStack(viper.UnsupportedConfigError(configType))
+	}
+
+	config := viper.New()
+	config.SetConfigType(configType)
+
+	if err := config.ReadConfig(in); err != nil {
+		return nil, err
+	}
+
+	// some decoders return typed lists (like []map[string]interface{} for the tables of TOML),
+	// they are converted to the generic types of JSON to be merged with the other files
+	data, err := json.Marshal(config.AllSettings())
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+
+	return values, json.Unmarshal(data, &values)
+}
+
+// configMerger merges the documents of a configuration, and keeps track of the
+// file each value is coming from to report conflicts.
+type configMerger struct {
+	origins map[string]string
+}
+
+func mergeConfigDocuments(documents []configDocument) (map[string]interface{}, error) {
+	merger := &configMerger{origins: map[string]string{}}
+	merged := map[string]interface{}{}
+
+	for _, document := range documents {
+		for key, value := range document.values {
+			existing, ok := merged[key]
+			if !ok {
+				merged[key] = value
+				merger.origins[key] = document.file
+
+				continue
+			}
+
+			mergedValue, err := merger.merge(key, existing, value, document.file, configListKeys[key])
+			if err != nil {
+				return nil, err
+			}
+			merged[key] = mergedValue
+		}
+	}
+
+	return merged, nil
+}
+
+func (m *configMerger) merge(path string, dst, src interface{}, file string, listKey func(map[string]interface{}) string) (interface{}, error) {
+	dstMap, dstIsMap := toConfigMap(dst)
+	srcMap, srcIsMap := toConfigMap(src)
+	if dstIsMap && srcIsMap {
+		for key, value := range srcMap {
+			keyPath := path + "." + key
+
+			existing, ok := dstMap[key]
+			if !ok {
+				dstMap[key] = value
+				m.origins[keyPath] = file
+
+				continue
+			}
+
+			mergedValue, err := m.merge(keyPath, existing, value, file, nestedListKey(path, key))
+			if err != nil {
+				return nil, err
+			}
+			dstMap[key] = mergedValue
+		}
+
+		return dstMap, nil
+	}
+
+	dstList, dstIsList := dst.([]interface{})
+	srcList, srcIsList := src.([]interface{})
+	if dstIsList && srcIsList {
+		return m.mergeList(path, dstList, srcList, file, listKey)
+	}
+
+	if reflect.DeepEqual(dst, src) {
+		return dst, nil
+	}
+
+	return nil, errors.Errorf("conflicting values for %s in vault config files %s and %s", path, m.origin(path), file)
+}
+
+// mergeList merges the items of src into dst, the items are only matched with the ones
+// coming from earlier files, so the same key can be repeated within a file
+func (m *configMerger) mergeList(path string, dst, src []interface{}, file string, listKey func(map[string]interface{}) string) ([]interface{}, error) {
+	index := map[string]int{}
+	if listKey != nil {
+		for i, item := range dst {
+			if itemMap, ok := toConfigMap(item); ok {
+				if key := listKey(itemMap); key != "" {
+					index[key] = i
+				}
+			}
+		}
+	}
+
+	for _, item := range src {
+		itemMap, isMap := toConfigMap(item)
+		if listKey == nil || !isMap || listKey(itemMap) == "" {
+			dst = append(dst, item)
+
+			continue
+		}
+
+		key := listKey(itemMap)
+		itemPath := fmt.Sprintf("%s[%s]", path, key)
+
+		i, ok := index[key]
+		if !ok {
+			dst = append(dst, item)
+			m.origins[itemPath] = file
+
+			continue
+		}
+
+		merged, err := m.merge(itemPath, dst[i], item, file, nil)
+		if err != nil {
+			return nil, err
+		}
+		dst[i] = merged
+	}
+
+	return dst, nil
+}
+
+// origin returns the file the value at the path or its closest parent is coming from
+func (m *configMerger) origin(path string) string {
+	for path != "" {
+		if file, ok := m.origins[path]; ok {
+			return file
+		}
+
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+
+	return "unknown"
+}
+
+// toConfigMap returns the value if it is a map, YAML is decoded through JSON, so all maps have string keys
+func toConfigMap(value interface{}) (map[string]interface{}, bool) {
+	m, ok := value.(map[string]interface{})
+
+	return m, ok
+}
@@ -0,0 +1,218 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0o600))
+	}
+
+	return dir
+}
+
+func TestLoadConfigDirectory(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"10-auth.yml": `
+auth:
+  - type: kubernetes
+    roles:
+      - name: default
+        policies: allow_secrets
+policies:
+  - name: allow_secrets
+    rules: path "secret/*" { capabilities = ["read"] }
+`,
+		"20-auth.yaml": `
+include:
+  - shared/*.json
+auth:
+  - type: kubernetes
+    config:
+      kubernetes_host: https://kubernetes.default
+    roles:
+      - name: admin
+        policies: admin
+  - type: approle
+purgeUnmanagedConfig:
+  enabled: true
+`,
+		"shared/policies.json": `{"policies": [{"name": "admin", "rules": "path \"*\" { capabilities = [\"sudo\"] }"}]}`,
+		"README.md":            "not a config file",
+	})
+
+	config, err := LoadConfig(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, dir, config.ConfigFileUsed())
+
+	var loaded externalConfig
+	assert.NoError(t, config.Unmarshal(&loaded))
+
+	assert.Len(t, loaded.Auth, 2)
+	assert.Equal(t, "kubernetes", loaded.Auth[0].Type)
+	assert.Len(t, loaded.Auth[0].Roles, 2)
+	assert.Equal(t, "https://kubernetes.default", loaded.Auth[0].Config["kubernetes_host"])
+	assert.Equal(t, "approle", loaded.Auth[1].Type)
+
+	assert.Len(t, loaded.Policies, 2)
+	assert.Equal(t, "allow_secrets", loaded.Policies[0].Name)
+	assert.Equal(t, "admin", loaded.Policies[1].Name)
+
+	assert.True(t, loaded.PurgeUnmanagedConfig.Enabled)
+	assert.Nil(t, config.Get(configIncludeKey))
+}
+
+func TestLoadConfigConflict(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yml": `
+policies:
+  - name: admin
+    rules: path "*" { capabilities = ["read"] }
+`,
+		"b.yml": `
+policies:
+  - name: admin
+    rules: path "*" { capabilities = ["sudo"] }
+`,
+	})
+
+	_, err := LoadConfig(dir)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "policies[admin].rules")
+	assert.Contains(t, err.Error(), filepath.Join(dir, "a.yml"))
+	assert.Contains(t, err.Error(), filepath.Join(dir, "b.yml"))
+}
+
+func TestLoadConfigIncludeOnce(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yml": `
+include: b.yml
+startupSecrets:
+  - type: kv
+    path: secret/data/a
+`,
+		"b.yml": `
+include: a.yml
+startupSecrets:
+  - type: kv
+    path: secret/data/b
+`,
+	})
+
+	config, err := LoadConfig(filepath.Join(dir, "a.yml"))
+	assert.NoError(t, err)
+
+	startupSecrets, err := toSliceStringMapE(config.Get("startupSecrets"))
+	assert.NoError(t, err)
+	assert.Len(t, startupSecrets, 2)
+
+	_, err = LoadConfig(filepath.Join(dir, "missing.yml"))
+	assert.Error(t, err)
+}
+
+func TestLoadConfigPaths(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"auth.yml": `
+include: policies/*.yml
+auth:
+  - type: approle
+`,
+		"policies/admin.yml": `
+policies:
+  - name: admin
+    rules: path "*" { capabilities = ["sudo"] }
+`,
+		"secrets/kv.yml": `
+secrets:
+  - path: secret
+    type: kv
+policies:
+  - name: admin
+    rules: path "*" { capabilities = ["sudo"] }
+`,
+		"conflict.yml": `
+policies:
+  - name: admin
+    rules: path "*" { capabilities = ["read"] }
+`,
+	})
+
+	config, err := LoadConfig(filepath.Join(dir, "auth.yml"), filepath.Join(dir, "secrets"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "auth.yml")+","+filepath.Join(dir, "secrets"), config.ConfigFileUsed())
+
+	var loaded externalConfig
+	assert.NoError(t, config.Unmarshal(&loaded))
+	assert.Len(t, loaded.Auth, 1)
+	assert.Len(t, loaded.Secrets, 1)
+	assert.Len(t, loaded.Policies, 1)
+
+	// The paths are merged into a single configuration, so they can conflict with each other
+	_, err = LoadConfig(filepath.Join(dir, "auth.yml"), filepath.Join(dir, "conflict.yml"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "policies[admin].rules")
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"vault-config.toml": `
+include = "policies.yml"
+
+[[auth]]
+type = "approle"
+
+[[policies]]
+name = "reader"
+rules = 'path "secret/*" { capabilities = ["read"] }'
+`,
+		"policies.yml": `
+policies:
+  - name: admin
+    rules: path "*" { capabilities = ["sudo"] }
+`,
+		"vault-config.txt": "auth: []",
+	})
+
+	config, err := LoadConfig(filepath.Join(dir, "vault-config.toml"))
+	assert.NoError(t, err)
+
+	var loaded externalConfig
+	assert.NoError(t, config.Unmarshal(&loaded))
+	assert.Len(t, loaded.Auth, 1)
+	assert.Equal(t, "approle", loaded.Auth[0].Type)
+	assert.Len(t, loaded.Policies, 2)
+	assert.Equal(t, "reader", loaded.Policies[0].Name)
+	assert.Equal(t, "admin", loaded.Policies[1].Name)
+
+	// only the YAML and JSON files are loaded from directories
+	config, err = LoadConfig(dir)
+	assert.NoError(t, err)
+	assert.Nil(t, config.Get("auth"))
+	assert.Len(t, config.Get("policies"), 1)
+
+	_, err = LoadConfig(filepath.Join(dir, "vault-config.txt"))
+	assert.Error(t, err)
+}
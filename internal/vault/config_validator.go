@@ -0,0 +1,554 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/mitchellh/mapstructure"
+	"gopkg.in/yaml.v3"
+
+	"github.com/banzaicloud/bank-vaults/internal/configuration"
+)
+
+// ConfigValidationError is a violation of the configuration schema or a failed policy test, Line is 0
+// if the error doesn't belong to a single value (e.g. conflicting files). Warnings (e.g. dangerous
+// policy rules) don't make the configuration invalid.
+type ConfigValidationError struct {
+	File    string
+	Line    int
+	Column  int
+	Path    string
+	Message string
+	Warning bool
+}
+
+func (e *ConfigValidationError) Error() string {
+	message := e.Message
+	if e.Warning {
+		message = "warning: " + message
+	}
+
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, message)
+	}
+
+	if e.Path == "" {
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, message)
+	}
+
+	return fmt.Sprintf("%s:%d:%d: %s: %s", e.File, e.Line, e.Column, e.Path, message)
+}
+
+// configValidator validates the files of a configuration, like configLoader loads them
+type configValidator struct {
+	schema    *Schema
+	documents []validatedDocument
+	loaded    map[string]bool
+	// plugins holds the names of the registered plugins by type
+	plugins map[string]map[string]bool
+	errors  []*ConfigValidationError
+}
+
+type validatedDocument struct {
+	file string
+	node *yaml.Node
+}
+
+// ValidateConfig validates the configuration files or directories against ConfigSchema, together with
+// the files they include, without connecting to Vault. The files are not rendered as templates, template
+// expressions are accepted for any value. The files are also checked for conflicting values, like
+// LoadConfig merges them. The policies without template expressions are linted and their tests are
+// evaluated. The returned error is only set if the files couldn't be read or parsed.
+func ValidateConfig(paths ...string) ([]*ConfigValidationError, error) {
+	validator := &configValidator{
+		schema:  ConfigSchema(),
+		loaded:  map[string]bool{},
+		plugins: map[string]map[string]bool{},
+	}
+
+	for _, path := range paths {
+		if err := validator.load(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, document := range validator.documents {
+		validator.collectPlugins(document.node)
+	}
+
+	documents := make([]configDocument, 0, len(validator.documents))
+	for _, document := range validator.documents {
+		validator.validate(document.file, document.node, validator.schema, "")
+		validator.checkPolicies(document.file, document.node, "")
+
+		values := map[string]interface{}{}
+		if err := document.node.Decode(&values); err != nil {
+			return nil, errors.Wrapf(err, "error decoding vault config file %s", document.file)
+		}
+
+		lowercased := make(map[string]interface{}, len(values))
+		for key, value := range values {
+			lowercased[strings.ToLower(key)] = value
+		}
+		delete(lowercased, configIncludeKey)
+
+		documents = append(documents, configDocument{file: document.file, values: lowercased})
+	}
+
+	if _, err := mergeConfigDocuments(documents); err != nil {
+		validator.errors = append(validator.errors, &ConfigValidationError{File: strings.Join(paths, ","), Message: err.Error()})
+	}
+
+	return validator.errors, nil
+}
+
+func (v *configValidator) load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "error reading vault config")
+	}
+
+	if !info.IsDir() {
+		return v.loadFile(path)
+	}
+
+	files, err := configDirectoryFiles(path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := v.loadFile(file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *configValidator) loadFile(file string) error {
+	file = filepath.Clean(file)
+	if v.loaded[file] {
+		return nil
+	}
+	v.loaded[file] = true
+
+	if !isConfigFile(file) {
+		return errors.Errorf("vault config file %s can't be validated, only YAML and JSON files are supported", file)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err, "error reading vault config file")
+	}
+
+	// JSON is a subset of YAML, so both are parsed with positions the same way
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return errors.Wrapf(err, "error parsing vault config file %s", file)
+	}
+
+	// an empty file
+	if len(document.Content) == 0 {
+		return nil
+	}
+
+	node := document.Content[0]
+	v.documents = append(v.documents, validatedDocument{file: file, node: node})
+
+	return loadIncludes(file, v.includes(file, node), v.load)
+}
+
+// includes returns the files included by the document, templated entries can't be followed
+func (v *configValidator) includes(file string, node *yaml.Node) []string {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var includes []string
+	for _, pair := range mappingPairs(node) {
+		key, value := pair[0], pair[1]
+		if !strings.EqualFold(key.Value, configIncludeKey) {
+			continue
+		}
+
+		entries := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			entries = value.Content
+		}
+
+		for _, entry := range entries {
+			if entry.Kind != yaml.ScalarNode || entry.Tag == "!!null" {
+				v.errorf(file, entry, key.Value, "expected a file name or a list of file names")
+
+				continue
+			}
+			if isTemplated(entry) {
+				continue
+			}
+			includes = append(includes, entry.Value)
+		}
+	}
+
+	return includes
+}
+
+func (v *configValidator) collectPlugins(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	for _, pair := range mappingPairs(node) {
+		if !strings.EqualFold(pair[0].Value, "plugins") || pair[1].Kind != yaml.SequenceNode {
+			continue
+		}
+
+		for _, plugin := range pair[1].Content {
+			if plugin.Kind != yaml.MappingNode {
+				continue
+			}
+
+			var pluginType, pluginName string
+			for _, field := range mappingPairs(plugin) {
+				switch field[0].Value {
+				case "type":
+					pluginType = field[1].Value
+				case "plugin_name":
+					pluginName = field[1].Value
+				}
+			}
+
+			if v.plugins[pluginType] == nil {
+				v.plugins[pluginType] = map[string]bool{}
+			}
+			v.plugins[pluginType][pluginName] = true
+		}
+	}
+}
+
+func (v *configValidator) validate(file string, node *yaml.Node, schema *Schema, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	// missing values are left empty, templates may render to any type
+	if node.Kind == yaml.ScalarNode && (node.Tag == "!!null" || isTemplated(node)) {
+		return
+	}
+
+	switch schema.Type {
+	case "object":
+		if node.Kind != yaml.MappingNode {
+			v.errorf(file, node, path, "expected an object")
+
+			return
+		}
+
+		present := map[string]bool{}
+		for _, pair := range mappingPairs(node) {
+			key, value := pair[0], pair[1]
+			keyPath := key.Value
+			if path != "" {
+				keyPath = path + "." + key.Value
+			}
+
+			present[strings.ToLower(key.Value)] = true
+
+			if property := schema.property(key.Value); property != nil {
+				v.validate(file, value, property, keyPath)
+
+				continue
+			}
+
+			switch additional := schema.AdditionalProperties.(type) {
+			case *Schema:
+				v.validate(file, value, additional, keyPath)
+			case bool:
+				if !additional {
+					v.errorf(file, key, path, "unknown key %q%s", key.Value, schema.suggestion(key.Value))
+				}
+			}
+		}
+
+		for _, required := range schema.Required {
+			if !present[strings.ToLower(required)] {
+				v.errorf(file, node, path, "missing required key %q", required)
+			}
+		}
+
+	case "array":
+		if node.Kind != yaml.SequenceNode {
+			v.errorf(file, node, path, "expected a list")
+
+			return
+		}
+
+		for i, item := range node.Content {
+			v.validate(file, item, schema.Items, fmt.Sprintf("%s[%d]", path, i))
+		}
+
+	case "string", "boolean", "integer", "number":
+		if node.Kind != yaml.ScalarNode || !scalarIs(node, schema.Type) {
+			v.errorf(file, node, path, "expected a %s", schema.Type)
+
+			return
+		}
+
+		if len(schema.Enum) > 0 && !v.allowed(schema, node.Value) {
+			v.errorf(file, node, path, "unsupported value %q, expected one of %s", node.Value, strings.Join(schema.Enum, ", "))
+		}
+	}
+}
+
+// checkPolicies lints the policies of a document (or namespace) and evaluates their tests, the policies with
+// configuration template expressions are skipped, since they are rendered only when loaded
+func (v *configValidator) checkPolicies(file string, node *yaml.Node, pathPrefix string) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	for _, pair := range mappingPairs(node) {
+		if pathPrefix == "" && strings.EqualFold(pair[0].Value, "namespaces") && pair[1].Kind == yaml.SequenceNode {
+			for i, namespace := range pair[1].Content {
+				v.checkPolicies(file, namespace, fmt.Sprintf("%s[%d].", pair[0].Value, i))
+			}
+
+			continue
+		}
+
+		if !strings.EqualFold(pair[0].Value, "policies") || pair[1].Kind != yaml.SequenceNode {
+			continue
+		}
+
+		for i, item := range pair[1].Content {
+			path := fmt.Sprintf("%s%s[%d]", pathPrefix, pair[0].Value, i)
+			if item.Kind != yaml.MappingNode || containsTemplate(item) {
+				continue
+			}
+
+			var values map[string]interface{}
+			var p policy
+			// the schema violations are reported already
+			if item.Decode(&values) != nil || mapstructure.WeakDecode(values, &p) != nil {
+				continue
+			}
+
+			policies, err := expandPolicies([]policy{p})
+			if err != nil {
+				v.errorf(file, item, path, "%s", err.Error())
+
+				continue
+			}
+
+			for _, expanded := range policies {
+				v.checkPolicy(file, item, path, expanded)
+			}
+		}
+	}
+}
+
+func (v *configValidator) checkPolicy(file string, node *yaml.Node, path string, p policy) {
+	if err := p.format(); err != nil {
+		v.errorf(file, node, path, "%s", err.Error())
+
+		return
+	}
+
+	for _, warning := range p.lint() {
+		v.warnf(file, node, path, "%s policy: %s", p.Name, warning)
+	}
+
+	failures, err := p.test()
+	if err != nil {
+		v.errorf(file, node, path, "%s", err.Error())
+
+		return
+	}
+
+	var testNodes []*yaml.Node
+	for _, pair := range mappingPairs(node) {
+		if strings.EqualFold(pair[0].Value, "tests") && pair[1].Kind == yaml.SequenceNode {
+			testNodes = pair[1].Content
+		}
+	}
+
+	for i := range p.Tests {
+		if failure, ok := failures[i]; ok {
+			testNode := node
+			if i < len(testNodes) {
+				testNode = testNodes[i]
+			}
+			v.errorf(file, testNode, fmt.Sprintf("%s.tests[%d]", path, i), "%s", failure)
+		}
+	}
+}
+
+func (v *configValidator) allowed(schema *Schema, value string) bool {
+	for _, allowed := range schema.Enum {
+		if value == allowed {
+			return true
+		}
+	}
+
+	return schema.pluginType != "" && v.plugins[schema.pluginType][value]
+}
+
+func (v *configValidator) errorf(file string, node *yaml.Node, path string, format string, args ...interface{}) {
+	v.errors = append(v.errors, &ConfigValidationError{
+		File:    file,
+		Line:    node.Line,
+		Column:  node.Column,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *configValidator) warnf(file string, node *yaml.Node, path string, format string, args ...interface{}) {
+	v.errorf(file, node, path, format, args...)
+	v.errors[len(v.errors)-1].Warning = true
+}
+
+// property looks up a property case insensitively, like viper does
+func (s *Schema) property(name string) *Schema {
+	for key, property := range s.Properties {
+		if strings.EqualFold(key, name) {
+			return property
+		}
+	}
+
+	return nil
+}
+
+// suggestion returns the closest property name to a misspelled one
+func (s *Schema) suggestion(name string) string {
+	best, bestDistance := "", 3
+	for key := range s.Properties {
+		distance := editDistance(strings.ToLower(key), strings.ToLower(name))
+		if distance < bestDistance || distance == bestDistance && key < best {
+			best, bestDistance = key, distance
+		}
+	}
+
+	if best == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+
+	return result
+}
+
+// mappingPairs returns the key and value nodes of a mapping, with the YAML merge keys resolved
+func mappingPairs(node *yaml.Node) [][2]*yaml.Node {
+	var pairs [][2]*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Tag != "!!merge" {
+			pairs = append(pairs, [2]*yaml.Node{key, value})
+
+			continue
+		}
+
+		merged := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			merged = value.Content
+		}
+		for _, m := range merged {
+			if m.Kind == yaml.AliasNode {
+				m = m.Alias
+			}
+			if m.Kind == yaml.MappingNode {
+				pairs = append(pairs, mappingPairs(m)...)
+			}
+		}
+	}
+
+	return pairs
+}
+
+// containsTemplate checks if any scalar under the node is templated
+func containsTemplate(node *yaml.Node) bool {
+	if node.Kind == yaml.ScalarNode {
+		return isTemplated(node)
+	}
+
+	for _, child := range node.Content {
+		if containsTemplate(child) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isTemplated(node *yaml.Node) bool {
+	return strings.Contains(node.Value, configuration.DefaultLeftDelimiter)
+}
+
+// scalarIs checks the type of a scalar, quoted values are accepted if they can be parsed
+// as the type, since the configuration is decoded with weak typing
+func scalarIs(node *yaml.Node, typ string) bool {
+	switch typ {
+	case "boolean":
+		_, err := strconv.ParseBool(node.Value)
+		return node.Tag == "!!bool" || node.Tag == "!!str" && err == nil
+	case "integer":
+		_, err := strconv.ParseInt(node.Value, 0, 64)
+		return node.Tag == "!!int" || node.Tag == "!!str" && err == nil
+	case "number":
+		_, err := strconv.ParseFloat(node.Value, 64)
+		return node.Tag == "!!int" || node.Tag == "!!float" || node.Tag == "!!str" && err == nil
+	default:
+		return true
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigSchemaPublished(t *testing.T) {
+	published, err := ioutil.ReadFile("../../vault-config.schema.json")
+	assert.NoError(t, err)
+
+	generated, err := json.MarshalIndent(ConfigSchema(), "", "  ")
+	assert.NoError(t, err)
+
+	assert.JSONEq(t, string(generated), string(published), "regenerate it with: bank-vaults validate --print-schema > vault-config.schema.json")
+}
+
+func TestValidateConfigExample(t *testing.T) {
+	validationErrors, err := ValidateConfig("../../vault-config.yml")
+	assert.NoError(t, err)
+	assert.Empty(t, validationErrors)
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"vault-config.yml": `include: policies.json
+plugins:
+  - plugin_name: custom-auth
+    type: auth
+    command: custom-auth
+    sha256: abcd
+auth:
+  - type: kubernetess
+  - type: custom-auth
+    options:
+      listing_visibility: unauth
+  - type: approle
+    path: ${ env "APPROLE_PATH" }
+    roles: not-a-list
+secrets:
+  - path: secret
+    type: kv
+    configurations:
+      config:
+        - max_versions: 100
+    config:
+      roles: []
+  - type: pki
+    local: "yes please"
+purgeUnmanagedConfig:
+  enabled: "true"
+startupSecrets:
+  - type: kv
+`,
+		"policies.json": `{
+  "policies": [
+    {"name": "admin"}
+  ]
+}`,
+	})
+
+	validationErrors, err := ValidateConfig(filepath.Join(dir, "vault-config.yml"))
+	assert.NoError(t, err)
+
+	messages := make([]string, 0, len(validationErrors))
+	for _, validationError := range validationErrors {
+		messages = append(messages, validationError.Error())
+	}
+
+	configFile := filepath.Join(dir, "vault-config.yml")
+	policiesFile := filepath.Join(dir, "policies.json")
+	assert.Equal(t, []string{
+		configFile + `:8:11: auth[0].type: unsupported value "kubernetess", expected one of alicloud, approle, aws, azure, cert, cf, gcp, github, jwt, kerberos, kubernetes, ldap, oci, oidc, okta, radius, token, userpass`,
+		configFile + `:14:12: auth[2].roles: expected a list`,
+		configFile + `:18:5: secrets[0]: unknown key "configurations", did you mean "configuration"?`,
+		configFile + `:22:7: secrets[0].config: unknown key "roles"`,
+		configFile + `:24:12: secrets[1].local: expected a boolean`,
+		configFile + `:28:5: startupSecrets[0]: missing required key "path"`,
+		policiesFile + `:3:5: policies[0]: missing required key "rules"`,
+	}, messages)
+}
+
+func TestValidateConfigConflict(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yml": `
+auth:
+  - type: approle
+    options:
+      default_lease_ttl: 1h
+`,
+		"b.yml": `
+auth:
+  - type: approle
+    options:
+      default_lease_ttl: 2h
+`,
+	})
+
+	validationErrors, err := ValidateConfig(dir)
+	assert.NoError(t, err)
+	assert.Len(t, validationErrors, 1)
+	assert.Contains(t, validationErrors[0].Error(), "auth[approle].options.default_lease_ttl")
+
+	// Separate paths are merged too
+	validationErrors, err = ValidateConfig(filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml"))
+	assert.NoError(t, err)
+	assert.Len(t, validationErrors, 1)
+
+	_, err = ValidateConfig(filepath.Join(dir, "missing.yml"))
+	assert.Error(t, err)
+}
+
+func TestValidatePolicies(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"vault-config.yml": `policies:
+  - name: admin
+    rules: path "*" { capabilities = ["read"] }
+  - name: team-[[ .item ]]
+    for_each: [a, b]
+    rules: path "secret/[[ .item ]]/*" { capabilities = ["read"] }
+    tests:
+      - path: secret/[[ .item ]]/db
+        capability: read
+        allowed: true
+      - path: secret/a/db
+        capability: read
+        allowed: false
+  - name: ${ env "POLICY_NAME" }
+    rules: path "*" { capabilities = ["sudo"] }
+`,
+	})
+
+	validationErrors, err := ValidateConfig(filepath.Join(dir, "vault-config.yml"))
+	assert.NoError(t, err)
+
+	messages := make([]string, 0, len(validationErrors))
+	for _, validationError := range validationErrors {
+		messages = append(messages, validationError.Error())
+	}
+
+	configFile := filepath.Join(dir, "vault-config.yml")
+	assert.Equal(t, []string{
+		configFile + `:2:5: policies[0]: warning: admin policy: path "*" matches every path`,
+		configFile + `:11:9: policies[1].tests[1]: team-a policy should deny read on "secret/a/db"`,
+	}, messages)
+	assert.True(t, validationErrors[0].Warning)
+	assert.False(t, validationErrors[1].Warning)
+}
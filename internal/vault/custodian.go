@@ -0,0 +1,240 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"emperror.dev/errors"
+	"github.com/hashicorp/vault/api"
+	json "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// CustodianSharePath is the path of the custodian intake where the unseal key shares are submitted
+	CustodianSharePath = "/v1/share"
+	// CustodianStatusPath is the path of the custodian intake where the unseal progress can be checked
+	CustodianStatusPath = "/v1/status"
+
+	maxShareRequestSize = 64 * 1024
+)
+
+// CustodianShareRequest is the body of a share submission
+type CustodianShareRequest struct {
+	Key string `json:"key"`
+}
+
+// CustodianStatus describes the unseal progress of Vault
+type CustodianStatus struct {
+	Sealed    bool `json:"sealed"`
+	Progress  int  `json:"progress"`
+	Threshold int  `json:"threshold"`
+	// custodians who already submitted their share in the current unseal attempt
+	Submitted []string `json:"submitted"`
+}
+
+// CustodianIntake accepts unseal key shares from key custodians over HTTP and passes them to Vault.
+// Every custodian authenticates with their own bearer token and may submit one share per unseal attempt.
+// The shares are never stored or logged, they are only held in memory until they are sent to Vault.
+type CustodianIntake struct {
+	cl     *api.Client
+	tokens map[string]string
+
+	mu        sync.Mutex
+	submitted map[string]bool
+	progress  int
+}
+
+// NewCustodianIntake creates a custodian intake for the Vault instance, tokens maps custodian names to their tokens.
+func NewCustodianIntake(cl *api.Client, tokens map[string]string) (*CustodianIntake, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("at least one custodian token is required")
+	}
+
+	seen := map[string]string{}
+	for name, token := range tokens {
+		if token == "" {
+			return nil, errors.Errorf("empty token for custodian '%s'", name)
+		}
+		if other, ok := seen[token]; ok {
+			return nil, errors.Errorf("custodians '%s' and '%s' share the same token", other, name)
+		}
+		seen[token] = name
+	}
+
+	return &CustodianIntake{
+		cl:        cl,
+		tokens:    tokens,
+		submitted: map[string]bool{},
+	}, nil
+}
+
+func (i *CustodianIntake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	custodian, ok := i.authenticate(r)
+	if !ok {
+		http.Error(w, "invalid custodian token", http.StatusUnauthorized)
+
+		return
+	}
+
+	switch {
+	case r.URL.Path == CustodianSharePath && r.Method == http.MethodPost:
+		i.submitShare(w, r, custodian)
+	case r.URL.Path == CustodianStatusPath && r.Method == http.MethodGet:
+		status, err := i.Status()
+		if err != nil {
+			logrus.Errorf("error checking unseal progress: %s", err.Error())
+			http.Error(w, "error checking unseal progress", http.StatusBadGateway)
+
+			return
+		}
+
+		writeCustodianStatus(w, status)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Status returns the unseal progress reported by sys/seal-status, together with the custodians
+// who submitted their share since the progress was last reset.
+func (i *CustodianIntake) Status() (*CustodianStatus, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.status()
+}
+
+func (i *CustodianIntake) status() (*CustodianStatus, error) {
+	sealStatus, err := i.cl.Sys().SealStatus()
+	if err != nil {
+		return nil, errors.Wrap(err, "error checking seal status")
+	}
+
+	// Vault reset the unseal progress (unsealed, timed out, or reset manually),
+	// custodians may submit their share again.
+	if !sealStatus.Sealed || sealStatus.Progress < i.progress || sealStatus.Progress == 0 {
+		i.submitted = map[string]bool{}
+	}
+	i.progress = sealStatus.Progress
+
+	return &CustodianStatus{
+		Sealed:    sealStatus.Sealed,
+		Progress:  sealStatus.Progress,
+		Threshold: sealStatus.T,
+		Submitted: i.submittedCustodians(),
+	}, nil
+}
+
+func (i *CustodianIntake) submittedCustodians() []string {
+	submitted := make([]string, 0, len(i.submitted))
+	for custodian := range i.submitted {
+		submitted = append(submitted, custodian)
+	}
+	sort.Strings(submitted)
+
+	return submitted
+}
+
+func (i *CustodianIntake) submitShare(w http.ResponseWriter, r *http.Request, custodian string) {
+	defer runtime.GC()
+
+	var req CustodianShareRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxShareRequestSize)).Decode(&req); err != nil || req.Key == "" {
+		http.Error(w, "invalid share submission", http.StatusBadRequest)
+
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	status, err := i.status()
+	if err != nil {
+		logrus.Errorf("error checking unseal progress: %s", err.Error())
+		http.Error(w, "error checking unseal progress", http.StatusBadGateway)
+
+		return
+	}
+
+	log := logrus.WithField("custodian", custodian)
+
+	if !status.Sealed {
+		log.Info("share submitted while vault is already unsealed, ignoring it")
+		writeCustodianStatus(w, status)
+
+		return
+	}
+
+	if i.submitted[custodian] {
+		log.Warn("custodian already submitted a share in the current unseal attempt")
+		http.Error(w, "share already submitted in the current unseal attempt", http.StatusConflict)
+
+		return
+	}
+
+	resp, err := i.cl.Sys().Unseal(req.Key)
+	if err != nil {
+		// Don't wrap the error, Vault may echo parts of the request
+		log.Warn("vault rejected the submitted share")
+		http.Error(w, "vault rejected the submitted share", http.StatusBadRequest)
+
+		return
+	}
+
+	if resp.Sealed {
+		i.submitted[custodian] = true
+		i.progress = resp.Progress
+		log.Infof("share accepted, unseal progress %d/%d", resp.Progress, resp.T)
+	} else {
+		i.submitted = map[string]bool{}
+		i.progress = 0
+		log.Info("share accepted, vault is unsealed")
+	}
+
+	writeCustodianStatus(w, &CustodianStatus{
+		Sealed:    resp.Sealed,
+		Progress:  resp.Progress,
+		Threshold: resp.T,
+		Submitted: i.submittedCustodians(),
+	})
+}
+
+// authenticate returns the name of the custodian owning the bearer token of the request
+func (i *CustodianIntake) authenticate(r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return "", false
+	}
+
+	found := ""
+	for name, custodianToken := range i.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(custodianToken)) == 1 {
+			found = name
+		}
+	}
+
+	return found, found != ""
+}
+
+func writeCustodianStatus(w http.ResponseWriter, status *CustodianStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,116 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSealedVault accepts any unseal key and unseals after two of them
+type fakeSealedVault struct {
+	sync.Mutex
+
+	keys []string
+}
+
+func (f *fakeSealedVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.URL.Path == "/v1/sys/unseal" {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.keys = append(f.keys, body["key"].(string))
+	}
+
+	sealed := len(f.keys) < 2
+	progress := len(f.keys)
+	if !sealed {
+		progress = 0
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"sealed": sealed, "t": 2, "n": 3, "progress": progress})
+}
+
+func submit(t *testing.T, intake http.Handler, token, body string) (int, *CustodianStatus) {
+	req := httptest.NewRequest(http.MethodPost, CustodianSharePath, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	intake.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+
+	var status CustodianStatus
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+
+	return w.Code, &status
+}
+
+func TestCustodianIntake(t *testing.T) {
+	fake := &fakeSealedVault{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cl, err := api.NewClient(&api.Config{Address: server.URL})
+	assert.NoError(t, err)
+
+	intake, err := NewCustodianIntake(cl, map[string]string{"alice": "alice-token", "bob": "bob-token"})
+	assert.NoError(t, err)
+
+	code, _ := submit(t, intake, "invalid", `{"key": "share-0"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = submit(t, intake, "alice-token", `{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, status := submit(t, intake, "alice-token", `{"key": "share-0"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, &CustodianStatus{Sealed: true, Progress: 1, Threshold: 2, Submitted: []string{"alice"}}, status)
+
+	code, _ = submit(t, intake, "alice-token", `{"key": "share-1"}`)
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, status = submit(t, intake, "bob-token", `{"key": "share-1"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, status.Sealed)
+
+	assert.Equal(t, []string{"share-0", "share-1"}, fake.keys)
+
+	status, err = intake.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, &CustodianStatus{Sealed: false, Progress: 0, Threshold: 2, Submitted: []string{}}, status)
+}
+
+func TestCustodianIntakeTokens(t *testing.T) {
+	_, err := NewCustodianIntake(nil, nil)
+	assert.Error(t, err)
+
+	_, err = NewCustodianIntake(nil, map[string]string{"alice": ""})
+	assert.Error(t, err)
+
+	_, err = NewCustodianIntake(nil, map[string]string{"alice": "token", "bob": "token"})
+	assert.Error(t, err)
+}
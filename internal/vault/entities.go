@@ -0,0 +1,452 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// entityGroup is an internal group whose members are changed by the entities section
+type entityGroup struct {
+	id      string
+	members []string
+}
+
+func (v *vault) configureIdentityEntities(config *viper.Viper) error {
+	entities := []map[string]interface{}{}
+	entityAliases := []map[string]interface{}{}
+
+	err := config.UnmarshalKey("entities", &entities)
+	if err != nil {
+		return errors.Wrap(err, "error unmarshalling vault entities config")
+	}
+
+	err = config.UnmarshalKey("entity-aliases", &entityAliases)
+	if err != nil {
+		return errors.Wrap(err, "error unmarshalling vault entity aliases config")
+	}
+
+	for _, entity := range entities {
+		// the entity is created if it doesn't exist yet
+		logrus.Infof("writing entity: %s", entity["name"])
+		_, err = v.writeWithWarningCheck(fmt.Sprintf("identity/entity/name/%s", entity["name"]), entityConfig(entity))
+		if err != nil {
+			return errors.Wrapf(err, "failed to write entity %s", entity["name"])
+		}
+	}
+
+	groups, err := v.getChangedEntityGroups(entities, false)
+	if err != nil {
+		return err
+	}
+
+	for groupName, group := range groups {
+		logrus.Infof("setting entity members of group: %s", groupName)
+		_, err = v.writeWithWarningCheck(fmt.Sprintf("identity/group/id/%s", group.id), map[string]interface{}{
+			"member_entity_ids": group.members,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to set members of group %s", groupName)
+		}
+	}
+
+	existingAliases, err := listVaultEntityAliases(v.cl)
+	if err != nil {
+		return err
+	}
+
+	// an entity can have a single alias on each auth mount, so the aliases are matched by name and mount
+	for _, entityAlias := range entityAliases {
+		accessor, err := getVaultAuthMountAccessor(cast.ToString(entityAlias["mountpath"]), v.cl)
+		if err != nil {
+			return errors.Wrapf(err, "error getting mount accessor for %s", entityAlias["mountpath"])
+		}
+
+		id, err := getVaultEntityID(cast.ToString(entityAlias["entity"]), v.cl)
+		if err != nil {
+			return errors.Wrapf(err, "error getting canonical_id for entity %s", entityAlias["entity"])
+		}
+
+		aliasConfig := map[string]interface{}{
+			"name":           cast.ToString(entityAlias["name"]),
+			"mount_accessor": accessor,
+			"canonical_id":   id,
+		}
+
+		ea := findVaultEntityAliasID(existingAliases, cast.ToString(entityAlias["name"]), accessor)
+		if ea == "" {
+			logrus.Infof("creating entity-alias: %s@%s", entityAlias["name"], accessor)
+			_, err = v.writeWithWarningCheck("identity/entity-alias", aliasConfig)
+			if err != nil {
+				return errors.Wrapf(err, "failed to create entity-alias %s", entityAlias["name"])
+			}
+		} else {
+			logrus.Infof("tuning already existing entity-alias: %s@%s - ID: %s", entityAlias["name"], accessor, ea)
+			_, err = v.writeWithWarningCheck(fmt.Sprintf("identity/entity-alias/id/%s", ea), aliasConfig)
+			if err != nil {
+				return errors.Wrapf(err, "failed to tune entity-alias %s", ea)
+			}
+		}
+	}
+
+	if err := v.removeUnmanagedEntityAliases(config, entityAliases); err != nil {
+		return err
+	}
+
+	return v.removeUnmanagedEntities(config, entities)
+}
+
+func entityConfig(entity map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"policies": cast.ToStringSlice(entity["policies"]),
+		"metadata": cast.ToStringMap(entity["metadata"]),
+		"disabled": cast.ToBool(entity["disabled"]),
+	}
+}
+
+// getChangedEntityGroups returns the internal groups (by name) whose entity members differ from the groups listed
+// by the managed entities. The members which are not managed entities are kept. Missing entities and groups are
+// skipped if skipMissing is set (when planning), otherwise a missing group is an error.
+func (v *vault) getChangedEntityGroups(entities []map[string]interface{}, skipMissing bool) (map[string]entityGroup, error) {
+	managedEntities := map[string]bool{}
+	desiredMembers := map[string][]string{}
+	groupIDs := map[string]bool{}
+
+	for _, entity := range entities {
+		entityName := cast.ToString(entity["name"])
+
+		e, err := readVaultEntity(entityName, v.cl)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading entity")
+		}
+		if e == nil {
+			if skipMissing {
+				continue
+			}
+
+			return nil, errors.Errorf("entity %s does not exist", entityName)
+		}
+
+		entityID := cast.ToString(e.Data["id"])
+		managedEntities[entityID] = true
+
+		for _, groupID := range cast.ToStringSlice(e.Data["direct_group_ids"]) {
+			groupIDs[groupID] = true
+		}
+
+		for _, groupName := range cast.ToStringSlice(entity["groups"]) {
+			g, err := readVaultGroup(groupName, v.cl)
+			if err != nil {
+				return nil, errors.Wrap(err, "error reading group")
+			}
+			if g == nil {
+				if skipMissing {
+					continue
+				}
+
+				return nil, errors.Errorf("group %s of entity %s does not exist", groupName, entityName)
+			}
+			if cast.ToString(g.Data["type"]) == "external" {
+				return nil, errors.Errorf("entity %s can't be a member of external group %s, use an entity alias instead", entityName, groupName)
+			}
+
+			groupID := cast.ToString(g.Data["id"])
+			groupIDs[groupID] = true
+			desiredMembers[groupID] = append(desiredMembers[groupID], entityID)
+		}
+	}
+
+	changedGroups := map[string]entityGroup{}
+	for groupID := range groupIDs {
+		g, err := v.cl.Logical().Read(fmt.Sprintf("identity/group/id/%s", groupID))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read group by id %s", groupID)
+		}
+		if g == nil || cast.ToString(g.Data["type"]) == "external" {
+			continue
+		}
+
+		existingMembers := cast.ToStringSlice(g.Data["member_entity_ids"])
+		members := []string{}
+		for _, member := range existingMembers {
+			if !managedEntities[member] {
+				members = append(members, member)
+			}
+		}
+		members = append(members, desiredMembers[groupID]...)
+
+		sort.Strings(existingMembers)
+		sort.Strings(members)
+		if strings.Join(existingMembers, ",") == strings.Join(members, ",") {
+			continue
+		}
+
+		changedGroups[cast.ToString(g.Data["name"])] = entityGroup{id: groupID, members: members}
+	}
+
+	return changedGroups, nil
+}
+
+func (v *vault) planIdentityEntities(plan *Plan, config *viper.Viper, purge purgeUnmanagedConfig) error {
+	entities := []map[string]interface{}{}
+	entityAliases := []map[string]interface{}{}
+
+	err := config.UnmarshalKey("entities", &entities)
+	if err != nil {
+		return errors.Wrap(err, "error unmarshalling vault entities config")
+	}
+
+	err = config.UnmarshalKey("entity-aliases", &entityAliases)
+	if err != nil {
+		return errors.Wrap(err, "error unmarshalling vault entity aliases config")
+	}
+
+	for _, entity := range entities {
+		entityName := cast.ToString(entity["name"])
+
+		e, err := readVaultEntity(entityName, v.cl)
+		if err != nil {
+			return errors.Wrap(err, "error reading entity")
+		}
+
+		if e == nil {
+			plan.add("entities", entityName)
+			continue
+		}
+
+		if fields := configChanges(entityConfig(entity), e.Data); len(fields) > 0 {
+			plan.change("entities", entityName, fields...)
+		}
+	}
+
+	groups, err := v.getChangedEntityGroups(entities, true)
+	if err != nil {
+		return err
+	}
+
+	for groupName := range groups {
+		plan.change("groups", groupName, "member_entity_ids")
+	}
+
+	existingAliases, err := listVaultEntityAliases(v.cl)
+	if err != nil {
+		return err
+	}
+
+	for _, entityAlias := range entityAliases {
+		aliasName := cast.ToString(entityAlias["name"])
+		mountPath := cast.ToString(entityAlias["mountpath"])
+		planName := fmt.Sprintf("%s@%s", aliasName, strings.Trim(mountPath, "/"))
+
+		accessor, err := getVaultAuthMountAccessor(mountPath, v.cl)
+		if err != nil {
+			// The auth mount is not there yet, so the alias will be created as well
+			plan.add("entity-aliases", planName)
+			continue
+		}
+
+		ea := findVaultEntityAliasID(existingAliases, aliasName, accessor)
+		if ea == "" {
+			plan.add("entity-aliases", planName)
+			continue
+		}
+
+		e, err := readVaultEntity(cast.ToString(entityAlias["entity"]), v.cl)
+		if err != nil {
+			return errors.Wrap(err, "error reading entity")
+		}
+
+		if e == nil || cast.ToString(existingAliases[ea]["canonical_id"]) != cast.ToString(e.Data["id"]) {
+			plan.change("entity-aliases", planName, "canonical_id")
+		}
+	}
+
+	if purge.Enabled && !purge.Exclude.Entities && config.IsSet("entities") {
+		unmanagedEntities, err := v.getUnmanagedEntities(entities)
+		if err != nil {
+			return err
+		}
+
+		for entityName := range unmanagedEntities {
+			plan.remove("entities", entityName)
+		}
+	}
+
+	if purge.Enabled && !purge.Exclude.EntityAliases && config.IsSet("entity-aliases") {
+		unmanagedEntityAliases, err := v.getUnmanagedEntityAliases(entityAliases)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range unmanagedEntityAliases {
+			plan.remove("entity-aliases", name)
+		}
+	}
+
+	return nil
+}
+
+// getUnmanagedEntities gets the names of the entities in Vault but missing from the externalConfig
+func (v *vault) getUnmanagedEntities(managedEntities []map[string]interface{}) (map[string]bool, error) {
+	existingEntities, err := v.cl.Logical().List("identity/entity/name")
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing entities")
+	}
+
+	unmanagedEntities := map[string]bool{}
+	if existingEntities != nil {
+		for _, entityName := range cast.ToStringSlice(existingEntities.Data["keys"]) {
+			unmanagedEntities[entityName] = true
+		}
+	}
+
+	for _, entity := range managedEntities {
+		delete(unmanagedEntities, cast.ToString(entity["name"]))
+	}
+
+	return unmanagedEntities, nil
+}
+
+// Deletes any entity that's not managed if purgeUnmanagedConfig option is enabled. Vault creates entities
+// on the first login of its users, so they are only purged if the entities section is in the configuration.
+func (v *vault) removeUnmanagedEntities(config *viper.Viper, managedEntities []map[string]interface{}) error {
+	if !extConfig.PurgeUnmanagedConfig.Enabled || extConfig.PurgeUnmanagedConfig.Exclude.Entities || !config.IsSet("entities") {
+		return nil
+	}
+
+	unmanagedEntities, err := v.getUnmanagedEntities(managedEntities)
+	if err != nil {
+		return err
+	}
+
+	for entityName := range unmanagedEntities {
+		logrus.Infof("removing unmanaged entity %s", entityName)
+		if _, err := v.cl.Logical().Delete(fmt.Sprintf("identity/entity/name/%s", entityName)); err != nil {
+			return errors.Wrapf(err, "error deleting entity %s from vault", entityName)
+		}
+	}
+
+	return nil
+}
+
+// getUnmanagedEntityAliases gets the entity aliases in Vault but missing from the externalConfig,
+// the result maps their IDs to name@mountpath
+func (v *vault) getUnmanagedEntityAliases(managedEntityAliases []map[string]interface{}) (map[string]string, error) {
+	mountPaths, err := getVaultAuthMountPaths(v.cl)
+	if err != nil {
+		return nil, err
+	}
+
+	managed := map[string]bool{}
+	for _, entityAlias := range managedEntityAliases {
+		managed[fmt.Sprintf("%s@%s", cast.ToString(entityAlias["name"]), strings.Trim(cast.ToString(entityAlias["mountpath"]), "/"))] = true
+	}
+
+	aliases, err := listVaultEntityAliases(v.cl)
+	if err != nil {
+		return nil, err
+	}
+
+	unmanagedEntityAliases := map[string]string{}
+	for id, alias := range aliases {
+		name := fmt.Sprintf("%s@%s", cast.ToString(alias["name"]), mountPaths[cast.ToString(alias["mount_accessor"])])
+		if !managed[name] {
+			unmanagedEntityAliases[id] = name
+		}
+	}
+
+	return unmanagedEntityAliases, nil
+}
+
+// Deletes any entity alias that's not managed if purgeUnmanagedConfig option is enabled, like entities
+// they are only purged if the entity-aliases section is in the configuration.
+func (v *vault) removeUnmanagedEntityAliases(config *viper.Viper, managedEntityAliases []map[string]interface{}) error {
+	if !extConfig.PurgeUnmanagedConfig.Enabled || extConfig.PurgeUnmanagedConfig.Exclude.EntityAliases || !config.IsSet("entity-aliases") {
+		return nil
+	}
+
+	unmanagedEntityAliases, err := v.getUnmanagedEntityAliases(managedEntityAliases)
+	if err != nil {
+		return err
+	}
+
+	for id, name := range unmanagedEntityAliases {
+		logrus.Infof("removing unmanaged entity-alias %s - ID: %s", name, id)
+		if _, err := v.cl.Logical().Delete(fmt.Sprintf("identity/entity-alias/id/%s", id)); err != nil {
+			return errors.Wrapf(err, "error deleting entity-alias %s from vault", name)
+		}
+	}
+
+	return nil
+}
+
+// readVaultEntity returns nil if the entity doesn't exist
+func readVaultEntity(entity string, client *api.Client) (*api.Secret, error) {
+	secret, err := client.Logical().Read(fmt.Sprintf("identity/entity/name/%s", entity))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read entity %s by name", entity)
+	}
+
+	return secret, nil
+}
+
+func getVaultEntityID(entity string, client *api.Client) (string, error) {
+	e, err := readVaultEntity(entity, client)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading entity %s", entity)
+	}
+	if e == nil {
+		return "", errors.Errorf("entity %s does not exist", entity)
+	}
+
+	return cast.ToString(e.Data["id"]), nil
+}
+
+// listVaultEntityAliases returns the name, mount_accessor and canonical_id of the entity aliases by their IDs
+func listVaultEntityAliases(client *api.Client) (map[string]map[string]interface{}, error) {
+	aliases, err := client.Logical().List("identity/entity-alias/id")
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing entity aliases")
+	}
+
+	result := map[string]map[string]interface{}{}
+	if aliases == nil {
+		return result, nil
+	}
+
+	for id, info := range cast.ToStringMap(aliases.Data["key_info"]) {
+		result[id] = cast.ToStringMap(info)
+	}
+
+	return result, nil
+}
+
+func findVaultEntityAliasID(aliases map[string]map[string]interface{}, name, accessor string) string {
+	for id, alias := range aliases {
+		if cast.ToString(alias["name"]) == name && cast.ToString(alias["mount_accessor"]) == accessor {
+			return id
+		}
+	}
+
+	return ""
+}
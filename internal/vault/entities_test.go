@@ -0,0 +1,87 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigureIdentityEntities(t *testing.T) {
+	fake := &fakeVault{responses: map[string]string{
+		"GET /v1/sys/auth":                       `{"data": {"ldap/": {"type": "ldap", "accessor": "auth_ldap_1"}, "oidc/": {"type": "oidc", "accessor": "auth_oidc_1"}}}`,
+		"GET /v1/identity/entity/name/alice":     `{"data": {"id": "entity-alice", "name": "alice", "direct_group_ids": ["group-old"]}}`,
+		"GET /v1/identity/group/name/admins":     `{"data": {"id": "group-admins", "name": "admins", "type": "internal"}}`,
+		"GET /v1/identity/group/id/group-admins": `{"data": {"id": "group-admins", "name": "admins", "type": "internal", "member_entity_ids": ["entity-bob"]}}`,
+		"GET /v1/identity/group/id/group-old":    `{"data": {"id": "group-old", "name": "old", "type": "internal", "member_entity_ids": ["entity-alice", "entity-bob"]}}`,
+		"LIST /v1/identity/entity-alias/id":      `{"data": {"keys": ["alias-1", "alias-2"], "key_info": {"alias-1": {"name": "alice", "mount_accessor": "auth_ldap_1", "canonical_id": "entity-alice"}, "alias-2": {"name": "bob", "mount_accessor": "auth_ldap_1", "canonical_id": "entity-bob"}}}}`,
+		"LIST /v1/identity/entity/name":          `{"data": {"keys": ["alice", "bob"]}}`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cl, err := api.NewClient(&api.Config{Address: server.URL})
+	assert.NoError(t, err)
+
+	v := &vault{cl: cl}
+
+	config := viper.New()
+	config.SetConfigType("yaml")
+	assert.NoError(t, config.ReadConfig(strings.NewReader(`
+entities:
+  - name: alice
+    policies:
+      - admin
+    metadata:
+      team: platform
+    groups:
+      - admins
+entity-aliases:
+  - name: alice
+    mountpath: ldap
+    entity: alice
+  - name: alice@example.com
+    mountpath: oidc/
+    entity: alice
+`)))
+
+	extConfig = externalConfig{}
+	extConfig.PurgeUnmanagedConfig.Enabled = true
+	extConfig.PurgeUnmanagedConfig.Exclude.Entities = true
+	defer func() { extConfig = externalConfig{} }()
+
+	assert.NoError(t, v.configureIdentityEntities(config))
+
+	assert.Equal(t, map[string]interface{}{
+		"policies": []interface{}{"admin"},
+		"metadata": map[string]interface{}{"team": "platform"},
+		"disabled": false,
+	}, fake.written["/v1/identity/entity/name/alice"])
+
+	// alice is added to admins and removed from old, other members are kept
+	assert.Equal(t, map[string]interface{}{"member_entity_ids": []interface{}{"entity-alice", "entity-bob"}}, fake.written["/v1/identity/group/id/group-admins"])
+	assert.Equal(t, map[string]interface{}{"member_entity_ids": []interface{}{"entity-bob"}}, fake.written["/v1/identity/group/id/group-old"])
+
+	assert.Equal(t, map[string]interface{}{"name": "alice", "mount_accessor": "auth_ldap_1", "canonical_id": "entity-alice"}, fake.written["/v1/identity/entity-alias/id/alias-1"])
+	assert.Equal(t, map[string]interface{}{"name": "alice@example.com", "mount_accessor": "auth_oidc_1", "canonical_id": "entity-alice"}, fake.written["/v1/identity/entity-alias"])
+
+	// entities are excluded from the purge
+	assert.Equal(t, []string{"/v1/identity/entity-alias/id/alias-2"}, fake.deleted)
+}
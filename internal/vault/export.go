@@ -0,0 +1,605 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+)
+
+// exportableSecretEngineConfigs holds the named config options per secret engine type,
+// which can be listed and read back from Vault in the same format as they are written.
+var exportableSecretEngineConfigs = map[string][]string{
+	"ad":         {"roles"},
+	"aws":        {"roles"},
+	"azure":      {"roles"},
+	"consul":     {"roles"},
+	"database":   {"config", "roles", "static-roles"},
+	"kubernetes": {"roles"},
+	"nomad":      {"role"},
+	"pki":        {"roles"},
+	"rabbitmq":   {"roles"},
+	"ssh":        {"roles"},
+	"transit":    {"keys"},
+}
+
+// createOnlySecretEngineConfigs holds the config options which contain credentials or key material
+// that can't be read back from Vault, these are exported with create_only, so configure never
+// overwrites them with the incomplete exported data.
+var createOnlySecretEngineConfigs = map[string]bool{
+	"config":      true,
+	"config/root": true,
+	"keys":        true,
+}
+
+// ExportedConfig is the live state of Vault in the externalConfig format.
+type ExportedConfig struct {
+	Auth         []map[string]interface{} `json:"auth,omitempty"`
+	Policies     []map[string]interface{} `json:"policies,omitempty"`
+	Secrets      []map[string]interface{} `json:"secrets,omitempty"`
+	Audit        []map[string]interface{} `json:"audit,omitempty"`
+	Groups       []map[string]interface{} `json:"groups,omitempty"`
+	GroupAliases []map[string]interface{} `json:"group-aliases,omitempty"`
+}
+
+// Export reads the auth methods, policies, secrets engines, audit devices and identity groups
+// from Vault and returns them in the externalConfig format. Values which Vault never returns
+// (passwords, secret keys, JWTs) are missing from the result.
+func (v *vault) Export(ctx context.Context) (*ExportedConfig, error) {
+	// A token set on the client (for example with VAULT_TOKEN) takes precedence over the stored root token
+	if v.cl.Token() == "" {
+		clearToken, err := v.useRootToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer clearToken()
+	}
+
+	var exported ExportedConfig
+	var err error
+
+	if exported.Auth, err = v.exportAuthMethods(); err != nil {
+		return nil, errors.Wrap(err, "error exporting auth methods")
+	}
+
+	if exported.Policies, err = v.exportPolicies(); err != nil {
+		return nil, errors.Wrap(err, "error exporting policies")
+	}
+
+	if exported.Secrets, err = v.exportSecretsEngines(); err != nil {
+		return nil, errors.Wrap(err, "error exporting secrets engines")
+	}
+
+	if exported.Audit, err = v.exportAuditDevices(); err != nil {
+		return nil, errors.Wrap(err, "error exporting audit devices")
+	}
+
+	if exported.Groups, exported.GroupAliases, err = v.exportIdentityGroups(); err != nil {
+		return nil, errors.Wrap(err, "error exporting identity groups")
+	}
+
+	return &exported, nil
+}
+
+func (v *vault) exportAuthMethods() ([]map[string]interface{}, error) {
+	mounts, err := v.cl.Sys().ListAuth()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list existing auth methods")
+	}
+
+	var authMethods []map[string]interface{}
+
+	for _, mountPath := range sortedMountPaths(mounts) {
+		mount := mounts[mountPath]
+		authMethod := auth{Type: mount.Type, Path: strings.Trim(mountPath, "/")}
+
+		entry := map[string]interface{}{"type": authMethod.Type}
+		if authMethod.Path != authMethod.Type {
+			entry["path"] = authMethod.Path
+		}
+		if mount.Description != "" {
+			entry["description"] = mount.Description
+		}
+		if options := exportMountConfig(mount.Config); options != nil {
+			entry["options"] = options
+		}
+
+		if configPath := authConfigPath(authMethod); configPath != "" {
+			config, err := v.exportData(configPath)
+			if err != nil {
+				return nil, err
+			}
+			if len(config) > 0 {
+				entry["config"] = config
+			}
+		}
+
+		if rolesPath := authRolesPath(authMethod); rolesPath != "" {
+			roles, err := v.exportList(rolesPath, "name")
+			if err != nil {
+				return nil, err
+			}
+			if len(roles) > 0 {
+				entry["roles"] = roles
+			}
+		}
+
+		if err := v.exportAdditionalAuthConfig(authMethod, entry); err != nil {
+			return nil, errors.Wrapf(err, "error exporting %s auth method", authMethod.Path)
+		}
+
+		// The token auth method is always there, only export it when it has something to configure
+		if authMethod.Type == "token" && len(entry) == 1 {
+			continue
+		}
+
+		authMethods = append(authMethods, entry)
+	}
+
+	return authMethods, nil
+}
+
+// exportAdditionalAuthConfig exports the auth method specific sections, see addAdditionalAuthConfig.
+func (v *vault) exportAdditionalAuthConfig(authMethod auth, entry map[string]interface{}) error {
+	switch authMethod.Type {
+	case "github":
+		mappings := map[string]interface{}{}
+		for _, mappingType := range []string{"teams", "users"} {
+			mapping, err := v.exportMap(fmt.Sprintf("auth/%s/map/%s", authMethod.Path, mappingType))
+			if err != nil {
+				return err
+			}
+			if len(mapping) == 0 {
+				continue
+			}
+
+			values := map[string]interface{}{}
+			for userOrTeam, data := range mapping {
+				values[userOrTeam] = cast.ToStringMap(data)["value"]
+			}
+			mappings[mappingType] = values
+		}
+		if len(mappings) > 0 {
+			entry["map"] = mappings
+		}
+
+	case "aws":
+		crossAccountRoles, err := v.exportList(fmt.Sprintf("auth/%s/config/sts", authMethod.Path), "sts_account")
+		if err != nil {
+			return err
+		}
+		if len(crossAccountRoles) > 0 {
+			entry["crossaccountrole"] = crossAccountRoles
+		}
+
+	case "ldap", "okta":
+		users, err := v.exportMap(fmt.Sprintf("auth/%s/users", authMethod.Path))
+		if err != nil {
+			return err
+		}
+		if len(users) > 0 {
+			entry["users"] = users
+		}
+
+		groups, err := v.exportMap(fmt.Sprintf("auth/%s/groups", authMethod.Path))
+		if err != nil {
+			return err
+		}
+		if len(groups) > 0 {
+			entry["groups"] = groups
+		}
+
+	case "userpass":
+		users, err := v.exportList(fmt.Sprintf("auth/%s/users", authMethod.Path), "username")
+		if err != nil {
+			return err
+		}
+		if len(users) > 0 {
+			entry["users"] = users
+		}
+	}
+
+	return nil
+}
+
+// exportPolicies exports all policies, except the built-in root and default ones.
+func (v *vault) exportPolicies() ([]map[string]interface{}, error) {
+	policyNames, err := v.cl.Sys().ListPolicies()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list existing policies")
+	}
+
+	sort.Strings(policyNames)
+
+	var policies []map[string]interface{}
+
+	for _, policyName := range policyNames {
+		if policyName == "root" || policyName == "default" {
+			continue
+		}
+
+		rules, err := v.cl.Sys().GetPolicy(policyName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s policy from vault", policyName)
+		}
+
+		policies = append(policies, map[string]interface{}{
+			"name":  policyName,
+			"rules": rules,
+		})
+	}
+
+	return policies, nil
+}
+
+func (v *vault) exportSecretsEngines() ([]map[string]interface{}, error) {
+	mounts, err := v.cl.Sys().ListMounts()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list existing secrets engines")
+	}
+
+	var secretsEngines []map[string]interface{}
+
+	for _, mountPath := range sortedMountPaths(mounts) {
+		mount := mounts[mountPath]
+		path := strings.Trim(mountPath, "/")
+
+		// Ignore system mounts.
+		if path == "sys" || path == "identity" || path == "cubbyhole" {
+			continue
+		}
+
+		entry := map[string]interface{}{
+			"path": path,
+			"type": mount.Type,
+		}
+		if mount.Description != "" {
+			entry["description"] = mount.Description
+		}
+		if len(mount.Options) > 0 {
+			entry["options"] = mount.Options
+		}
+		if config := exportMountConfig(mount.Config); config != nil {
+			entry["config"] = config
+		}
+		if mount.Local {
+			entry["local"] = true
+		}
+		if mount.SealWrap {
+			entry["seal_wrap"] = true
+		}
+
+		configuration, err := v.exportSecretEngineConfiguration(path, mount)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error exporting %s secret engine", path)
+		}
+		if len(configuration) > 0 {
+			entry["configuration"] = configuration
+		}
+
+		secretsEngines = append(secretsEngines, entry)
+	}
+
+	return secretsEngines, nil
+}
+
+func (v *vault) exportSecretEngineConfiguration(path string, mount *api.MountOutput) (map[string]interface{}, error) {
+	configuration := map[string]interface{}{}
+
+	var unnamedConfigOptions []string
+	switch {
+	case mount.Type == "kv" && mount.Options["version"] != "2":
+		// kv version 1 has no configuration
+	case mount.Type == "aws":
+		unnamedConfigOptions = []string{"config/root"}
+	case configNeedsNoName(mount.Type, "config"):
+		unnamedConfigOptions = []string{"config"}
+	}
+
+	for _, configOption := range unnamedConfigOptions {
+		data, err := v.exportData(secretEngineConfigPath(path, configOption, nil))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		if mount.Type != "kv" && createOnlySecretEngineConfigs[configOption] {
+			data["create_only"] = true
+		}
+
+		configuration[configOption] = []interface{}{data}
+	}
+
+	for _, configOption := range exportableSecretEngineConfigs[mount.Type] {
+		entries, err := v.exportList(fmt.Sprintf("%s/%s", path, configOption), "name")
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		for _, entry := range entries {
+			data := entry.(map[string]interface{})
+
+			// The database connection is written with flat parameters, but read back as a nested object
+			if mount.Type == "database" && configOption == "config" {
+				for k, v := range cast.ToStringMap(data["connection_details"]) {
+					data[k] = v
+				}
+				delete(data, "connection_details")
+			}
+
+			if createOnlySecretEngineConfigs[configOption] {
+				data["create_only"] = true
+			}
+		}
+
+		configuration[configOption] = entries
+	}
+
+	return configuration, nil
+}
+
+func (v *vault) exportAuditDevices() ([]map[string]interface{}, error) {
+	audits, err := v.cl.Sys().ListAudit()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading audit mounts from vault")
+	}
+
+	paths := make([]string, 0, len(audits))
+	for path := range audits {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var auditDevices []map[string]interface{}
+
+	for _, path := range paths {
+		audit := audits[path]
+
+		entry := map[string]interface{}{"type": audit.Type}
+		if path := strings.Trim(path, "/"); path != audit.Type {
+			entry["path"] = path
+		}
+		if audit.Description != "" {
+			entry["description"] = audit.Description
+		}
+		if len(audit.Options) > 0 {
+			entry["options"] = audit.Options
+		}
+		if audit.Local {
+			entry["local"] = true
+		}
+
+		auditDevices = append(auditDevices, entry)
+	}
+
+	return auditDevices, nil
+}
+
+// exportIdentityGroups exports the external groups and their aliases, internal groups
+// are skipped since configureIdentityGroups doesn't support them.
+func (v *vault) exportIdentityGroups() ([]map[string]interface{}, []map[string]interface{}, error) {
+	groupNames, err := v.exportKeys("identity/group/name")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(groupNames) == 0 {
+		return nil, nil, nil
+	}
+
+	authMounts, err := v.cl.Sys().ListAuth()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read auth mounts from vault")
+	}
+
+	mountPaths := make(map[string]string, len(authMounts))
+	for path, mount := range authMounts {
+		mountPaths[mount.Accessor] = strings.Trim(path, "/")
+	}
+
+	var groups, groupAliases []map[string]interface{}
+
+	for _, groupName := range groupNames {
+		g, err := readVaultGroup(groupName, v.cl)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error reading group")
+		}
+		if g == nil {
+			continue
+		}
+
+		if groupType := cast.ToString(g.Data["type"]); groupType != "external" {
+			logrus.Warnf("skipping %s group %s, only external groups are supported", groupType, groupName)
+			continue
+		}
+
+		group := map[string]interface{}{
+			"name": groupName,
+			"type": "external",
+		}
+		if policies := cast.ToStringSlice(g.Data["policies"]); len(policies) > 0 {
+			group["policies"] = policies
+		}
+		if metadata := cast.ToStringMap(g.Data["metadata"]); len(metadata) > 0 {
+			group["metadata"] = metadata
+		}
+
+		groups = append(groups, group)
+
+		alias := cast.ToStringMap(g.Data["alias"])
+		if aliasName := cast.ToString(alias["name"]); aliasName != "" {
+			accessor := cast.ToString(alias["mount_accessor"])
+			mountPath, ok := mountPaths[accessor]
+			if !ok {
+				return nil, nil, errors.Errorf("auth mount with accessor %s of group alias %s does not exist", accessor, aliasName)
+			}
+
+			groupAliases = append(groupAliases, map[string]interface{}{
+				"name":      aliasName,
+				"mountpath": mountPath,
+				"group":     groupName,
+			})
+		}
+	}
+
+	return groups, groupAliases, nil
+}
+
+// exportKeys lists the keys under the given path, a missing path results in an empty list.
+func (v *vault) exportKeys(path string) ([]string, error) {
+	secret, err := v.cl.Logical().List(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing %s", path)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+
+	keys, err := cast.ToStringSliceE(secret.Data["keys"])
+	if err != nil {
+		return nil, errors.Wrapf(err, "error converting keys of %s", path)
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// exportData reads the given path, dropping the unset (null) values.
+func (v *vault) exportData(path string) (map[string]interface{}, error) {
+	secret, err := v.cl.Logical().Read(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", path)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+
+	data := make(map[string]interface{}, len(secret.Data))
+	for k, v := range secret.Data {
+		if v != nil {
+			data[k] = v
+		}
+	}
+
+	return data, nil
+}
+
+// exportList reads every entry under the given path into a list, storing the key of each entry as nameKey.
+func (v *vault) exportList(path, nameKey string) ([]interface{}, error) {
+	keys, err := v.exportKeys(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []interface{}
+	for _, key := range keys {
+		data, err := v.exportData(fmt.Sprintf("%s/%s", path, key))
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			data = map[string]interface{}{}
+		}
+
+		data[nameKey] = key
+		entries = append(entries, data)
+	}
+
+	return entries, nil
+}
+
+// exportMap reads every entry under the given path into a map keyed by the entry names.
+func (v *vault) exportMap(path string) (map[string]interface{}, error) {
+	keys, err := v.exportKeys(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		data, err := v.exportData(fmt.Sprintf("%s/%s", path, key))
+		if err != nil {
+			return nil, err
+		}
+
+		entries[key] = data
+	}
+
+	return entries, nil
+}
+
+// exportMountConfig returns the tune options of a mount which are not left on the defaults.
+func exportMountConfig(config api.MountConfigOutput) map[string]interface{} {
+	options := map[string]interface{}{}
+
+	// The mount tune input expects duration strings
+	if config.DefaultLeaseTTL != 0 {
+		options["default_lease_ttl"] = fmt.Sprintf("%ds", config.DefaultLeaseTTL)
+	}
+	if config.MaxLeaseTTL != 0 {
+		options["max_lease_ttl"] = fmt.Sprintf("%ds", config.MaxLeaseTTL)
+	}
+	if config.ForceNoCache {
+		options["force_no_cache"] = true
+	}
+	if len(config.AuditNonHMACRequestKeys) > 0 {
+		options["audit_non_hmac_request_keys"] = config.AuditNonHMACRequestKeys
+	}
+	if len(config.AuditNonHMACResponseKeys) > 0 {
+		options["audit_non_hmac_response_keys"] = config.AuditNonHMACResponseKeys
+	}
+	if config.ListingVisibility != "" {
+		options["listing_visibility"] = config.ListingVisibility
+	}
+	if len(config.PassthroughRequestHeaders) > 0 {
+		options["passthrough_request_headers"] = config.PassthroughRequestHeaders
+	}
+	if len(config.AllowedResponseHeaders) > 0 {
+		options["allowed_response_headers"] = config.AllowedResponseHeaders
+	}
+	if config.TokenType != "" && config.TokenType != "default-service" {
+		options["token_type"] = config.TokenType
+	}
+
+	if len(options) == 0 {
+		return nil
+	}
+
+	return options
+}
+
+func sortedMountPaths(mounts map[string]*api.MountOutput) []string {
+	paths := make([]string, 0, len(mounts))
+	for path := range mounts {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
@@ -0,0 +1,41 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportMountConfig(t *testing.T) {
+	assert.Nil(t, exportMountConfig(api.MountConfigOutput{TokenType: "default-service"}))
+
+	config := api.MountConfigOutput{
+		DefaultLeaseTTL:   3600,
+		ListingVisibility: "unauth",
+		TokenType:         "default-service",
+	}
+
+	exported := exportMountConfig(config)
+	assert.Equal(t, map[string]interface{}{
+		"default_lease_ttl":  "3600s",
+		"listing_visibility": "unauth",
+	}, exported)
+
+	// Applying the exported options again must not result in any changes
+	assert.Empty(t, mountConfigChanges(exported, config))
+}
@@ -0,0 +1,201 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"runtime"
+
+	"emperror.dev/errors"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// GenerateRoot generates a new root token with the unseal keys (or recovery keys in case of auto-unseal)
+// in the key store, replaces the stored root token with it and revokes the previously stored one.
+// If the root token shouldn't be stored it is only logged. The new root token is encrypted to the age
+// recipient if configured, the stored keys have to be unencrypted.
+func (v *vault) GenerateRoot(ctx context.Context) error {
+	defer runtime.GC()
+
+	if v.config.RootTokenPGPKey != "" {
+		return errors.New("the new root token can't be encrypted with a PGP key, since the previous one is revoked with it, use an age recipient instead")
+	}
+
+	sealStatus, err := v.cl.Sys().SealStatus()
+	if err != nil {
+		return errors.Wrap(err, "error checking status")
+	}
+	if sealStatus.Sealed {
+		return errors.New("vault is sealed, can't generate root token")
+	}
+
+	keyForID := v.unsealKeyForID
+	if sealStatus.RecoverySeal {
+		keyForID = v.recoveryKeyForID
+	}
+
+	status, err := v.cl.Sys().GenerateRootStatus()
+	if err != nil {
+		return errors.Wrap(err, "error checking root token generation status")
+	}
+	if status.Started {
+		return errors.New("a root token generation is already in progress, cancel it first with 'vault operator generate-root -cancel'")
+	}
+	if status.OTPLength == 0 {
+		return errors.New("vault version is too old to generate root tokens with a one-time-password")
+	}
+
+	// Don't start a root token generation which can't be completed with the stored keys
+	if _, err := v.storedKey(ctx, keyForID(0)); err != nil {
+		return err
+	}
+
+	otp, err := generateOTP(status.OTPLength)
+	if err != nil {
+		return errors.Wrap(err, "error generating one-time-password")
+	}
+
+	status, err = v.cl.Sys().GenerateRootInit(otp, "")
+	if err != nil {
+		return errors.Wrap(err, "error starting root token generation")
+	}
+
+	logrus.Infof("root token generation started, submitting %d of the stored keys", status.Required)
+
+	for i := 0; !status.Complete; i++ {
+		k, err := v.storedKey(ctx, keyForID(i))
+		if err == nil {
+			status, err = v.cl.Sys().GenerateRootUpdate(string(k), status.Nonce)
+			err = errors.Wrap(err, "error sending root token generation update to vault")
+		}
+
+		if err != nil {
+			if cancelErr := v.cl.Sys().GenerateRootCancel(); cancelErr != nil {
+				return errors.Combine(err, errors.Wrap(cancelErr, "error canceling root token generation"))
+			}
+
+			return err
+		}
+	}
+
+	rootToken, err := decodeRootToken(status.EncodedRootToken, otp)
+	if err != nil {
+		return err
+	}
+
+	rootTokenValue, err := v.encryptRootToken(rootToken)
+	if err != nil {
+		return errors.Wrap(err, "error encrypting root token")
+	}
+
+	if !v.config.StoreRootToken {
+		logrus.WithField("root-token", string(rootTokenValue)).Warnf("won't store root token in key store, this token grants full privileges to vault, so keep this secret")
+
+		return nil
+	}
+
+	newClient, err := v.cl.Clone()
+	if err != nil {
+		return errors.Wrap(err, "error creating vault client")
+	}
+	newClient.SetToken(rootToken)
+
+	oldRootToken, err := kv.GetWithContext(ctx, v.keyStore, v.rootTokenKey())
+	if err != nil && !isNotFoundError(err) {
+		err = errors.Wrapf(err, "unable to get key '%s'", v.rootTokenKey())
+	} else {
+		err = v.setAndVerify(ctx, v.rootTokenKey(), rootTokenValue)
+	}
+	if err != nil {
+		// Don't leave a root token behind which isn't stored anywhere
+		if revokeErr := newClient.Auth().Token().RevokeSelf(""); revokeErr != nil {
+			return errors.Combine(err, errors.Wrap(revokeErr, "error revoking the new root token"))
+		}
+
+		return err
+	}
+
+	logrus.WithField("key", v.rootTokenKey()).Info("root token replaced in key store")
+
+	if encryptedToCustodian(oldRootToken) {
+		logrus.Warn("previous root token is encrypted to a custodian, it has to be revoked with 'vault token revoke' after decrypting it")
+	} else if len(oldRootToken) > 0 && string(oldRootToken) != rootToken {
+		err = newClient.Auth().Token().RevokeOrphan(string(oldRootToken))
+		if err != nil {
+			var respErr *api.ResponseError
+			if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusBadRequest {
+				return errors.Wrap(err, "error revoking the previous root token")
+			}
+
+			logrus.Info("previous root token was already invalid")
+		} else {
+			logrus.Info("previous root token revoked")
+		}
+	}
+
+	return nil
+}
+
+// generateOTP generates a random base62 one-time-password, the same way as the Vault CLI does
+func generateOTP(length int) (string, error) {
+	const charset = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	otp := make([]byte, length)
+	for i := range otp {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", errors.Wrap(err, "error reading random bytes")
+		}
+		otp[i] = charset[n.Int64()]
+	}
+
+	return string(otp), nil
+}
+
+// decodeRootToken decodes the root token returned by the sys/generate-root flow with the one-time-password
+func decodeRootToken(encoded, otp string) (string, error) {
+	tokenBytes, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.Wrap(err, "error decoding root token")
+	}
+
+	tokenBytes, err = xorBytes(tokenBytes, []byte(otp))
+	if err != nil {
+		return "", errors.Wrap(err, "error decoding root token")
+	}
+
+	return string(tokenBytes), nil
+}
+
+// xorBytes returns the XOR of two byte slices of the same length
+func xorBytes(a, b []byte) ([]byte, error) {
+	if len(a) != len(b) {
+		return nil, errors.Errorf("length of byte slices is not equivalent: %d != %d", len(a), len(b))
+	}
+
+	result := make([]byte, len(a))
+	for i := range a {
+		result[i] = a[i] ^ b[i]
+	}
+
+	return result, nil
+}
@@ -0,0 +1,143 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+
+	"emperror.dev/errors"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// keyEncryptionForConfig validates the key share encryption settings and parses the age recipients.
+// Shares can be encrypted either by Vault with PGP or client-side with age, but not both.
+func keyEncryptionForConfig(config Config) ([]age.Recipient, age.Recipient, error) {
+	if len(config.PGPKeys) > 0 && len(config.AgeRecipients) > 0 {
+		return nil, nil, errors.New("key shares can be encrypted either with PGP keys or age recipients, not both")
+	}
+	if config.RootTokenPGPKey != "" && config.RootTokenAgeRecipient != "" {
+		return nil, nil, errors.New("the root token can be encrypted either with a PGP key or an age recipient, not both")
+	}
+	if len(config.PGPKeys) > 0 && len(config.PGPKeys) != config.SecretShares {
+		return nil, nil, errors.Errorf("the number of PGP keys must match the secret shares [%d != %d]", len(config.PGPKeys), config.SecretShares)
+	}
+	if len(config.AgeRecipients) > 0 && len(config.AgeRecipients) != config.SecretShares {
+		return nil, nil, errors.Errorf("the number of age recipients must match the secret shares [%d != %d]", len(config.AgeRecipients), config.SecretShares)
+	}
+	if config.InitRootToken != "" && (config.RootTokenPGPKey != "" || config.RootTokenAgeRecipient != "") {
+		return nil, nil, errors.New("the init root token can't be used together with root token encryption")
+	}
+
+	var shareRecipients []age.Recipient
+	for i, r := range config.AgeRecipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid age recipient for key share %d", i)
+		}
+		shareRecipients = append(shareRecipients, recipient)
+	}
+
+	var rootTokenRecipient age.Recipient
+	if config.RootTokenAgeRecipient != "" {
+		recipient, err := age.ParseX25519Recipient(config.RootTokenAgeRecipient)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid age recipient for the root token")
+		}
+		rootTokenRecipient = recipient
+	}
+
+	return shareRecipients, rootTokenRecipient, nil
+}
+
+// sharesEncrypted returns true if the key shares returned by init are not stored in plain text
+func (v *vault) sharesEncrypted() bool {
+	return len(v.config.PGPKeys) > 0 || len(v.shareRecipients) > 0
+}
+
+// encryptShare encrypts the i-th key share to its age recipient if configured,
+// PGP encrypted shares are already returned in an encrypted form by Vault.
+func (v *vault) encryptShare(i int, share string) ([]byte, error) {
+	if len(v.shareRecipients) == 0 {
+		return []byte(share), nil
+	}
+
+	if i >= len(v.shareRecipients) {
+		return nil, errors.Errorf("no age recipient configured for key share %d", i)
+	}
+
+	return ageEncrypt(v.shareRecipients[i], []byte(share))
+}
+
+func (v *vault) encryptRootToken(rootToken string) ([]byte, error) {
+	if v.rootTokenRecipient == nil {
+		return []byte(rootToken), nil
+	}
+
+	return ageEncrypt(v.rootTokenRecipient, []byte(rootToken))
+}
+
+// maxPlainShareLength is the length of the longest unencrypted key share returned by Vault,
+// a share of the 32 bytes long key with its x coordinate
+const maxPlainShareLength = 33
+
+// encryptedToCustodian detects the key shares and root tokens which were stored encrypted to a custodian
+// at init: age encrypted ones are ASCII armored, PGP encrypted ones are returned by Vault as an OpenPGP
+// message (hex encoded shares, base64 encoded root token) starting with a public-key encrypted session key packet.
+func encryptedToCustodian(value []byte) bool {
+	if bytes.HasPrefix(bytes.TrimSpace(value), []byte(armor.Header)) {
+		return true
+	}
+
+	message, err := hex.DecodeString(string(value))
+	if err != nil {
+		message, err = base64.StdEncoding.DecodeString(string(value))
+	}
+	if err != nil || len(message) <= maxPlainShareLength {
+		return false
+	}
+
+	// new (0xc1) or old (0x84-0x87) format packet header of tag 1
+	return message[0] == 0xc1 || message[0]&0xfc == 0x84
+}
+
+// ageEncrypt encrypts value to the recipient in the ASCII armored age format,
+// which can be decrypted by the custodian with "age --decrypt".
+func ageEncrypt(recipient age.Recipient, value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	armorWriter := armor.NewWriter(&buf)
+
+	w, err := age.Encrypt(armorWriter, recipient)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating age encryptor")
+	}
+
+	if _, err := w.Write(value); err != nil {
+		return nil, errors.Wrap(err, "error encrypting with age")
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "error finishing age encryption")
+	}
+
+	if err := armorWriter.Close(); err != nil {
+		return nil, errors.Wrap(err, "error finishing age armor")
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,175 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+)
+
+func newFakeInitVault(t *testing.T, config Config, initRequest *api.InitRequest) (Vault, KVService) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp interface{}
+
+		switch {
+		case r.URL.Path == "/v1/sys/init" && r.Method == http.MethodGet:
+			resp = map[string]interface{}{"initialized": false}
+		case r.URL.Path == "/v1/sys/init":
+			_ = json.NewDecoder(r.Body).Decode(initRequest)
+			resp = map[string]interface{}{"keys": []string{"key-0", "key-1"}, "root_token": "root"}
+		case r.URL.Path == "/v1/sys/seal-status":
+			resp = map[string]interface{}{"sealed": true, "type": "shamir"}
+		}
+
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	cl, err := api.NewClient(&api.Config{Address: server.URL})
+	assert.NoError(t, err)
+
+	store, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	v, err := New(store, cl, config)
+	assert.NoError(t, err)
+
+	return v, store
+}
+
+func ageDecrypt(t *testing.T, identity age.Identity, value []byte) string {
+	r, err := age.Decrypt(armor.NewReader(bytes.NewReader(value)), identity)
+	assert.NoError(t, err)
+
+	decrypted, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+
+	return string(decrypted)
+}
+
+func TestInitAgeEncryption(t *testing.T) {
+	var identities []*age.X25519Identity
+	var recipients []string
+	for i := 0; i < 3; i++ {
+		identity, err := age.GenerateX25519Identity()
+		assert.NoError(t, err)
+		identities = append(identities, identity)
+		recipients = append(recipients, identity.Recipient().String())
+	}
+
+	var initRequest api.InitRequest
+	v, store := newFakeInitVault(t, Config{
+		SecretShares:          2,
+		SecretThreshold:       2,
+		StoreRootToken:        true,
+		AgeRecipients:         recipients[:2],
+		RootTokenAgeRecipient: recipients[2],
+	}, &initRequest)
+
+	assert.NoError(t, v.Init(context.Background()))
+	assert.Empty(t, initRequest.PGPKeys)
+
+	for i, key := range []string{"vault-unseal-0", "vault-unseal-1"} {
+		value, err := store.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprint("key-", i), ageDecrypt(t, identities[i], value))
+
+		// Only the custodian of the share can decrypt it
+		_, err = age.Decrypt(armor.NewReader(bytes.NewReader(value)), identities[2])
+		assert.Error(t, err)
+	}
+
+	value, err := store.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, "root", ageDecrypt(t, identities[2], value))
+
+	// The encrypted root token is not sent to Vault
+	err = v.Configure(context.Background(), viper.New())
+	assert.EqualError(t, err, "the root token in key 'vault-root' is encrypted to a custodian, it can't be used to access vault")
+}
+
+func TestEncryptedToCustodian(t *testing.T) {
+	for _, rootToken := range []string{"root", "s.gQW6Xtdiq3dWVsLYhvYpSvDh", "hvs.CAESIJ5P3vZ8", "5d6fbd4c-5d1c-1c4d-8bd6-5a3b1b5f3c2e"} {
+		assert.False(t, encryptedToCustodian([]byte(rootToken)), rootToken)
+	}
+
+	// A plain share may start with the same byte as an OpenPGP message
+	share := append([]byte{0xc1}, bytes.Repeat([]byte{0x42}, maxPlainShareLength-1)...)
+	assert.False(t, encryptedToCustodian([]byte(hex.EncodeToString(share))))
+
+	// OpenPGP messages as returned by Vault, hex encoded shares and a base64 encoded root token
+	message := append([]byte{0xc1, 0xc0, 0x4c, 0x03}, bytes.Repeat([]byte{0x42}, 256)...)
+	assert.True(t, encryptedToCustodian([]byte(hex.EncodeToString(message))))
+	assert.True(t, encryptedToCustodian([]byte(base64.StdEncoding.EncodeToString(message))))
+	message[0] = 0x85
+	assert.True(t, encryptedToCustodian([]byte(hex.EncodeToString(message))))
+
+	assert.True(t, encryptedToCustodian([]byte("-----BEGIN AGE ENCRYPTED FILE-----\nYWdlLWVuY3J5cHRpb24ub3JnL3YxCg==\n")))
+}
+
+func TestInitPGPKeys(t *testing.T) {
+	var initRequest api.InitRequest
+	v, store := newFakeInitVault(t, Config{
+		SecretShares:    2,
+		SecretThreshold: 2,
+		StoreRootToken:  true,
+		PGPKeys:         []string{"keybase:alice", "keybase:bob"},
+		RootTokenPGPKey: "keybase:carol",
+	}, &initRequest)
+
+	assert.NoError(t, v.Init(context.Background()))
+	assert.Equal(t, []string{"keybase:alice", "keybase:bob"}, initRequest.PGPKeys)
+	assert.Empty(t, initRequest.RecoveryPGPKeys)
+	assert.Equal(t, "keybase:carol", initRequest.RootTokenPGPKey)
+
+	// Vault returns the encrypted shares, which are stored as is
+	value, err := store.Get("vault-unseal-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("key-1"), value)
+}
+
+func TestKeyEncryptionConfig(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	recipient := identity.Recipient().String()
+
+	for name, config := range map[string]Config{
+		"pgp and age":            {SecretShares: 1, PGPKeys: []string{"keybase:alice"}, AgeRecipients: []string{recipient}},
+		"root token pgp and age": {SecretShares: 1, RootTokenPGPKey: "keybase:alice", RootTokenAgeRecipient: recipient},
+		"pgp key count":          {SecretShares: 2, PGPKeys: []string{"keybase:alice"}},
+		"age recipient count":    {SecretShares: 2, AgeRecipients: []string{recipient}},
+		"invalid age recipient":  {SecretShares: 1, AgeRecipients: []string{"age1invalid"}},
+		"init root token":        {SecretShares: 1, InitRootToken: "root", RootTokenAgeRecipient: recipient},
+	} {
+		_, err := New(nil, nil, config)
+		assert.Error(t, err, name)
+	}
+}
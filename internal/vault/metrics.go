@@ -0,0 +1,32 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var configureSectionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "vault",
+	Subsystem: "config",
+	Name:      "section_duration_seconds",
+	Help:      "Time spent applying each section of the configuration.",
+	Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+}, []string{"section"})
+
+// RegisterMetrics registers the configuration metrics of the vault helper.
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	return registerer.Register(configureSectionDuration) // nolint:wrapcheck
+}
@@ -0,0 +1,204 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// MigrateKeys copies the unseal keys, recovery keys, the root token and the credential rotation state
+// from one key store to another.
+// Every copied key is read back from the destination and compared to the original, the keys are
+// deleted from the source only if deleteSource is set and all of them were copied successfully.
+// Keys already present in the destination with the same value are skipped, a different value is an error.
+// The names of the migrated keys are returned.
+func MigrateKeys(ctx context.Context, from, to KVService, deleteSource bool) ([]string, error) {
+	source := &vault{keyStore: from}
+	destination := &vault{keyStore: to}
+
+	storedKeys, values, err := source.storedKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(storedKeys) == 0 {
+		return nil, errors.New("no keys found in the source key store")
+	}
+
+	keys := make([]string, 0, len(values))
+	for _, key := range storedKeys {
+		value := values[key]
+
+		existing, err := kv.GetWithContext(ctx, to, key)
+		switch {
+		case err == nil && bytes.Equal(existing, value):
+			logrus.WithField("key", key).Info("key already present in the destination key store")
+		case err == nil:
+			return nil, errors.Errorf("key '%s' already exists in the destination key store with a different value", key)
+		case isNotFoundError(err):
+			if err := destination.keyStoreSet(ctx, key, value); err != nil {
+				return nil, errors.Wrapf(err, "error copying key '%s'", key)
+			}
+		default:
+			return nil, errors.Wrapf(err, "error checking key '%s' in the destination key store", key)
+		}
+
+		copied, err := kv.GetWithContext(ctx, to, key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading back key '%s' from the destination key store", key)
+		}
+		if !bytes.Equal(copied, value) {
+			return nil, errors.Errorf("key '%s' read back from the destination key store doesn't match the source", key)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if deleteSource {
+		for _, key := range keys {
+			if err := kv.Delete(from, key); err != nil {
+				return keys, errors.Wrapf(err, "error deleting key '%s' from the source key store", key)
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// keyProbeGap is the number of indexes probed after the first missing unseal or recovery key
+// in the key stores which can't list their keys, to detect the keys stored after a gap
+const keyProbeGap = 5
+
+// storedKeys reads every key known to the vault helper from the key store. A missing unseal or
+// recovery key followed by more keys is an error, so a partial set of keys is never migrated.
+// The credential rotation keys can only be found in the key stores which can list their keys.
+func (v *vault) storedKeys(ctx context.Context) ([]string, map[string][]byte, error) {
+	var keys []string
+	values := map[string][]byte{}
+
+	read := func(key string) error {
+		value, err := kv.GetWithContext(ctx, v.keyStore, key)
+		if err != nil {
+			return errors.Wrapf(err, "error reading key '%s' from the source key store", key)
+		}
+
+		keys = append(keys, key)
+		values[key] = value
+
+		return nil
+	}
+
+	for _, keyForID := range []func(int) string{v.unsealKeyForID, v.recoveryKeyForID} {
+		count, err := v.storedKeyCount(ctx, keyForID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for i := 0; i < count; i++ {
+			if err := read(keyForID(i)); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	notFound, err := v.keyStoreNotFound(ctx, v.rootTokenKey())
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error reading key '%s' from the source key store", v.rootTokenKey())
+	}
+	if !notFound {
+		if err := read(v.rootTokenKey()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rotationKeys, err := kv.List(v.keyStore, rotationKeyPrefix)
+	if errors.Is(err, kv.ErrNotSupported) {
+		logrus.Warnf("the source key store can't list its keys, the credential rotation state ('%s*' keys) isn't migrated, "+
+			"the credentials will be rotated again", rotationKeyPrefix)
+	} else if err != nil {
+		return nil, nil, errors.Wrapf(err, "error listing keys with prefix '%s' in the source key store", rotationKeyPrefix)
+	}
+
+	sort.Strings(rotationKeys)
+	for _, key := range rotationKeys {
+		if err := read(key); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return keys, values, nil
+}
+
+// storedKeyCount returns the number of the keys of keyForID in the key store, which have to be stored
+// from index 0 without gaps. The keys are listed if the key store supports it, otherwise probed by index.
+func (v *vault) storedKeyCount(ctx context.Context, keyForID func(int) string) (int, error) {
+	prefix := strings.TrimSuffix(keyForID(0), "0")
+
+	listed, err := kv.List(v.keyStore, prefix)
+	if err != nil && !errors.Is(err, kv.ErrNotSupported) {
+		return 0, errors.Wrapf(err, "error listing keys with prefix '%s' in the source key store", prefix)
+	}
+
+	if err == nil {
+		ids := map[int]bool{}
+		last := -1
+		for _, key := range listed {
+			i, err := strconv.Atoi(strings.TrimPrefix(key, prefix))
+			if err != nil || keyForID(i) != key {
+				continue
+			}
+
+			ids[i] = true
+			if i > last {
+				last = i
+			}
+		}
+
+		for i := 0; i <= last; i++ {
+			if !ids[i] {
+				return 0, errors.Errorf("key '%s' is missing from the source key store, but '%s' exists", keyForID(i), keyForID(last))
+			}
+		}
+
+		return last + 1, nil
+	}
+
+	count := 0
+	for i := 0; i < count+keyProbeGap; i++ {
+		notFound, err := v.keyStoreNotFound(ctx, keyForID(i))
+		if err != nil {
+			return 0, errors.Wrapf(err, "error reading key '%s' from the source key store", keyForID(i))
+		}
+		if notFound {
+			continue
+		}
+
+		if i > count {
+			return 0, errors.Errorf("key '%s' is missing from the source key store, but '%s' exists", keyForID(count), keyForID(i))
+		}
+		count++
+	}
+
+	return count, nil
+}
@@ -0,0 +1,115 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+)
+
+func TestMigrateKeys(t *testing.T) {
+	from, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	to, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	for _, key := range []string{"vault-unseal-0", "vault-unseal-1", "vault-recovery-0", "vault-root", "vault-rotation-database.rotate-root.my-mysql", "unrelated"} {
+		assert.NoError(t, from.Set(key, []byte(key)))
+	}
+
+	// Keys already migrated with the same value are accepted
+	assert.NoError(t, to.Set("vault-unseal-0", []byte("vault-unseal-0")))
+
+	keys, err := MigrateKeys(context.Background(), from, to, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-unseal-0", "vault-unseal-1", "vault-recovery-0", "vault-root", "vault-rotation-database.rotate-root.my-mysql"}, keys)
+
+	for _, key := range keys {
+		value, err := to.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(key), value)
+
+		_, err = from.Get(key)
+		assert.True(t, kv.IsNotFoundError(err))
+	}
+
+	remaining, err := kv.List(from, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"unrelated"}, remaining)
+
+	// Nothing left to migrate
+	_, err = MigrateKeys(context.Background(), from, to, false)
+	assert.Error(t, err)
+}
+
+func TestMigrateKeysConflict(t *testing.T) {
+	from, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	to, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	assert.NoError(t, from.Set("vault-root", []byte("new-token")))
+	assert.NoError(t, to.Set("vault-root", []byte("old-token")))
+
+	_, err = MigrateKeys(context.Background(), from, to, true)
+	assert.Error(t, err)
+
+	value, err := from.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new-token"), value)
+}
+
+func TestMigrateKeysGap(t *testing.T) {
+	listing, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	// The same store without List support, where the keys are probed
+	probing := struct{ kv.Service }{listing}
+
+	to, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	for _, key := range []string{"vault-unseal-0", "vault-unseal-2", "vault-root"} {
+		assert.NoError(t, listing.Set(key, []byte(key)))
+	}
+
+	for _, from := range []kv.Service{listing, probing} {
+		_, err = MigrateKeys(context.Background(), from, to, true)
+		assert.EqualError(t, err, "key 'vault-unseal-1' is missing from the source key store, but 'vault-unseal-2' exists")
+
+		value, err := listing.Get("vault-unseal-0")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("vault-unseal-0"), value)
+
+		_, err = to.Get("vault-unseal-0")
+		assert.True(t, kv.IsNotFoundError(err))
+	}
+
+	// Keys stored from index 0 without gaps are migrated from both
+	assert.NoError(t, listing.Set("vault-unseal-1", []byte("vault-unseal-1")))
+
+	for _, from := range []kv.Service{probing, listing} {
+		keys, err := MigrateKeys(context.Background(), from, to, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"vault-unseal-0", "vault-unseal-1", "vault-unseal-2", "vault-root"}, keys)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// namespaceConfig is a Vault Enterprise namespace, with the configuration applied within it
+type namespaceConfig struct {
+	name   string
+	config *viper.Viper
+}
+
+// getNamespacesConfig reads the namespaces section, the namespaces inherit the purgeUnmanagedConfig
+// of the root namespace unless they have their own.
+func getNamespacesConfig(config *viper.Viper) ([]namespaceConfig, error) {
+	namespaces := []map[string]interface{}{}
+	if err := config.UnmarshalKey("namespaces", &namespaces); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling vault namespaces config")
+	}
+
+	namespaceConfigs := make([]namespaceConfig, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		name := strings.Trim(cast.ToString(namespace["name"]), "/")
+		if name == "" {
+			return nil, errors.New("namespace without name")
+		}
+
+		values := make(map[string]interface{}, len(namespace))
+		for key, value := range namespace {
+			if key != "name" {
+				values[key] = value
+			}
+		}
+
+		nsConfig := viper.New()
+		if err := nsConfig.MergeConfigMap(values); err != nil {
+			return nil, errors.Wrapf(err, "error loading the config of namespace %s", name)
+		}
+		if !nsConfig.IsSet("purgeUnmanagedConfig") && config.IsSet("purgeUnmanagedConfig") {
+			nsConfig.Set("purgeUnmanagedConfig", config.Get("purgeUnmanagedConfig"))
+		}
+
+		namespaceConfigs = append(namespaceConfigs, namespaceConfig{name: name, config: nsConfig})
+	}
+
+	return namespaceConfigs, nil
+}
+
+// namespaced returns a copy of the vault sending its requests to a namespace, relative to the namespace
+// of the client (the root one, unless VAULT_NAMESPACE is set)
+func (v *vault) namespaced(namespace string) (*vault, error) {
+	cl, err := v.cl.Clone()
+	if err != nil {
+		return nil, errors.Wrap(err, "error cloning vault client")
+	}
+
+	headers := v.cl.Headers()
+	cl.SetHeaders(headers)
+	cl.SetToken(v.cl.Token())
+	if namespace != "" {
+		cl.SetNamespace(path.Join(headers.Get(consts.NamespaceHeaderName), namespace))
+	}
+
+	return &vault{
+		keyStore:           v.keyStore,
+		cl:                 cl,
+		config:             v.config,
+		rotateCache:        v.rotateCache,
+		shareRecipients:    v.shareRecipients,
+		rootTokenRecipient: v.rootTokenRecipient,
+	}, nil
+}
+
+// namespaceExists checks if the namespace exists in its parent namespace
+func (v *vault) namespaceExists(namespace string) (bool, error) {
+	parent, name := path.Split(namespace)
+
+	pv, err := v.namespaced(strings.TrimSuffix(parent, "/"))
+	if err != nil {
+		return false, err
+	}
+
+	secret, err := pv.cl.Logical().Read("sys/namespaces/" + name)
+	if err != nil {
+		return false, errors.Wrapf(err, "error reading namespace %s", namespace)
+	}
+
+	return secret != nil, nil
+}
+
+// createNamespace creates the namespace in its parent namespace if it doesn't exist yet,
+// the parent has to exist already
+func (v *vault) createNamespace(namespace string) error {
+	exists, err := v.namespaceExists(namespace)
+	if err != nil || exists {
+		return err
+	}
+
+	parent, name := path.Split(namespace)
+
+	pv, err := v.namespaced(strings.TrimSuffix(parent, "/"))
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("creating namespace %s", namespace)
+	if _, err := pv.writeWithWarningCheck("sys/namespaces/"+name, nil); err != nil {
+		return errors.Wrapf(err, "error creating namespace %s", namespace)
+	}
+
+	return nil
+}
+
+// configureNamespaces creates the namespaces, and applies their auth, policies, secrets and groups
+// sections within them, like the ones of the root namespace.
+func (v *vault) configureNamespaces(ctx context.Context, config *viper.Viper) error {
+	namespaces, err := getNamespacesConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// The sections read their configuration from extConfig, it is switched to the one of
+	// each namespace while it is configured
+	rootConfig := extConfig
+	defer func() { extConfig = rootConfig }()
+
+	for _, namespace := range namespaces {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrapf(err, "configuration interrupted before namespace %s", namespace.name)
+		}
+
+		if err := v.createNamespace(namespace.name); err != nil {
+			return err
+		}
+
+		nv, err := v.namespaced(namespace.name)
+		if err != nil {
+			return err
+		}
+
+		extConfig = externalConfig{}
+		if err := namespace.config.Unmarshal(&extConfig); err != nil {
+			return errors.Wrapf(err, "error loading externalConfig of namespace %s", namespace.name)
+		}
+
+		nsConfig := namespace.config
+		sections := []struct {
+			name      string
+			configure func() error
+		}{
+			{"auth", nv.configureAuthMethods},
+			{"policies", nv.configurePolicies},
+			{"secrets", func() error { return nv.configureSecretsEngines(ctx) }},
+			{"groups", func() error { return nv.configureIdentityGroups(nsConfig) }},
+		}
+
+		for _, section := range sections {
+			if err := section.configure(); err != nil {
+				return errors.Wrapf(err, "error configuring %s of namespace %s", section.name, namespace.name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v *vault) planNamespaces(plan *Plan, config *viper.Viper) error {
+	namespaces, err := getNamespacesConfig(config)
+	if err != nil {
+		return err
+	}
+
+	for _, namespace := range namespaces {
+		exists, err := v.namespaceExists(namespace.name)
+		if err != nil {
+			return err
+		}
+
+		// The content of a missing namespace can't be compared, all of it is going to be added
+		if !exists {
+			plan.add("namespaces", namespace.name)
+
+			continue
+		}
+
+		nv, err := v.namespaced(namespace.name)
+		if err != nil {
+			return err
+		}
+
+		var nsConfig externalConfig
+		if err := namespace.config.Unmarshal(&nsConfig); err != nil {
+			return errors.Wrapf(err, "error loading externalConfig of namespace %s", namespace.name)
+		}
+
+		nsPlan := &Plan{}
+		if err := nv.planAuthMethods(nsPlan, nsConfig); err != nil {
+			return errors.Wrapf(err, "error planning auth methods of namespace %s", namespace.name)
+		}
+		if err := nv.planPolicies(nsPlan, nsConfig); err != nil {
+			return errors.Wrapf(err, "error planning policies of namespace %s", namespace.name)
+		}
+		if err := nv.planSecretsEngines(nsPlan, nsConfig); err != nil {
+			return errors.Wrapf(err, "error planning secrets engines of namespace %s", namespace.name)
+		}
+		if err := nv.planIdentityGroups(nsPlan, namespace.config, nsConfig.PurgeUnmanagedConfig); err != nil {
+			return errors.Wrapf(err, "error planning identity groups of namespace %s", namespace.name)
+		}
+
+		for _, item := range nsPlan.Items {
+			item.Namespace = namespace.name
+			plan.Items = append(plan.Items, item)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigureNamespaces(t *testing.T) {
+	fake := &fakeVault{responses: map[string]string{
+		"GET /v1/sys/namespaces/team-a":               `{"data": {"path": "team-a/"}}`,
+		"team-a:GET /v1/sys/auth":                     `{"data": {"token/": {"type": "token"}}}`,
+		"team-a:GET /v1/sys/mounts":                   `{"data": {"cubbyhole/": {"type": "cubbyhole"}}}`,
+		"team-a:LIST /v1/sys/policies/acl":            `{"data": {"keys": ["default", "reader", "stale"]}}`,
+		"team-a:GET /v1/sys/policies/acl/reader":      `{"data": {"name": "reader", "policy": "path \"secret/*\" {\n  capabilities = [\"read\"]\n}"}}`,
+		"team-a/sub:GET /v1/sys/auth":                 `{"data": {"token/": {"type": "token"}}}`,
+		"team-a/sub:GET /v1/sys/mounts":               `{"data": {"cubbyhole/": {"type": "cubbyhole"}}}`,
+		"team-a/sub:LIST /v1/sys/policies/acl":        `{"data": {"keys": ["default"]}}`,
+		"team-a/sub:LIST /v1/identity/group/name":     `{"data": {"keys": []}}`,
+		"team-a:LIST /v1/identity/group/name":         `{"data": {"keys": []}}`,
+		"team-a:LIST /v1/identity/group-alias/id":     `{"data": {"keys": []}}`,
+		"team-a/sub:LIST /v1/identity/group-alias/id": `{"data": {"keys": []}}`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cl, err := api.NewClient(&api.Config{Address: server.URL})
+	assert.NoError(t, err)
+	cl.SetToken("root")
+
+	v := &vault{cl: cl}
+
+	config := viper.New()
+	config.SetConfigType("yaml")
+	assert.NoError(t, config.ReadConfig(strings.NewReader(`
+purgeUnmanagedConfig:
+  enabled: true
+namespaces:
+  - name: team-a
+    policies:
+      - name: reader
+        rules: path "secret/*" { capabilities = ["read"] }
+  - name: team-a/sub
+    purgeUnmanagedConfig:
+      enabled: false
+    policies:
+      - name: reader
+        rules: path "secret/*" { capabilities = ["read"] }
+`)))
+
+	extConfig = externalConfig{}
+	defer func() { extConfig = externalConfig{} }()
+
+	assert.NoError(t, v.configureNamespaces(context.Background(), config))
+
+	// team-a exists already, the sub namespace is created in it
+	assert.Contains(t, fake.written, "team-a:/v1/sys/namespaces/sub")
+	assert.NotContains(t, fake.written, "/v1/sys/namespaces/team-a")
+	assert.Contains(t, fake.written, "team-a:/v1/sys/policies/acl/reader")
+	assert.Contains(t, fake.written, "team-a/sub:/v1/sys/policies/acl/reader")
+
+	// the purge is inherited by team-a, and disabled in the sub namespace
+	assert.Equal(t, []string{"team-a:/v1/sys/policies/acl/stale"}, fake.deleted)
+
+	plan := &Plan{}
+	assert.NoError(t, v.planNamespaces(plan, config))
+	assert.Equal(t, []PlanItem{
+		{Namespace: "team-a", Section: "policies", Name: "stale", Action: PlanActionRemove},
+		{Section: "namespaces", Name: "team-a/sub", Action: PlanActionAdd},
+	}, plan.Items)
+}
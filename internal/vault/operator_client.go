@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"emperror.dev/errors"
+	"filippo.io/age"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/sdk/helper/consts"
 	json "github.com/json-iterator/go"
@@ -35,6 +36,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	crclient "sigs.k8s.io/controller-runtime/pkg/client"
 	crconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
 )
 
 // DefaultConfigFile is the name of the default config file
@@ -52,6 +55,17 @@ type Config struct {
 	// should the root token be stored in the keyStore
 	StoreRootToken bool
 
+	// PGP public keys (base64 encoded or "keybase:<username>"), one for each key share,
+	// Vault encrypts every unseal (or recovery) key with the respective key at init
+	PGPKeys []string
+	// PGP public key (base64 encoded or "keybase:<username>") to encrypt the root token with at init
+	RootTokenPGPKey string
+	// age recipients, one for each key share, every unseal (or recovery) key is
+	// encrypted to the respective recipient before it gets stored
+	AgeRecipients []string
+	// age recipient to encrypt the root token to before it gets stored
+	RootTokenAgeRecipient string
+
 	// should the KV backend be tested first to validate access rights
 	PreFlightChecks bool
 }
@@ -62,7 +76,10 @@ type vault struct {
 	keyStore    KVService
 	cl          *api.Client
 	config      *Config
-	rotateCache map[string]bool
+	rotateCache map[string]time.Time
+
+	shareRecipients    []age.Recipient
+	rootTokenRecipient age.Recipient
 }
 
 // Interface check
@@ -71,23 +88,39 @@ var _ Vault = &vault{}
 // Vault is an interface that can be used to attempt to perform actions against
 // a Vault server.
 type Vault interface {
-	Init() error
-	RaftInitialized() (bool, error)
+	Init(ctx context.Context) error
+	RaftInitialized(ctx context.Context) (bool, error)
 	RaftJoin(string) error
 	Sealed() (bool, error)
 	Active() (bool, error)
-	Unseal() error
+	Unseal(ctx context.Context) error
+	Rekey(ctx context.Context) error
+	GenerateRoot(ctx context.Context) error
 	Leader() (bool, error)
 	LeaderAddress() (string, error)
-	Configure(config *viper.Viper) error
+	Configure(ctx context.Context, config *viper.Viper) error
+	RotateCredentials(ctx context.Context, config *viper.Viper) error
+	Plan(ctx context.Context, config *viper.Viper) (*Plan, error)
+	Export(ctx context.Context) (*ExportedConfig, error)
 }
 
 type purgeUnmanagedConfig struct {
 	Enabled bool `json:"enabled,omitempty"`
 	Exclude struct {
-		Auths    bool `json:"auth,omitempty"`
-		Policies bool `json:"policies,omitempty"`
-		Secrets  bool `json:"secrets,omitempty"`
+		Auths         bool `json:"auth,omitempty" mapstructure:"auth"`
+		Policies      bool `json:"policies,omitempty"`
+		Secrets       bool `json:"secrets,omitempty"`
+		Audit         bool `json:"audit,omitempty"`
+		Plugins       bool `json:"plugins,omitempty"`
+		Groups        bool `json:"groups,omitempty"`
+		GroupAliases  bool `json:"group-aliases,omitempty" mapstructure:"group-aliases"`
+		Entities      bool `json:"entities,omitempty"`
+		EntityAliases bool `json:"entity-aliases,omitempty" mapstructure:"entity-aliases"`
+
+		PasswordPolicies bool `json:"passwordPolicies,omitempty"`
+		RGPs             bool `json:"rgp,omitempty" mapstructure:"rgp"`
+		EGPs             bool `json:"egp,omitempty" mapstructure:"egp"`
+		Quotas           bool `json:"quotas,omitempty"`
 	} `json:"exclude,omitempty"`
 }
 
@@ -97,6 +130,9 @@ type externalConfig struct {
 	Auth                 []auth               `json:"auth,omitempty"`
 	Policies             []policy             `json:"policies,omitempty"`
 	Secrets              []secretEngine       `json:"secrets,omitempty"`
+	PasswordPolicies     []passwordPolicy     `json:"passwordPolicies,omitempty"`
+	RGPs                 []sentinelPolicy     `json:"rgp,omitempty" mapstructure:"rgp"`
+	EGPs                 []sentinelPolicy     `json:"egp,omitempty" mapstructure:"egp"`
 }
 
 var extConfig externalConfig
@@ -110,15 +146,15 @@ type kvTester struct {
 	Service KVService
 }
 
-func (t kvTester) Test(key string) error {
-	_, err := t.Service.Get(key)
+func (t kvTester) Test(ctx context.Context, key string) error {
+	_, err := kv.GetWithContext(ctx, t.Service, key)
 	if err != nil {
 		if !isNotFoundError(err) {
 			return err // nolint:wrapcheck
 		}
 	}
 
-	return t.Service.Set(key, []byte(key))
+	return kv.SetWithContext(ctx, t.Service, key, []byte(key))
 }
 
 // New returns a new vault Vault, or an error.
@@ -127,11 +163,18 @@ func New(k KVService, cl *api.Client, config Config) (Vault, error) {
 		return nil, errors.Errorf("the secret threshold can't be bigger than the shares [%d < %d]", config.SecretShares, config.SecretThreshold)
 	}
 
+	shareRecipients, rootTokenRecipient, err := keyEncryptionForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &vault{
-		keyStore:    k,
-		cl:          cl,
-		config:      &config,
-		rotateCache: map[string]bool{},
+		keyStore:           k,
+		cl:                 cl,
+		config:             &config,
+		rotateCache:        map[string]time.Time{},
+		shareRecipients:    shareRecipients,
+		rootTokenRecipient: rootTokenRecipient,
 	}, nil
 }
 
@@ -182,13 +225,13 @@ func (v *vault) LeaderAddress() (string, error) {
 // and sending unseal requests to vault. It will return an error if retrieving
 // a key fails, or if the unseal progress is reset to 0 (indicating that a key)
 // was invalid.
-func (v *vault) Unseal() error {
+func (v *vault) Unseal(ctx context.Context) error {
 	defer runtime.GC()
 	for i := 0; ; i++ {
 		keyID := v.unsealKeyForID(i)
 
 		logrus.Debugf("retrieving key from kms service...")
-		k, err := v.keyStore.Get(keyID)
+		k, err := kv.GetWithContext(ctx, v.keyStore, keyID)
 		if err != nil {
 			return errors.Wrapf(err, "unable to get key '%s'", keyID)
 		}
@@ -225,8 +268,8 @@ func isNotFoundError(err error) bool {
 	return false
 }
 
-func (v *vault) keyStoreNotFound(key string) (bool, error) {
-	_, err := v.keyStore.Get(key)
+func (v *vault) keyStoreNotFound(ctx context.Context, key string) (bool, error) {
+	_, err := kv.GetWithContext(ctx, v.keyStore, key)
 	if isNotFoundError(err) {
 		return true, nil
 	}
@@ -234,10 +277,10 @@ func (v *vault) keyStoreNotFound(key string) (bool, error) {
 	return false, err // nolint:wrapcheck
 }
 
-func (v *vault) keyStoreSet(key string, val []byte) error {
-	notFound, err := v.keyStoreNotFound(key)
+func (v *vault) keyStoreSet(ctx context.Context, key string, val []byte) error {
+	notFound, err := v.keyStoreNotFound(ctx, key)
 	if notFound {
-		return v.keyStore.Set(key, val)
+		return kv.SetWithContext(ctx, v.keyStore, key, val)
 	} else if err == nil {
 		return errors.Errorf("error setting key '%s': it already exists", key)
 	} else {
@@ -246,7 +289,7 @@ func (v *vault) keyStoreSet(key string, val []byte) error {
 }
 
 // Init initializes Vault if is not initialized already
-func (v *vault) Init() error {
+func (v *vault) Init(ctx context.Context) error {
 	initialized, err := v.cl.Sys().InitStatus()
 	if err != nil {
 		return errors.Wrap(err, "error testing if vault is initialized")
@@ -262,7 +305,7 @@ func (v *vault) Init() error {
 	// test backend first
 	if v.config.PreFlightChecks {
 		tester := kvTester{Service: v.keyStore}
-		err = tester.Test(v.testKey())
+		err = tester.Test(ctx, v.testKey())
 		if err != nil {
 			return errors.Wrap(err, "error testing keystore before init")
 		}
@@ -280,7 +323,7 @@ func (v *vault) Init() error {
 
 	// test every key
 	for _, key := range keys {
-		notFound, err := v.keyStoreNotFound(key)
+		notFound, err := v.keyStoreNotFound(ctx, key)
 		if notFound && err != nil {
 			return errors.Wrapf(err, "error before init: checking key '%s' failed", key)
 		} else if !notFound && err == nil {
@@ -288,19 +331,45 @@ func (v *vault) Init() error {
 		}
 	}
 
-	resp, err := v.cl.Sys().Init(&api.InitRequest{
+	initRequest := &api.InitRequest{
 		SecretShares:      v.config.SecretShares,
 		SecretThreshold:   v.config.SecretThreshold,
 		RecoveryShares:    v.config.SecretShares,
 		RecoveryThreshold: v.config.SecretThreshold,
-	})
+		RootTokenPGPKey:   v.config.RootTokenPGPKey,
+	}
+
+	if len(v.config.PGPKeys) > 0 {
+		sealStatus, err := v.cl.Sys().SealStatus()
+		if err != nil {
+			return errors.Wrap(err, "error checking seal type")
+		}
+
+		// Only one kind of key is returned, depending on the seal type
+		if sealStatus.RecoverySeal {
+			initRequest.RecoveryPGPKeys = v.config.PGPKeys
+		} else {
+			initRequest.PGPKeys = v.config.PGPKeys
+		}
+	}
+
+	if v.sharesEncrypted() {
+		logrus.Warn("key shares are encrypted to custodians, the stored keys can't be used to unseal vault automatically")
+	}
+
+	resp, err := v.cl.Sys().Init(initRequest)
 	if err != nil {
 		return errors.Wrap(err, "error initializing vault")
 	}
 
 	for i, k := range resp.Keys {
 		keyID := v.unsealKeyForID(i)
-		err := v.keyStoreSet(keyID, []byte(k))
+		value, err := v.encryptShare(i, k)
+		if err != nil {
+			return errors.Wrapf(err, "error encrypting unseal key '%s'", keyID)
+		}
+
+		err = v.keyStoreSet(ctx, keyID, value)
 		if err != nil {
 			return errors.Wrapf(err, "error storing unseal key '%s'", keyID)
 		}
@@ -310,7 +379,12 @@ func (v *vault) Init() error {
 
 	for i, k := range resp.RecoveryKeys {
 		keyID := v.recoveryKeyForID(i)
-		err := v.keyStoreSet(keyID, []byte(k))
+		value, err := v.encryptShare(i, k)
+		if err != nil {
+			return errors.Wrapf(err, "error encrypting recovery key '%s'", keyID)
+		}
+
+		err = v.keyStoreSet(ctx, keyID, value)
 		if err != nil {
 			return errors.Wrapf(err, "error storing recovery key '%s'", keyID)
 		}
@@ -336,7 +410,11 @@ func (v *vault) Init() error {
 				logrus.Infof("vault not reachable: %s", err.Error())
 			}
 
-			time.Sleep(wait)
+			select {
+			case <-ctx.Done():
+				return errors.Wrapf(ctx.Err(), "stopped waiting for vault to be unsealed, (temporary root token: '%s')", resp.RootToken)
+			case <-time.After(wait):
+			}
 		}
 
 		// use temporary token
@@ -362,22 +440,27 @@ func (v *vault) Init() error {
 		rootToken = v.config.InitRootToken
 	}
 
+	rootTokenValue, err := v.encryptRootToken(resp.RootToken)
+	if err != nil {
+		return errors.Wrap(err, "error encrypting root token")
+	}
+
 	if v.config.StoreRootToken {
 		rootTokenKey := v.rootTokenKey()
-		if err = v.keyStoreSet(rootTokenKey, []byte(resp.RootToken)); err != nil {
+		if err = v.keyStoreSet(ctx, rootTokenKey, rootTokenValue); err != nil {
 			return errors.Wrapf(err, "error storing root token '%s' in key'%s'", rootToken, rootTokenKey)
 		}
 		logrus.WithField("key", rootTokenKey).Info("root token stored in key store")
 	} else if v.config.InitRootToken == "" {
-		logrus.WithField("root-token", resp.RootToken).Warnf("won't store root token in key store, this token grants full privileges to vault, so keep this secret")
+		logrus.WithField("root-token", string(rootTokenValue)).Warnf("won't store root token in key store, this token grants full privileges to vault, so keep this secret")
 	}
 
 	return nil
 }
 
 // in our case Vault is initialized when root key is stored in the Cloud KMS
-func (v *vault) RaftInitialized() (bool, error) {
-	rootToken, err := v.keyStore.Get(v.rootTokenKey())
+func (v *vault) RaftInitialized(ctx context.Context) (bool, error) {
+	rootToken, err := kv.GetWithContext(ctx, v.keyStore, v.rootTokenKey())
 	if err != nil {
 		if isNotFoundError(err) {
 			return false, nil
@@ -445,59 +528,78 @@ func (v *vault) RaftJoin(leaderAPIAddr string) error {
 	return errors.New("vault hasn't joined raft cluster")
 }
 
-func (v *vault) Configure(config *viper.Viper) error {
+// useRootToken sets the root token from the key store on the Vault client,
+// the returned function clears it when the caller is done.
+func (v *vault) useRootToken(ctx context.Context) (func(), error) {
 	logrus.Debugf("retrieving key from kms service...")
 
-	rootToken, err := v.keyStore.Get(v.rootTokenKey())
+	rootToken, err := kv.GetWithContext(ctx, v.keyStore, v.rootTokenKey())
 	if err != nil {
-		return errors.Wrapf(err, "unable to get key '%s'", v.rootTokenKey())
+		return nil, errors.Wrapf(err, "unable to get key '%s'", v.rootTokenKey())
+	}
+
+	if encryptedToCustodian(rootToken) {
+		return nil, errors.Errorf("the root token in key '%s' is encrypted to a custodian, it can't be used to access vault", v.rootTokenKey())
 	}
 
 	v.cl.SetToken(string(rootToken))
 
 	// Clear the token and GC it
-	defer runtime.GC()
-	defer v.cl.SetToken("")
-	defer func() { rootToken = nil }()
+	return func() {
+		rootToken = nil
+		v.cl.SetToken("")
+		runtime.GC()
+	}, nil
+}
 
-	err = config.Unmarshal(&extConfig)
+// Configure applies the configuration section by section, if the context is done
+// the section in progress is finished, but no further sections are applied.
+func (v *vault) Configure(ctx context.Context, config *viper.Viper) error {
+	clearToken, err := v.useRootToken(ctx)
 	if err != nil {
-		return errors.Wrap(err, "error loading externalConfig")
+		return err
 	}
+	defer clearToken()
 
-	if err = v.configureAuthMethods(); err != nil {
-		return errors.Wrap(err, "error configuring auth methods for vault")
-	}
-
-	if err = v.configurePolicies(); err != nil {
-		return errors.Wrap(err, "error configuring policies for vault")
-	}
-
-	if err = v.configureSecretsEngines(); err != nil {
-		return errors.Wrap(err, "error configuring secret engines for vault")
-	}
-
-	err = v.configurePlugins(config)
+	err = config.Unmarshal(&extConfig)
 	if err != nil {
-		return errors.Wrap(err, "error configuring plugins for vault")
+		return errors.Wrap(err, "error loading externalConfig")
 	}
 
-	err = v.configureAuditDevices(config)
-	if err != nil {
-		return errors.Wrap(err, "error configuring audit devices for vault")
-	}
+	sections := []struct {
+		name      string
+		configure func() error
+		message   string
+	}{
+		{"auth", v.configureAuthMethods, "error configuring auth methods for vault"},
+		{"policies", v.configurePolicies, "error configuring policies for vault"},
+		{"passwordPolicies", v.configurePasswordPolicies, "error configuring password policies for vault"},
+		{"sentinelPolicies", v.configureSentinelPolicies, "error configuring sentinel policies for vault"},
+		{"secrets", func() error { return v.configureSecretsEngines(ctx) }, "error configuring secret engines for vault"},
+		{"plugins", func() error { return v.configurePlugins(config) }, "error configuring plugins for vault"},
+		{"audit", func() error { return v.configureAuditDevices(config) }, "error configuring audit devices for vault"},
+		{"quotas", func() error { return v.configureQuotas(config) }, "error configuring quotas for vault"},
+		{"startupSecrets", func() error { return v.configureStartupSecrets(config) }, "error writing startup secrets to vault"},
+		{"groups", func() error { return v.configureIdentityGroups(config) }, "error writing groups configurations for vault"},
+		{"entities", func() error { return v.configureIdentityEntities(config) }, "error writing entities configurations for vault"},
+		{"namespaces", func() error { return v.configureNamespaces(ctx, config) }, "error configuring namespaces for vault"},
+	}
+
+	for _, section := range sections {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrapf(err, "configuration interrupted before section %s", section.name)
+		}
 
-	err = v.configureStartupSecrets(config)
-	if err != nil {
-		return errors.Wrap(err, "error writing startup secrets to vault")
-	}
+		start := time.Now()
+		err := section.configure()
+		configureSectionDuration.WithLabelValues(section.name).Observe(time.Since(start).Seconds())
 
-	err = v.configureIdentityGroups(config)
-	if err != nil {
-		return errors.Wrap(err, "error writing groups configurations for vault")
+		if err != nil {
+			return errors.Wrap(err, section.message)
+		}
 	}
 
-	return err
+	return nil
 }
 
 func (*vault) unsealKeyForID(i int) string {
@@ -508,6 +610,18 @@ func (*vault) recoveryKeyForID(i int) string {
 	return fmt.Sprint("vault-recovery-", i)
 }
 
+// rotationKeyPrefix is the prefix of the keys holding the credential rotation state
+const rotationKeyPrefix = "vault-rotation-"
+
+// rotationKey is the key of the last credential rotation at rotatePath, in the namespace of the client
+func (v *vault) rotationKey(rotatePath string) string {
+	if namespace := v.cl.Headers().Get(consts.NamespaceHeaderName); namespace != "" {
+		rotatePath = namespace + "/" + strings.Trim(rotatePath, "/")
+	}
+
+	return rotationKeyPrefix + strings.ReplaceAll(strings.Trim(rotatePath, "/"), "/", ".")
+}
+
 func (*vault) rootTokenKey() string {
 	return "vault-root"
 }
@@ -524,7 +638,7 @@ func (v *vault) configurePlugins(config *viper.Viper) error {
 	}
 
 	if len(plugins) == 0 {
-		return nil
+		return v.removeUnmanagedPlugins(plugins)
 	}
 
 	listPlugins, err := v.cl.Sys().ListPlugins(&api.ListPluginsInput{})
@@ -572,6 +686,123 @@ func (v *vault) configurePlugins(config *viper.Viper) error {
 		logrus.Infoln("registered plugin", pluginName)
 	}
 
+	return v.removeUnmanagedPlugins(plugins)
+}
+
+// getUnmanagedPlugins gets the plugins registered in the catalog but missing from the externalConfig,
+// the builtin plugins are never unmanaged.
+func (v *vault) getUnmanagedPlugins(managedPlugins []map[string]interface{}) ([]api.DeregisterPluginInput, error) {
+	managed := map[string]bool{}
+	for _, plugin := range managedPlugins {
+		pluginType, err := consts.ParsePluginType(cast.ToString(plugin["type"]))
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing type for plugin")
+		}
+		managed[pluginType.String()+"/"+cast.ToString(plugin["plugin_name"])] = true
+	}
+
+	listPlugins, err := v.cl.Sys().ListPlugins(&api.ListPluginsInput{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve list of plugins")
+	}
+
+	var unmanagedPlugins []api.DeregisterPluginInput
+	for pluginType, pluginNames := range listPlugins.PluginsByType {
+		for _, pluginName := range pluginNames {
+			if managed[pluginType.String()+"/"+pluginName] {
+				continue
+			}
+
+			plugin, err := v.cl.Sys().GetPlugin(&api.GetPluginInput{Name: pluginName, Type: pluginType})
+			if err != nil {
+				return nil, errors.Wrapf(err, "error reading plugin %s from vault", pluginName)
+			}
+			if plugin.Builtin {
+				continue
+			}
+
+			unmanagedPlugins = append(unmanagedPlugins, api.DeregisterPluginInput{Name: pluginName, Type: pluginType})
+		}
+	}
+
+	return unmanagedPlugins, nil
+}
+
+// Deregisters any plugin that's not managed if purgeUnmanagedConfig option is enabled
+func (v *vault) removeUnmanagedPlugins(managedPlugins []map[string]interface{}) error {
+	if !extConfig.PurgeUnmanagedConfig.Enabled || extConfig.PurgeUnmanagedConfig.Exclude.Plugins {
+		return nil
+	}
+
+	unmanagedPlugins, err := v.getUnmanagedPlugins(managedPlugins)
+	if err != nil {
+		return err
+	}
+
+	for i := range unmanagedPlugins {
+		plugin := unmanagedPlugins[i]
+		logrus.Infof("removing unmanaged %s plugin %s", plugin.Type, plugin.Name)
+		if err := v.cl.Sys().DeregisterPlugin(&plugin); err != nil {
+			return errors.Wrapf(err, "error deregistering %s plugin in vault", plugin.Name)
+		}
+	}
+
+	return nil
+}
+
+func (v *vault) planPlugins(plan *Plan, config *viper.Viper, purge purgeUnmanagedConfig) error {
+	plugins := []map[string]interface{}{}
+	err := config.UnmarshalKey("plugins", &plugins)
+	if err != nil {
+		return errors.Wrap(err, "error unmarshalling vault plugins config")
+	}
+
+	if purge.Enabled && !purge.Exclude.Plugins {
+		unmanagedPlugins, err := v.getUnmanagedPlugins(plugins)
+		if err != nil {
+			return err
+		}
+
+		for _, plugin := range unmanagedPlugins {
+			plan.remove("plugins", plugin.Name)
+		}
+	}
+
+	for _, plugin := range plugins {
+		pluginName, err := getOrError(plugin, "plugin_name")
+		if err != nil {
+			return errors.Wrap(err, "error getting plugin_name for plugin")
+		}
+		typeRaw, err := getOrError(plugin, "type")
+		if err != nil {
+			return errors.Wrap(err, "error getting type for plugin")
+		}
+		pluginType, err := consts.ParsePluginType(typeRaw)
+		if err != nil {
+			return errors.Wrap(err, "error parsing type for plugin")
+		}
+
+		existingPlugin, err := v.cl.Sys().GetPlugin(&api.GetPluginInput{Name: pluginName, Type: pluginType})
+		if err != nil || existingPlugin == nil {
+			logrus.Debugf("plugin %s not found in catalog: %v", pluginName, err)
+			plan.add("plugins", pluginName)
+
+			continue
+		}
+
+		var changes []string
+		if sha256 := cast.ToString(plugin["sha256"]); sha256 != existingPlugin.SHA256 {
+			changes = append(changes, "sha256")
+		}
+		if command := cast.ToString(plugin["command"]); command != existingPlugin.Command {
+			changes = append(changes, "command")
+		}
+
+		if len(changes) > 0 {
+			plan.change("plugins", pluginName, changes...)
+		}
+	}
+
 	return nil
 }
 
@@ -588,13 +819,9 @@ func (v *vault) configureAuditDevices(config *viper.Viper) error {
 			return errors.Wrap(err, "error finding type for audit device")
 		}
 
-		path := auditDeviceType
-		if pathOverwrite, ok := auditDevice["path"]; ok {
-			path, err = cast.ToStringE(pathOverwrite)
-			if err != nil {
-				return errors.Wrap(err, "error converting path for audit device")
-			}
-			path = strings.Trim(path, "/")
+		path, err := auditDevicePath(auditDevice)
+		if err != nil {
+			return err
 		}
 
 		mounts, err := v.cl.Sys().ListAudit()
@@ -622,6 +849,277 @@ func (v *vault) configureAuditDevices(config *viper.Viper) error {
 		}
 	}
 
+	return v.removeUnmanagedAuditDevices(auditDevices)
+}
+
+// getUnmanagedAuditDevices gets the paths of the audit devices enabled in Vault but missing from the externalConfig
+func (v *vault) getUnmanagedAuditDevices(managedAuditDevices []map[string]interface{}) (map[string]bool, error) {
+	mounts, err := v.cl.Sys().ListAudit()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading audit mounts from vault")
+	}
+
+	unmanagedAuditDevices := map[string]bool{}
+	for path := range mounts {
+		unmanagedAuditDevices[strings.Trim(path, "/")] = true
+	}
+
+	for _, auditDevice := range managedAuditDevices {
+		path, err := auditDevicePath(auditDevice)
+		if err != nil {
+			return nil, err
+		}
+		delete(unmanagedAuditDevices, path)
+	}
+
+	return unmanagedAuditDevices, nil
+}
+
+// Disables any audit device that's not managed if purgeUnmanagedConfig option is enabled
+func (v *vault) removeUnmanagedAuditDevices(managedAuditDevices []map[string]interface{}) error {
+	if !extConfig.PurgeUnmanagedConfig.Enabled || extConfig.PurgeUnmanagedConfig.Exclude.Audit {
+		return nil
+	}
+
+	unmanagedAuditDevices, err := v.getUnmanagedAuditDevices(managedAuditDevices)
+	if err != nil {
+		return err
+	}
+
+	for path := range unmanagedAuditDevices {
+		logrus.Infof("removing unmanaged audit device %s", path)
+		if err := v.cl.Sys().DisableAudit(path); err != nil {
+			return errors.Wrapf(err, "error disabling audit device %s in vault", path)
+		}
+	}
+
+	return nil
+}
+
+func (v *vault) planAuditDevices(plan *Plan, config *viper.Viper, purge purgeUnmanagedConfig) error {
+	auditDevices := []map[string]interface{}{}
+	err := config.UnmarshalKey("audit", &auditDevices)
+	if err != nil {
+		return errors.Wrap(err, "error unmarshalling audit devices config")
+	}
+
+	if purge.Enabled && !purge.Exclude.Audit {
+		unmanagedAuditDevices, err := v.getUnmanagedAuditDevices(auditDevices)
+		if err != nil {
+			return err
+		}
+
+		for path := range unmanagedAuditDevices {
+			plan.remove("audit", path)
+		}
+	}
+
+	if len(auditDevices) == 0 {
+		return nil
+	}
+
+	mounts, err := v.cl.Sys().ListAudit()
+	if err != nil {
+		return errors.Wrap(err, "error reading audit mounts from vault")
+	}
+
+	for _, auditDevice := range auditDevices {
+		path, err := auditDevicePath(auditDevice)
+		if err != nil {
+			return err
+		}
+
+		// Already mounted audit devices are never modified
+		if mounts[path+"/"] == nil {
+			plan.add("audit", path)
+		}
+	}
+
+	return nil
+}
+
+func auditDevicePath(auditDevice map[string]interface{}) (string, error) {
+	path, err := cast.ToStringE(auditDevice["type"])
+	if err != nil {
+		return "", errors.Wrap(err, "error finding type for audit device")
+	}
+
+	if pathOverwrite, ok := auditDevice["path"]; ok {
+		path, err = cast.ToStringE(pathOverwrite)
+		if err != nil {
+			return "", errors.Wrap(err, "error converting path for audit device")
+		}
+		path = strings.Trim(path, "/")
+	}
+
+	return path, nil
+}
+
+// quotaTypes are the kinds of quotas which can be declared in the quotas section
+var quotaTypes = []string{"rate-limit", "lease-count"}
+
+// quotaName returns the name of a quota as "<type>/<name>", which is also its path under sys/quotas
+func quotaName(quota map[string]interface{}) (string, error) {
+	quotaType := cast.ToString(quota["type"])
+	supported := false
+	for _, t := range quotaTypes {
+		supported = supported || t == quotaType
+	}
+	if !supported {
+		return "", errors.Errorf("unsupported quota type '%s', use one of %s", quotaType, strings.Join(quotaTypes, ", "))
+	}
+
+	name := cast.ToString(quota["name"])
+	if name == "" {
+		return "", errors.Errorf("error finding name for %s quota", quotaType)
+	}
+
+	return quotaType + "/" + name, nil
+}
+
+// quotaData returns the settings of a quota, which are sent to Vault. The path is normalized
+// to the form Vault returns it in (with a trailing slash), so that it compares equal when reading it back.
+func quotaData(quota map[string]interface{}) map[string]interface{} {
+	data := make(map[string]interface{}, len(quota))
+	for key, value := range quota {
+		if key != "type" && key != "name" {
+			data[key] = value
+		}
+	}
+
+	if path, ok := data["path"].(string); ok {
+		path = strings.Trim(strings.TrimSpace(path), "/")
+		if path != "" {
+			path += "/"
+		}
+		data["path"] = path
+	}
+
+	return data
+}
+
+// configureQuotas writes the rate limit and lease count quotas which are missing or differ
+// from the externalConfig, a quota is scoped to a namespace, mount or role by its path and role.
+func (v *vault) configureQuotas(config *viper.Viper) error {
+	quotas := []map[string]interface{}{}
+	err := config.UnmarshalKey("quotas", &quotas)
+	if err != nil {
+		return errors.Wrap(err, "error unmarshalling quotas config")
+	}
+
+	for _, quota := range quotas {
+		name, err := quotaName(quota)
+		if err != nil {
+			return err
+		}
+
+		data := quotaData(quota)
+		exists, changes, err := v.planPath("sys/quotas/"+name, data)
+		if err != nil {
+			return err
+		}
+
+		if exists && len(changes) == 0 {
+			logrus.Debugf("quota %s is up to date", name)
+
+			continue
+		}
+
+		logrus.Infof("writing quota %s", name)
+		if _, err := v.writeWithWarningCheck("sys/quotas/"+name, data); err != nil {
+			return errors.Wrapf(err, "error writing quota %s into vault", name)
+		}
+	}
+
+	return v.removeUnmanagedQuotas(quotas)
+}
+
+// getUnmanagedQuotas gets the names of the quotas in Vault but missing from the externalConfig,
+// the kinds of quotas the Vault server doesn't support (lease-count is Enterprise only) are skipped
+func (v *vault) getUnmanagedQuotas(managedQuotas []map[string]interface{}) (map[string]bool, error) {
+	unmanagedQuotas := map[string]bool{}
+	for _, quotaType := range quotaTypes {
+		secret, err := v.cl.Logical().List("sys/quotas/" + quotaType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing %s quotas from vault", quotaType)
+		}
+		if secret == nil || secret.Data == nil {
+			continue
+		}
+
+		for _, name := range cast.ToStringSlice(secret.Data["keys"]) {
+			unmanagedQuotas[quotaType+"/"+name] = true
+		}
+	}
+
+	for _, quota := range managedQuotas {
+		name, err := quotaName(quota)
+		if err != nil {
+			return nil, err
+		}
+		delete(unmanagedQuotas, name)
+	}
+
+	return unmanagedQuotas, nil
+}
+
+// Deletes any quota that's not managed if purgeUnmanagedConfig option is enabled
+func (v *vault) removeUnmanagedQuotas(managedQuotas []map[string]interface{}) error {
+	if !extConfig.PurgeUnmanagedConfig.Enabled || extConfig.PurgeUnmanagedConfig.Exclude.Quotas {
+		return nil
+	}
+
+	unmanagedQuotas, err := v.getUnmanagedQuotas(managedQuotas)
+	if err != nil {
+		return err
+	}
+
+	for name := range unmanagedQuotas {
+		logrus.Infof("removing unmanaged quota %s", name)
+		if _, err := v.cl.Logical().Delete("sys/quotas/" + name); err != nil {
+			return errors.Wrapf(err, "error deleting quota %s from vault", name)
+		}
+	}
+
+	return nil
+}
+
+func (v *vault) planQuotas(plan *Plan, config *viper.Viper, purge purgeUnmanagedConfig) error {
+	quotas := []map[string]interface{}{}
+	err := config.UnmarshalKey("quotas", &quotas)
+	if err != nil {
+		return errors.Wrap(err, "error unmarshalling quotas config")
+	}
+
+	for _, quota := range quotas {
+		name, err := quotaName(quota)
+		if err != nil {
+			return err
+		}
+
+		exists, changes, err := v.planPath("sys/quotas/"+name, quotaData(quota))
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			plan.add("quotas", name)
+		} else if len(changes) > 0 {
+			plan.change("quotas", name, changes...)
+		}
+	}
+
+	if purge.Enabled && !purge.Exclude.Quotas {
+		unmanagedQuotas, err := v.getUnmanagedQuotas(quotas)
+		if err != nil {
+			return err
+		}
+
+		for name := range unmanagedQuotas {
+			plan.remove("quotas", name)
+		}
+	}
+
 	return nil
 }
 
@@ -673,6 +1171,62 @@ func (v *vault) configureStartupSecrets(config *viper.Viper) error {
 	return nil
 }
 
+func (v *vault) planStartupSecrets(plan *Plan, config *viper.Viper) error {
+	raw := config.Get("startupSecrets")
+	startupSecrets, err := toSliceStringMapE(raw)
+	if err != nil {
+		return errors.Wrapf(err, "error decoding data for startup secrets")
+	}
+
+	for _, startupSecret := range startupSecrets {
+		startupSecretType, err := cast.ToStringE(startupSecret["type"])
+		if err != nil {
+			return errors.Wrap(err, "error finding type for startup secret")
+		}
+
+		switch startupSecretType {
+		case "kv":
+			path, data, err := readStartupSecret(startupSecret)
+			if err != nil {
+				return errors.Wrap(err, "unable to read 'kv' startup secret")
+			}
+
+			exists, fields, err := v.planPath(path, data)
+			if err != nil {
+				return err
+			}
+
+			if !exists {
+				plan.add("startupSecrets", path)
+			} else if len(fields) > 0 {
+				plan.change("startupSecrets", path, fields...)
+			}
+
+		case "pki":
+			path, err := cast.ToStringE(startupSecret["path"])
+			if err != nil {
+				return errors.Wrap(err, "error findind path for startup secret")
+			}
+
+			// The certificate bundle can't be read back, only check if the CA is present on the mount
+			mount := strings.SplitN(strings.Trim(path, "/"), "/", 2)[0]
+			exists, err := v.secretEngineConfigExists(mount, "root/generate", path)
+			if err != nil {
+				logrus.Debugf("error checking pki CA for %s: %s", path, err.Error())
+			}
+
+			if !exists {
+				plan.add("startupSecrets", path)
+			}
+
+		default:
+			return errors.Errorf("'%s' startup secret type is not supported, only 'kv' or 'pki'", startupSecretType)
+		}
+	}
+
+	return nil
+}
+
 func (v *vault) writeWithWarningCheck(path string, data map[string]interface{}) (*api.Secret, error) {
 	sec, err := v.cl.Logical().Write(path, data)
 	if err != nil {
@@ -762,6 +1316,21 @@ func getVaultAuthMountAccessor(path string, client *api.Client) (accessor string
 	return mounts[path].Accessor, nil
 }
 
+// getVaultAuthMountPaths maps the accessors of the auth mounts to their paths
+func getVaultAuthMountPaths(client *api.Client) (map[string]string, error) {
+	mounts, err := client.Sys().ListAuth()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read auth mounts from vault")
+	}
+
+	mountPaths := make(map[string]string, len(mounts))
+	for path, mount := range mounts {
+		mountPaths[mount.Accessor] = strings.Trim(path, "/")
+	}
+
+	return mountPaths, nil
+}
+
 func getVaultGroupID(group string, client *api.Client) (id string, err error) {
 	g, err := readVaultGroup(group, client)
 	if err != nil {
@@ -844,10 +1413,10 @@ func (v *vault) configureIdentityGroups(config *viper.Viper) error {
 			return errors.Wrap(err, "error reading group")
 		}
 
-		// Currently does not support specifying members directly in the group config
-		// Use group aliases for that
-		if cast.ToString(group["type"]) != "external" {
-			return errors.Errorf("only external groups are supported for now")
+		// Members are not specified in the group config, external groups get their members
+		// by group aliases, internal groups by the groups listed in the entities
+		if groupType := cast.ToString(group["type"]); groupType != "external" && groupType != "internal" {
+			return errors.Errorf("unsupported type %q of group %s, use external or internal", groupType, group["name"])
 		}
 
 		config := map[string]interface{}{
@@ -912,6 +1481,214 @@ func (v *vault) configureIdentityGroups(config *viper.Viper) error {
 		}
 	}
 
+	if err := v.removeUnmanagedGroupAliases(groupAliases); err != nil {
+		return err
+	}
+
+	return v.removeUnmanagedGroups(groups)
+}
+
+// getUnmanagedGroups gets the names of the identity groups in Vault but missing from the externalConfig
+func (v *vault) getUnmanagedGroups(managedGroups []map[string]interface{}) (map[string]bool, error) {
+	existingGroups, err := v.cl.Logical().List("identity/group/name")
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing groups")
+	}
+
+	unmanagedGroups := map[string]bool{}
+	if existingGroups != nil {
+		for _, groupName := range cast.ToStringSlice(existingGroups.Data["keys"]) {
+			unmanagedGroups[groupName] = true
+		}
+	}
+
+	for _, group := range managedGroups {
+		delete(unmanagedGroups, cast.ToString(group["name"]))
+	}
+
+	return unmanagedGroups, nil
+}
+
+// Deletes any identity group that's not managed if purgeUnmanagedConfig option is enabled
+func (v *vault) removeUnmanagedGroups(managedGroups []map[string]interface{}) error {
+	if !extConfig.PurgeUnmanagedConfig.Enabled || extConfig.PurgeUnmanagedConfig.Exclude.Groups {
+		return nil
+	}
+
+	unmanagedGroups, err := v.getUnmanagedGroups(managedGroups)
+	if err != nil {
+		return err
+	}
+
+	for groupName := range unmanagedGroups {
+		logrus.Infof("removing unmanaged group %s", groupName)
+		if _, err := v.cl.Logical().Delete(fmt.Sprintf("identity/group/name/%s", groupName)); err != nil {
+			return errors.Wrapf(err, "error deleting group %s from vault", groupName)
+		}
+	}
+
+	return nil
+}
+
+// getUnmanagedGroupAliases gets the group aliases in Vault but missing from the externalConfig,
+// the result maps their IDs to name@mountpath
+func (v *vault) getUnmanagedGroupAliases(managedGroupAliases []map[string]interface{}) (map[string]string, error) {
+	mountPaths, err := getVaultAuthMountPaths(v.cl)
+	if err != nil {
+		return nil, err
+	}
+
+	managed := map[string]bool{}
+	for _, groupAlias := range managedGroupAliases {
+		managed[fmt.Sprintf("%s@%s", cast.ToString(groupAlias["name"]), strings.Trim(cast.ToString(groupAlias["mountpath"]), "/"))] = true
+	}
+
+	aliases, err := v.cl.Logical().List("identity/group-alias/id")
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing group aliases")
+	}
+
+	unmanagedGroupAliases := map[string]string{}
+	if aliases == nil {
+		return unmanagedGroupAliases, nil
+	}
+
+	for _, id := range cast.ToStringSlice(aliases.Data["keys"]) {
+		alias, err := readVaultGroupAlias(id, v.cl)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading group alias %s", id)
+		}
+		if alias == nil {
+			continue
+		}
+
+		name := fmt.Sprintf("%s@%s", cast.ToString(alias.Data["name"]), mountPaths[cast.ToString(alias.Data["mount_accessor"])])
+		if !managed[name] {
+			unmanagedGroupAliases[id] = name
+		}
+	}
+
+	return unmanagedGroupAliases, nil
+}
+
+// Deletes any group alias that's not managed if purgeUnmanagedConfig option is enabled
+func (v *vault) removeUnmanagedGroupAliases(managedGroupAliases []map[string]interface{}) error {
+	if !extConfig.PurgeUnmanagedConfig.Enabled || extConfig.PurgeUnmanagedConfig.Exclude.GroupAliases {
+		return nil
+	}
+
+	unmanagedGroupAliases, err := v.getUnmanagedGroupAliases(managedGroupAliases)
+	if err != nil {
+		return err
+	}
+
+	for id, name := range unmanagedGroupAliases {
+		logrus.Infof("removing unmanaged group-alias %s - ID: %s", name, id)
+		if _, err := v.cl.Logical().Delete(fmt.Sprintf("identity/group-alias/id/%s", id)); err != nil {
+			return errors.Wrapf(err, "error deleting group-alias %s from vault", name)
+		}
+	}
+
+	return nil
+}
+
+func (v *vault) planIdentityGroups(plan *Plan, config *viper.Viper, purge purgeUnmanagedConfig) error {
+	groups := []map[string]interface{}{}
+	groupAliases := []map[string]interface{}{}
+
+	err := config.UnmarshalKey("groups", &groups)
+	if err != nil {
+		return errors.Wrap(err, "error unmarshalling vault groups config")
+	}
+
+	err = config.UnmarshalKey("group-aliases", &groupAliases)
+	if err != nil {
+		return errors.Wrap(err, "error unmarshalling vault group aliases config")
+	}
+
+	if purge.Enabled && !purge.Exclude.Groups {
+		unmanagedGroups, err := v.getUnmanagedGroups(groups)
+		if err != nil {
+			return err
+		}
+
+		for groupName := range unmanagedGroups {
+			plan.remove("groups", groupName)
+		}
+	}
+
+	if purge.Enabled && !purge.Exclude.GroupAliases {
+		unmanagedGroupAliases, err := v.getUnmanagedGroupAliases(groupAliases)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range unmanagedGroupAliases {
+			plan.remove("group-aliases", name)
+		}
+	}
+
+	for _, group := range groups {
+		groupName := cast.ToString(group["name"])
+
+		g, err := readVaultGroup(groupName, v.cl)
+		if err != nil {
+			return errors.Wrap(err, "error reading group")
+		}
+
+		if g == nil {
+			plan.add("groups", groupName)
+			continue
+		}
+
+		desired := map[string]interface{}{
+			"type":     cast.ToString(group["type"]),
+			"policies": cast.ToStringSlice(group["policies"]),
+			"metadata": cast.ToStringMap(group["metadata"]),
+		}
+
+		if fields := configChanges(desired, g.Data); len(fields) > 0 {
+			plan.change("groups", groupName, fields...)
+		}
+	}
+
+	for _, groupAlias := range groupAliases {
+		aliasName := cast.ToString(groupAlias["name"])
+		mountPath := cast.ToString(groupAlias["mountpath"])
+		planName := fmt.Sprintf("%s@%s", aliasName, strings.Trim(mountPath, "/"))
+
+		accessor, err := getVaultAuthMountAccessor(mountPath, v.cl)
+		if err != nil {
+			// The auth mount is not there yet, so the alias will be created as well
+			plan.add("group-aliases", planName)
+			continue
+		}
+
+		ga, err := findVaultGroupAliasIDFromNameAndMount(aliasName, accessor, v.cl)
+		if err != nil {
+			return errors.Wrapf(err, "error finding group-alias %s", aliasName)
+		}
+
+		if ga == "" {
+			plan.add("group-aliases", planName)
+			continue
+		}
+
+		alias, err := readVaultGroupAlias(ga, v.cl)
+		if err != nil {
+			return errors.Wrapf(err, "error reading group alias %s", ga)
+		}
+
+		g, err := readVaultGroup(cast.ToString(groupAlias["group"]), v.cl)
+		if err != nil {
+			return errors.Wrap(err, "error reading group")
+		}
+
+		if alias != nil && (g == nil || cast.ToString(alias.Data["canonical_id"]) != cast.ToString(g.Data["id"])) {
+			plan.change("group-aliases", planName, "canonical_id")
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,251 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"emperror.dev/errors"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+)
+
+func TestCanceledContext(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	cl, err := api.NewClient(&api.Config{Address: server.URL})
+	assert.NoError(t, err)
+
+	store, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, store.Set("vault-unseal-0", []byte("key-0")))
+	assert.NoError(t, store.Set("vault-root", []byte("root")))
+
+	v, err := New(store, cl, Config{})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = v.Unseal(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	err = v.Configure(ctx, viper.New())
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	assert.Equal(t, 0, requests)
+}
+
+// fakeVault responds to the requests in responses (keyed by method and path, with LIST for list
+// requests), accepts all writes and deletions, and records them. The paths of the requests sent
+// to a namespace are prefixed with "<namespace>:".
+type fakeVault struct {
+	responses map[string]string
+	written   map[string]map[string]interface{}
+	deleted   []string
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.Method
+	if r.URL.Query().Get("list") == "true" {
+		method = "LIST"
+	}
+
+	prefix := ""
+	if namespace := r.Header.Get("X-Vault-Namespace"); namespace != "" {
+		prefix = namespace + ":"
+	}
+	path := prefix + r.URL.Path
+
+	switch method {
+	case http.MethodGet, "LIST":
+		response, ok := f.responses[prefix+method+" "+r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	case http.MethodDelete:
+		f.deleted = append(f.deleted, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		var data map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&data)
+		if f.written == nil {
+			f.written = map[string]map[string]interface{}{}
+		}
+		f.written[path] = data
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestPurgeUnmanaged(t *testing.T) {
+	fake := &fakeVault{responses: map[string]string{
+		"GET /v1/sys/audit":                         `{"data": {"file/": {"type": "file", "path": "file/"}, "syslog/": {"type": "syslog", "path": "syslog/"}}}`,
+		"GET /v1/sys/plugins/catalog":               `{"data": {"auth": ["jwt", "old-auth"], "secret": ["kv", "custom"]}}`,
+		"GET /v1/sys/plugins/catalog/auth/jwt":      `{"data": {"name": "jwt", "builtin": true}}`,
+		"GET /v1/sys/plugins/catalog/auth/old-auth": `{"data": {"name": "old-auth", "builtin": false}}`,
+		"GET /v1/sys/plugins/catalog/secret/kv":     `{"data": {"name": "kv", "builtin": true}}`,
+		"GET /v1/sys/auth":                          `{"data": {"oidc/": {"type": "oidc", "accessor": "auth_oidc_1"}}}`,
+		"LIST /v1/identity/group/name":              `{"data": {"keys": ["admins", "stale"]}}`,
+		"GET /v1/identity/group/name/admins":        `{"data": {"id": "group-1", "name": "admins"}}`,
+		"LIST /v1/identity/group-alias/id":          `{"data": {"keys": ["alias-1", "alias-2"]}}`,
+		"GET /v1/identity/group-alias/id/alias-1":   `{"data": {"name": "admins", "mount_accessor": "auth_oidc_1"}}`,
+		"GET /v1/identity/group-alias/id/alias-2":   `{"data": {"name": "stale", "mount_accessor": "auth_oidc_1"}}`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cl, err := api.NewClient(&api.Config{Address: server.URL})
+	assert.NoError(t, err)
+
+	v := &vault{cl: cl}
+
+	config := viper.New()
+	config.SetConfigType("yaml")
+	assert.NoError(t, config.ReadConfig(strings.NewReader(`
+audit:
+  - type: file
+plugins:
+  - plugin_name: custom
+    type: secret
+    command: custom
+    sha256: abcd
+groups:
+  - name: admins
+    type: external
+group-aliases:
+  - name: admins
+    mountpath: oidc
+    group: admins
+`)))
+
+	extConfig = externalConfig{}
+	extConfig.PurgeUnmanagedConfig.Enabled = true
+	extConfig.PurgeUnmanagedConfig.Exclude.Audit = true
+	defer func() { extConfig = externalConfig{} }()
+
+	assert.NoError(t, v.configureAuditDevices(config))
+	assert.NoError(t, v.configurePlugins(config))
+	assert.NoError(t, v.configureIdentityGroups(config))
+
+	assert.Equal(t, []string{
+		"/v1/sys/plugins/catalog/auth/old-auth",
+		"/v1/identity/group-alias/id/alias-2",
+		"/v1/identity/group/name/stale",
+	}, fake.deleted)
+
+	plan := &Plan{}
+	purge := purgeUnmanagedConfig{Enabled: true}
+	assert.NoError(t, v.planAuditDevices(plan, config, purge))
+	assert.NoError(t, v.planPlugins(plan, config, purge))
+
+	removed := []string{}
+	for _, item := range plan.Items {
+		if item.Action == PlanActionRemove {
+			removed = append(removed, item.Section+" "+item.Name)
+		}
+	}
+	sort.Strings(removed)
+	assert.Equal(t, []string{"audit syslog", "plugins old-auth"}, removed)
+}
+
+func TestConfigureQuotas(t *testing.T) {
+	fake := &fakeVault{responses: map[string]string{
+		"GET /v1/sys/quotas/rate-limit/global":  `{"data": {"name": "global", "type": "rate-limit", "path": "", "rate": 1000, "interval": 1}}`,
+		"GET /v1/sys/quotas/rate-limit/approle": `{"data": {"name": "approle", "type": "rate-limit", "path": "auth/approle/", "role": "ci", "rate": 10, "interval": 1}}`,
+		"GET /v1/sys/quotas/rate-limit/kv":      `{"data": {"name": "kv", "type": "rate-limit", "path": "kv/", "rate": 50, "interval": 1}}`,
+		"LIST /v1/sys/quotas/rate-limit":        `{"data": {"keys": ["global", "approle", "kv", "stale"]}}`,
+		"LIST /v1/sys/quotas/lease-count":       `{"data": {"keys": ["leases"]}}`,
+		"GET /v1/sys/quotas/lease-count/leases": `{"data": {"name": "leases", "type": "lease-count", "max_leases": 100}}`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cl, err := api.NewClient(&api.Config{Address: server.URL})
+	assert.NoError(t, err)
+
+	v := &vault{cl: cl}
+
+	config := viper.New()
+	config.SetConfigType("yaml")
+	assert.NoError(t, config.ReadConfig(strings.NewReader(`
+quotas:
+  - name: global
+    type: rate-limit
+    rate: 1000
+    interval: 1s
+  - name: approle
+    type: rate-limit
+    path: auth/approle/
+    role: ci
+    rate: 20
+  - name: secrets
+    type: rate-limit
+    path: secret
+    rate: 100
+  - name: kv
+    type: rate-limit
+    path: /kv
+    rate: 50
+  - name: leases
+    type: lease-count
+    max_leases: 100
+`)))
+
+	extConfig = externalConfig{}
+	extConfig.PurgeUnmanagedConfig.Enabled = true
+	defer func() { extConfig = externalConfig{} }()
+
+	assert.NoError(t, v.configureQuotas(config))
+
+	written := []string{}
+	for path := range fake.written {
+		written = append(written, path)
+	}
+	sort.Strings(written)
+	assert.Equal(t, []string{"/v1/sys/quotas/rate-limit/approle", "/v1/sys/quotas/rate-limit/secrets"}, written)
+	assert.Equal(t, map[string]interface{}{"path": "auth/approle/", "role": "ci", "rate": float64(20)}, fake.written["/v1/sys/quotas/rate-limit/approle"])
+	// Vault returns the paths with a trailing slash
+	assert.Equal(t, map[string]interface{}{"path": "secret/", "rate": float64(100)}, fake.written["/v1/sys/quotas/rate-limit/secrets"])
+	assert.Equal(t, []string{"/v1/sys/quotas/rate-limit/stale"}, fake.deleted)
+
+	plan := &Plan{}
+	assert.NoError(t, v.planQuotas(plan, config, purgeUnmanagedConfig{Enabled: true}))
+
+	items := []string{}
+	for _, item := range plan.Items {
+		items = append(items, string(item.Action)+" "+item.Name)
+	}
+	sort.Strings(items)
+	assert.Equal(t, []string{"add rate-limit/secrets", "change rate-limit/approle", "remove rate-limit/stale"}, items)
+
+	_, err = quotaName(map[string]interface{}{"name": "global", "type": "concurrency"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,137 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"emperror.dev/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+)
+
+// passwordPolicy is a password generation policy, used by the secret engines generating
+// passwords, like database and ldap.
+type passwordPolicy struct {
+	Name   string `json:"name"`
+	Policy string `json:"policy"`
+}
+
+// getExistingPoliciesOfKind lists the names of the policies under sys/policies/<kind>, it is empty
+// if the kind of policy isn't supported by the Vault server (rgp and egp are Enterprise only).
+func (v *vault) getExistingPoliciesOfKind(kind string) (map[string]bool, error) {
+	existingPolicies := make(map[string]bool)
+
+	secret, err := v.cl.Logical().List("sys/policies/" + kind)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list existing %s policies", kind)
+	}
+	if secret == nil || secret.Data == nil {
+		return existingPolicies, nil
+	}
+
+	for _, name := range cast.ToStringSlice(secret.Data["keys"]) {
+		existingPolicies[name] = true
+	}
+
+	return existingPolicies, nil
+}
+
+// getUnmanagedPoliciesOfKind returns the policies under sys/policies/<kind> which are not managed.
+func (v *vault) getUnmanagedPoliciesOfKind(kind string, managedNames []string) (map[string]bool, error) {
+	unmanagedPolicies, err := v.getExistingPoliciesOfKind(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range managedNames {
+		delete(unmanagedPolicies, name)
+	}
+
+	return unmanagedPolicies, nil
+}
+
+func (v *vault) removeUnmanagedPoliciesOfKind(kind string, managedNames []string) error {
+	unmanagedPolicies, err := v.getUnmanagedPoliciesOfKind(kind, managedNames)
+	if err != nil {
+		return err
+	}
+
+	logrus.Debugf("remove unmanged %s policies %v", kind, unmanagedPolicies)
+	for name := range unmanagedPolicies {
+		if _, err := v.cl.Logical().Delete("sys/policies/" + kind + "/" + name); err != nil {
+			return errors.Wrapf(err, "error deleting %s %s policy from vault", name, kind)
+		}
+	}
+
+	return nil
+}
+
+func (v *vault) configurePasswordPolicies() error {
+	managedNames := make([]string, 0, len(extConfig.PasswordPolicies))
+	for _, passwordPolicy := range extConfig.PasswordPolicies {
+		_, err := v.writeWithWarningCheck("sys/policies/password/"+passwordPolicy.Name, map[string]interface{}{
+			"policy": passwordPolicy.Policy,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error putting %s password policy into vault", passwordPolicy.Name)
+		}
+		managedNames = append(managedNames, passwordPolicy.Name)
+	}
+
+	if extConfig.PurgeUnmanagedConfig.Enabled && !extConfig.PurgeUnmanagedConfig.Exclude.PasswordPolicies {
+		return v.removeUnmanagedPoliciesOfKind("password", managedNames)
+	}
+
+	return nil
+}
+
+func (v *vault) planPasswordPolicies(plan *Plan, config externalConfig) error {
+	existingPolicies, err := v.getExistingPoliciesOfKind("password")
+	if err != nil {
+		return err
+	}
+
+	managedNames := make([]string, 0, len(config.PasswordPolicies))
+	for _, passwordPolicy := range config.PasswordPolicies {
+		managedNames = append(managedNames, passwordPolicy.Name)
+
+		if !existingPolicies[passwordPolicy.Name] {
+			plan.add("passwordPolicies", passwordPolicy.Name)
+
+			continue
+		}
+
+		_, fields, err := v.planPath("sys/policies/password/"+passwordPolicy.Name, map[string]interface{}{
+			"policy": passwordPolicy.Policy,
+		})
+		if err != nil {
+			return err
+		}
+		if len(fields) > 0 {
+			plan.change("passwordPolicies", passwordPolicy.Name, fields...)
+		}
+	}
+
+	if config.PurgeUnmanagedConfig.Enabled && !config.PurgeUnmanagedConfig.Exclude.PasswordPolicies {
+		unmanagedPolicies, err := v.getUnmanagedPoliciesOfKind("password", managedNames)
+		if err != nil {
+			return err
+		}
+		for name := range unmanagedPolicies {
+			plan.remove("passwordPolicies", name)
+		}
+	}
+
+	return nil
+}
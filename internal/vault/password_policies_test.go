@@ -0,0 +1,76 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigurePasswordAndSentinelPolicies(t *testing.T) {
+	fake := &fakeVault{responses: map[string]string{
+		"LIST /v1/sys/policies/password": `{"data": {"keys": ["database", "stale"]}}`,
+		"LIST /v1/sys/policies/egp":      `{"data": {"keys": ["cidr-check", "old-egp"]}}`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cl, err := api.NewClient(&api.Config{Address: server.URL})
+	assert.NoError(t, err)
+
+	v := &vault{cl: cl}
+
+	config := viper.New()
+	config.SetConfigType("yaml")
+	assert.NoError(t, config.ReadConfig(strings.NewReader(`
+purgeUnmanagedConfig:
+  enabled: true
+  exclude:
+    egp: true
+passwordPolicies:
+  - name: database
+    policy: length = 20
+egp:
+  - name: cidr-check
+    enforcement_level: hard-mandatory
+    paths: ["secret/*"]
+    policy: main = rule { true }
+`)))
+
+	extConfig = externalConfig{}
+	defer func() { extConfig = externalConfig{} }()
+	assert.NoError(t, config.Unmarshal(&extConfig))
+
+	assert.NoError(t, v.configurePasswordPolicies())
+	assert.NoError(t, v.configureSentinelPolicies())
+
+	assert.Equal(t, map[string]interface{}{"policy": "length = 20"}, fake.written["/v1/sys/policies/password/database"])
+	assert.Equal(t, map[string]interface{}{
+		"policy":            "main = rule { true }",
+		"enforcement_level": "hard-mandatory",
+		"paths":             []interface{}{"secret/*"},
+	}, fake.written["/v1/sys/policies/egp/cidr-check"])
+
+	// the egp policies are excluded from purging, and there are no rgp ones in Vault
+	assert.Equal(t, []string{"/v1/sys/policies/password/stale"}, fake.deleted)
+
+	extConfig.EGPs[0].Paths = nil
+	assert.EqualError(t, v.configureSentinelPolicies(), "egp policy cidr-check has no paths")
+}
@@ -0,0 +1,381 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	json "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// PlanAction describes what applying the externalConfig would do with a resource.
+type PlanAction string
+
+const (
+	// PlanActionAdd means that the resource doesn't exist in Vault yet.
+	PlanActionAdd PlanAction = "add"
+	// PlanActionChange means that the resource exists but differs from the externalConfig.
+	PlanActionChange PlanAction = "change"
+	// PlanActionRemove means that the resource is unmanaged and would be purged.
+	PlanActionRemove PlanAction = "remove"
+)
+
+var planActionSymbols = map[PlanAction]string{
+	PlanActionAdd:    "+",
+	PlanActionChange: "~",
+	PlanActionRemove: "-",
+}
+
+// PlanItem is a single difference between the externalConfig and the live Vault state.
+type PlanItem struct {
+	// Namespace is empty for the root namespace
+	Namespace string     `json:"namespace,omitempty"`
+	Section   string     `json:"section"`
+	Name      string     `json:"name"`
+	Action    PlanAction `json:"action"`
+	// Fields lists the changed sub-resources or attributes (only for changes).
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Plan holds all the differences between an externalConfig and the live Vault state.
+type Plan struct {
+	ConfigFile string     `json:"configFile,omitempty"`
+	Items      []PlanItem `json:"items"`
+}
+
+func (p *Plan) add(section, name string) {
+	p.Items = append(p.Items, PlanItem{Section: section, Name: name, Action: PlanActionAdd})
+}
+
+func (p *Plan) change(section, name string, fields ...string) {
+	p.Items = append(p.Items, PlanItem{Section: section, Name: name, Action: PlanActionChange, Fields: fields})
+}
+
+func (p *Plan) remove(section, name string) {
+	p.Items = append(p.Items, PlanItem{Section: section, Name: name, Action: PlanActionRemove})
+}
+
+// Empty returns true if applying the externalConfig wouldn't modify Vault.
+func (p *Plan) Empty() bool {
+	return len(p.Items) == 0
+}
+
+// Summary returns the number of additions, changes and removals in the Plan.
+func (p *Plan) Summary() (add, change, remove int) {
+	for _, item := range p.Items {
+		switch item.Action {
+		case PlanActionAdd:
+			add++
+		case PlanActionChange:
+			change++
+		case PlanActionRemove:
+			remove++
+		}
+	}
+
+	return add, change, remove
+}
+
+// WriteText writes the Plan in a human readable diff format.
+func (p *Plan) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	if p.ConfigFile != "" {
+		fmt.Fprintf(&b, "# %s\n", p.ConfigFile)
+	}
+
+	for _, item := range p.Items {
+		fmt.Fprintf(&b, "%s ", planActionSymbols[item.Action])
+		if item.Namespace != "" {
+			fmt.Fprintf(&b, "[%s] ", item.Namespace)
+		}
+		fmt.Fprintf(&b, "%s %s", item.Section, item.Name)
+		if len(item.Fields) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(item.Fields, ", "))
+		}
+		b.WriteString("\n")
+	}
+
+	add, change, remove := p.Summary()
+	fmt.Fprintf(&b, "Plan: %d to add, %d to change, %d to remove.\n", add, change, remove)
+
+	_, err := io.WriteString(w, b.String())
+
+	return errors.Wrap(err, "error writing plan")
+}
+
+// Plan compares the externalConfig with the live Vault state and returns the
+// differences without modifying anything in Vault.
+func (v *vault) Plan(ctx context.Context, config *viper.Viper) (*Plan, error) {
+	clearToken, err := v.useRootToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer clearToken()
+
+	var planConfig externalConfig
+	if err := config.Unmarshal(&planConfig); err != nil {
+		return nil, errors.Wrap(err, "error loading externalConfig")
+	}
+
+	plan := &Plan{ConfigFile: config.ConfigFileUsed(), Items: []PlanItem{}}
+
+	if err := v.planAuthMethods(plan, planConfig); err != nil {
+		return nil, errors.Wrap(err, "error planning auth methods")
+	}
+
+	if err := v.planPolicies(plan, planConfig); err != nil {
+		return nil, errors.Wrap(err, "error planning policies")
+	}
+
+	if err := v.planPasswordPolicies(plan, planConfig); err != nil {
+		return nil, errors.Wrap(err, "error planning password policies")
+	}
+
+	if err := v.planSentinelPolicies(plan, planConfig); err != nil {
+		return nil, errors.Wrap(err, "error planning sentinel policies")
+	}
+
+	if err := v.planSecretsEngines(plan, planConfig); err != nil {
+		return nil, errors.Wrap(err, "error planning secrets engines")
+	}
+
+	if err := v.planPlugins(plan, config, planConfig.PurgeUnmanagedConfig); err != nil {
+		return nil, errors.Wrap(err, "error planning plugins")
+	}
+
+	if err := v.planAuditDevices(plan, config, planConfig.PurgeUnmanagedConfig); err != nil {
+		return nil, errors.Wrap(err, "error planning audit devices")
+	}
+
+	if err := v.planQuotas(plan, config, planConfig.PurgeUnmanagedConfig); err != nil {
+		return nil, errors.Wrap(err, "error planning quotas")
+	}
+
+	if err := v.planStartupSecrets(plan, config); err != nil {
+		return nil, errors.Wrap(err, "error planning startup secrets")
+	}
+
+	if err := v.planIdentityGroups(plan, config, planConfig.PurgeUnmanagedConfig); err != nil {
+		return nil, errors.Wrap(err, "error planning identity groups")
+	}
+
+	if err := v.planIdentityEntities(plan, config, planConfig.PurgeUnmanagedConfig); err != nil {
+		return nil, errors.Wrap(err, "error planning identity entities")
+	}
+
+	if err := v.planNamespaces(plan, config); err != nil {
+		return nil, errors.Wrap(err, "error planning namespaces")
+	}
+
+	return plan, nil
+}
+
+// planPath compares the desired data with what is readable at the given path,
+// it returns whether the path exists and the list of differing keys.
+func (v *vault) planPath(path string, desired map[string]interface{}) (bool, []string, error) {
+	secret, err := v.cl.Logical().Read(path)
+	if err != nil {
+		return false, nil, errors.Wrapf(err, "error reading %s", path)
+	}
+	if secret == nil || secret.Data == nil {
+		return false, nil, nil
+	}
+
+	return true, configChanges(desired, secret.Data), nil
+}
+
+// mountConfigChanges compares the desired tune options of a mount with the current ones.
+func mountConfigChanges(desired map[string]interface{}, actual api.MountConfigOutput) []string {
+	if len(desired) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(actual)
+	if err != nil {
+		return nil
+	}
+
+	var actualConfig map[string]interface{}
+	if err := json.Unmarshal(data, &actualConfig); err != nil {
+		return nil
+	}
+
+	return configChanges(desired, actualConfig)
+}
+
+// configChanges returns the sorted list of keys in desired which differ from actual.
+// Keys not returned by Vault (for example write-only credentials) can't be compared
+// so they are ignored.
+func configChanges(desired, actual map[string]interface{}) []string {
+	var changes []string
+
+	for key, desiredValue := range desired {
+		actualValue, ok := actual[key]
+		if !ok {
+			continue
+		}
+
+		if !valuesEqual(desiredValue, actualValue) {
+			changes = append(changes, key)
+		}
+	}
+
+	sort.Strings(changes)
+
+	return changes
+}
+
+// valuesEqual compares a value coming from the externalConfig with a value read from Vault,
+// taking the usual input conversions of Vault into account (durations, comma separated lists, etc.).
+func valuesEqual(desired, actual interface{}) bool {
+	desired = normalizeValue(desired)
+	actual = normalizeValue(actual)
+
+	if reflect.DeepEqual(desired, actual) {
+		return true
+	}
+
+	// Vault returns null for unset lists and maps
+	if actual == nil {
+		return isEmptyValue(desired)
+	}
+
+	switch actualValue := actual.(type) {
+	case float64:
+		switch desiredValue := desired.(type) {
+		case string:
+			if f, err := strconv.ParseFloat(desiredValue, 64); err == nil {
+				return f == actualValue
+			}
+			if d, err := parseutil.ParseDurationSecond(desiredValue); err == nil {
+				return d.Seconds() == actualValue
+			}
+		}
+
+	case bool:
+		if b, err := cast.ToBoolE(desired); err == nil {
+			return b == actualValue
+		}
+
+	case string:
+		return cast.ToString(desired) == actualValue
+
+	case []interface{}:
+		var desiredList []interface{}
+		switch desiredValue := desired.(type) {
+		case string:
+			for _, item := range strings.Split(desiredValue, ",") {
+				desiredList = append(desiredList, strings.TrimSpace(item))
+			}
+		case []interface{}:
+			desiredList = desiredValue
+		default:
+			return false
+		}
+
+		return reflect.DeepEqual(sortedStrings(desiredList), sortedStrings(actualValue))
+
+	case map[string]interface{}:
+		desiredValue, ok := desired.(map[string]interface{})
+		if !ok {
+			return false
+		}
+
+		return len(configChanges(desiredValue, actualValue)) == 0
+	}
+
+	return false
+}
+
+func isEmptyValue(value interface{}) bool {
+	switch typed := value.(type) {
+	case nil:
+		return true
+	case string:
+		return typed == ""
+	case []interface{}:
+		return len(typed) == 0
+	case map[string]interface{}:
+		return len(typed) == 0
+	default:
+		return false
+	}
+}
+
+// normalizeValue converts the value to plain JSON types to make them comparable.
+func normalizeValue(value interface{}) interface{} {
+	data, err := json.Marshal(stringKeys(value))
+	if err != nil {
+		logrus.Debugf("error normalizing value %#v: %s", value, err.Error())
+		return value
+	}
+
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
+	}
+
+	return normalized
+}
+
+// stringKeys converts nested map[interface{}]interface{} values (coming from YAML) to map[string]interface{}.
+func stringKeys(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			m[cast.ToString(k)] = stringKeys(v)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			m[k] = stringKeys(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(typed))
+		for i, v := range typed {
+			s[i] = stringKeys(v)
+		}
+		return s
+	default:
+		return value
+	}
+}
+
+func sortedStrings(list []interface{}) []string {
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		result = append(result, cast.ToString(item))
+	}
+
+	sort.Strings(result)
+
+	return result
+}
@@ -0,0 +1,72 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigChanges(t *testing.T) {
+	desired := map[string]interface{}{
+		"ttl":                              "1h",
+		"max_ttl":                          "2h",
+		"policies":                         "allow_secrets, default",
+		"bound_service_account_namespaces": []interface{}{"default"},
+		"secret_id":                        "write-only",
+		"orphan":                           "true",
+		"metadata":                         map[interface{}]interface{}{"team": "dev"},
+	}
+
+	actual := map[string]interface{}{
+		"ttl":                              json.Number("3600"),
+		"max_ttl":                          json.Number("3600"),
+		"policies":                         []interface{}{"default", "allow_secrets"},
+		"bound_service_account_namespaces": []interface{}{"default"},
+		"orphan":                           true,
+		"metadata":                         map[string]interface{}{"team": "ops"},
+	}
+
+	assert.Equal(t, []string{"max_ttl", "metadata"}, configChanges(desired, actual))
+}
+
+func TestValuesEqualEmpty(t *testing.T) {
+	assert.True(t, valuesEqual(map[string]interface{}{}, nil))
+	assert.True(t, valuesEqual([]string{}, nil))
+	assert.False(t, valuesEqual("value", nil))
+}
+
+func TestPlanWriteText(t *testing.T) {
+	plan := Plan{ConfigFile: "vault-config.yml"}
+	plan.add("auth", "kubernetes")
+	plan.change("policies", "allow_secrets", "rules")
+	plan.remove("secrets", "old")
+	plan.Items = append(plan.Items, PlanItem{Namespace: "team-a", Section: "auth", Name: "approle", Action: PlanActionAdd})
+
+	var buf bytes.Buffer
+	assert.NoError(t, plan.WriteText(&buf))
+
+	expected := `# vault-config.yml
++ auth kubernetes
+~ policies allow_secrets (rules)
+- secrets old
++ [team-a] auth approle
+Plan: 2 to add, 1 to change, 1 to remove.
+`
+	assert.Equal(t, expected, buf.String())
+}
@@ -15,18 +15,127 @@
 package vault
 
 import (
+	"strings"
+
 	"emperror.dev/errors"
 	"github.com/hashicorp/hcl"
 	hclPrinter "github.com/hashicorp/hcl/hcl/printer"
 	"github.com/sirupsen/logrus"
+
+	"github.com/banzaicloud/bank-vaults/internal/configuration"
+)
+
+// The delimiters of the policy templates differ from the ones of the configuration files,
+// which are rendered earlier, and from the {{identity.entity.id}} like templates of Vault.
+const (
+	policyTemplateLeftDelimiter  = "[["
+	policyTemplateRightDelimiter = "]]"
 )
 
 type policy struct {
-	Name           string `json:"name"`
-	Rules          string `json:"rules"`
+	Name  string `json:"name"`
+	Rules string `json:"rules"`
+	// Vars are the variables of the name, rules and tests templates, the policy is rendered once
+	// for every item of ForEach, a map item is merged over the Vars, any other is set as .item
+	Vars    map[string]interface{} `json:"vars"`
+	ForEach []interface{}          `json:"for_each" mapstructure:"for_each"`
+	Tests   []policyTest           `json:"tests"`
+
 	RulesFormatted string
 }
 
+// policyTest is an expectation evaluated offline against the rules of the policy
+type policyTest struct {
+	Path       string `json:"path"`
+	Capability string `json:"capability"`
+	Allowed    bool   `json:"allowed"`
+}
+
+func (p *policy) templated() bool {
+	return p.Vars != nil || p.ForEach != nil
+}
+
+// render returns the policy with the vars rendered into its templates
+func (p *policy) render(vars map[string]interface{}) (policy, error) {
+	templater := configuration.NewTemplater(policyTemplateLeftDelimiter, policyTemplateRightDelimiter)
+	renderString := func(text string) (string, error) {
+		buffer, err := templater.Template(text, vars)
+		if err != nil {
+			return "", errors.Wrapf(err, "error rendering %s policy template", p.Name)
+		}
+
+		return buffer.String(), nil
+	}
+
+	name, err := renderString(p.Name)
+	if err != nil {
+		return policy{}, err
+	}
+
+	rules, err := renderString(p.Rules)
+	if err != nil {
+		return policy{}, err
+	}
+
+	rendered := policy{Name: name, Rules: rules}
+	for _, test := range p.Tests {
+		if test.Path, err = renderString(test.Path); err != nil {
+			return policy{}, err
+		}
+		rendered.Tests = append(rendered.Tests, test)
+	}
+
+	return rendered, nil
+}
+
+// expandPolicies renders the templated policies, and checks that the names are unique afterwards
+func expandPolicies(policies []policy) ([]policy, error) {
+	expanded := make([]policy, 0, len(policies))
+	for _, p := range policies {
+		if !p.templated() {
+			expanded = append(expanded, p)
+
+			continue
+		}
+
+		items := p.ForEach
+		if items == nil {
+			items = []interface{}{nil}
+		}
+
+		for _, item := range items {
+			vars := make(map[string]interface{}, len(p.Vars)+1)
+			for k, v := range p.Vars {
+				vars[k] = v
+			}
+
+			if itemVars, ok := toConfigMap(item); ok {
+				for k, v := range itemVars {
+					vars[k] = v
+				}
+			} else if item != nil {
+				vars["item"] = item
+			}
+
+			rendered, err := p.render(vars)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, rendered)
+		}
+	}
+
+	names := make(map[string]bool, len(expanded))
+	for _, p := range expanded {
+		if names[p.Name] {
+			return nil, errors.Errorf("policy %s is defined more than once", p.Name)
+		}
+		names[p.Name] = true
+	}
+
+	return expanded, nil
+}
+
 func (p *policy) format() error {
 	// Try to format rules (HCL only)
 	policyRules, err := hclPrinter.Format([]byte(p.Rules))
@@ -81,13 +190,20 @@ func (v *vault) getUnmanagedPolicies(managedPolicies []policy) map[string]bool {
 }
 
 func (v *vault) configurePolicies() error {
+	managedPolicies, err := expandPolicies(extConfig.Policies)
+	if err != nil {
+		return err
+	}
+
 	// Add managed policies.
-	managedPolicies := extConfig.Policies
 	logrus.Debugf("add manged policies %v", managedPolicies)
 	for _, policy := range managedPolicies {
 		if err := policy.format(); err != nil {
 			return errors.Wrapf(err, "error formatting %s policy", policy.Name)
 		}
+		for _, warning := range policy.lint() {
+			logrus.Warnf("%s policy: %s", policy.Name, warning)
+		}
 		if err := v.cl.Sys().PutPolicy(policy.Name, policy.RulesFormatted); err != nil {
 			return errors.Wrapf(err, "error putting %s policy into vault", policy.Name)
 		}
@@ -106,3 +222,49 @@ func (v *vault) configurePolicies() error {
 
 	return nil
 }
+
+func (v *vault) planPolicies(plan *Plan, config externalConfig) error {
+	existingPolicies, err := v.getExistingPolicies()
+	if err != nil {
+		return err
+	}
+
+	policies, err := expandPolicies(config.Policies)
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range policies {
+		if err := policy.format(); err != nil {
+			return errors.Wrapf(err, "error formatting %s policy", policy.Name)
+		}
+
+		if !existingPolicies[policy.Name] {
+			plan.add("policies", policy.Name)
+			continue
+		}
+
+		existingRules, err := v.cl.Sys().GetPolicy(policy.Name)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %s policy from vault", policy.Name)
+		}
+
+		existingPolicy := policy
+		existingPolicy.Rules = existingRules
+		if err := existingPolicy.format(); err != nil {
+			return errors.Wrapf(err, "error formatting existing %s policy", policy.Name)
+		}
+
+		if strings.TrimSpace(existingPolicy.RulesFormatted) != strings.TrimSpace(policy.RulesFormatted) {
+			plan.change("policies", policy.Name, "rules")
+		}
+	}
+
+	if config.PurgeUnmanagedConfig.Enabled && !config.PurgeUnmanagedConfig.Exclude.Policies {
+		for policyName := range v.getUnmanagedPolicies(policies) {
+			plan.remove("policies", policyName)
+		}
+	}
+
+	return nil
+}
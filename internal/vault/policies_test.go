@@ -0,0 +1,158 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandPolicies(t *testing.T) {
+	policies, err := expandPolicies([]policy{
+		{Name: "plain", Rules: `path "[[ .keep ]]" {}`},
+		{
+			Name:    "team-[[ .item ]]",
+			Rules:   `path "[[ .mount ]]/[[ .item ]]/*" { capabilities = ["read"] }`,
+			Vars:    map[string]interface{}{"mount": "secret"},
+			ForEach: []interface{}{"frontend", map[string]interface{}{"item": "backend", "mount": "kv"}},
+			Tests:   []policyTest{{Path: "[[ .mount ]]/[[ .item ]]/db", Capability: "read", Allowed: true}},
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []policy{
+		{Name: "plain", Rules: `path "[[ .keep ]]" {}`},
+		{
+			Name:  "team-frontend",
+			Rules: `path "secret/frontend/*" { capabilities = ["read"] }`,
+			Tests: []policyTest{{Path: "secret/frontend/db", Capability: "read", Allowed: true}},
+		},
+		{
+			Name:  "team-backend",
+			Rules: `path "kv/backend/*" { capabilities = ["read"] }`,
+			Tests: []policyTest{{Path: "kv/backend/db", Capability: "read", Allowed: true}},
+		},
+	}, policies)
+
+	_, err = expandPolicies([]policy{
+		{Name: "team", Rules: "", ForEach: []interface{}{"a", "b"}},
+	})
+	assert.EqualError(t, err, "policy team is defined more than once")
+}
+
+func TestExpandPoliciesConfig(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"vault-config.yml": `
+policies:
+  - name: team-[[ .item ]]
+    rules: path "[[ .mount ]]/[[ .item ]]/*" { capabilities = ["read"] }
+    vars:
+      mount: secret
+    for_each:
+      - frontend
+      - item: backend
+        mount: kv
+`,
+	})
+
+	config, err := LoadConfig(filepath.Join(dir, "vault-config.yml"))
+	assert.NoError(t, err)
+
+	var loaded externalConfig
+	assert.NoError(t, config.Unmarshal(&loaded))
+
+	// The map items are decoded from YAML with interface{} keys
+	policies, err := expandPolicies(loaded.Policies)
+	assert.NoError(t, err)
+	assert.Len(t, policies, 2)
+	assert.Equal(t, `path "secret/frontend/*" { capabilities = ["read"] }`, policies[0].Rules)
+	assert.Equal(t, "team-backend", policies[1].Name)
+	assert.Equal(t, `path "kv/backend/*" { capabilities = ["read"] }`, policies[1].Rules)
+}
+
+func TestPolicyLint(t *testing.T) {
+	p := policy{Name: "admin", Rules: `
+path "*" {
+  capabilities = ["read"]
+}
+path "sys/mounts/*" {
+  capabilities = ["create", "update", "sudo"]
+}
+path "sys/raw/*" {
+  capabilities = ["deny", "sudo"]
+}
+path "secret/+/config" {
+  capabilities = ["sudo"]
+}`}
+
+	assert.Equal(t, []string{
+		`path "*" matches every path`,
+		`path "sys/mounts/*" grants sudo on sys/ paths`,
+	}, p.lint())
+}
+
+func TestPolicyTest(t *testing.T) {
+	p := policy{Name: "app", Rules: `
+path "secret/*" {
+  capabilities = ["read", "list"]
+}
+path "secret/data/+/config" {
+  capabilities = ["update"]
+}
+path "secret/data/admin/*" {
+  capabilities = ["deny"]
+}
+path "secret/data/app" {
+  capabilities = ["create"]
+}`, Tests: []policyTest{
+		{Path: "secret/data/foo", Capability: "read", Allowed: true},
+		{Path: "secret/data/app/config", Capability: "update", Allowed: true},
+		{Path: "secret/data/app/config", Capability: "read", Allowed: false},
+		{Path: "secret/data/admin/config", Capability: "update", Allowed: false},
+		{Path: "secret/data/app", Capability: "read", Allowed: true},
+		{Path: "other/data", Capability: "read", Allowed: false},
+	}}
+
+	failures, err := p.test()
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{
+		4: `app policy should allow read on "secret/data/app"`,
+	}, failures)
+}
+
+func TestPolicyPathMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		matches bool
+	}{
+		{"secret/foo", "secret/foo", true},
+		{"secret/foo", "secret/foo/bar", false},
+		{"secret/*", "secret/foo/bar", true},
+		{"secret/fo*", "secret/foo", true},
+		{"secret/+/bar", "secret/foo/bar", true},
+		{"secret/+/bar", "secret/foo/baz", false},
+		{"secret/+/bar", "secret/foo/bar/baz", false},
+		{"secret/+/ba*", "secret/foo/bar/baz", true},
+		{"secret/+", "secret/foo", true},
+		{"secret/+", "secret/foo/bar", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.matches, policyPathMatches(test.pattern, test.path), "%s %s", test.pattern, test.path)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/hashicorp/hcl"
+)
+
+// policyRules are the path rules of a policy, as far as the offline checks need them
+type policyRules struct {
+	Paths map[string]*policyPathRules `hcl:"path"`
+}
+
+type policyPathRules struct {
+	Capabilities []string `hcl:"capabilities"`
+}
+
+func (p *policy) parseRules() (*policyRules, error) {
+	var rules policyRules
+	if err := hcl.Decode(&rules, p.Rules); err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s policy rules", p.Name)
+	}
+
+	return &rules, nil
+}
+
+// lint returns warnings about the dangerous rules of the policy: sudo on sys/ paths and
+// paths matching every path, the rules which can't be parsed are left to format.
+func (p *policy) lint() []string {
+	rules, err := p.parseRules()
+	if err != nil {
+		return nil
+	}
+
+	var warnings []string
+	for _, path := range sortedPolicyPaths(rules) {
+		capabilities := rules.Paths[path].Capabilities
+		if hasCapability(capabilities, "deny") {
+			continue
+		}
+
+		if matchesEveryPath(path) {
+			warnings = append(warnings, fmt.Sprintf("path %q matches every path", path))
+		}
+
+		if hasCapability(capabilities, "sudo") && (strings.HasPrefix(path, "sys/") || matchesEveryPath(path)) {
+			warnings = append(warnings, fmt.Sprintf("path %q grants sudo on sys/ paths", path))
+		}
+	}
+
+	return warnings
+}
+
+// test evaluates the tests of the policy, and returns a failure message for the failed ones by index
+func (p *policy) test() (map[int]string, error) {
+	if len(p.Tests) == 0 {
+		return nil, nil
+	}
+
+	rules, err := p.parseRules()
+	if err != nil {
+		return nil, err
+	}
+
+	failures := map[int]string{}
+	for i, test := range p.Tests {
+		allowed := rules.allows(test.Path, test.Capability)
+		if allowed != test.Allowed {
+			expected := "allow"
+			if !test.Allowed {
+				expected = "deny"
+			}
+			failures[i] = fmt.Sprintf("%s policy should %s %s on %q", p.Name, expected, test.Capability, test.Path)
+		}
+	}
+
+	return failures, nil
+}
+
+// allows checks if the capability is granted on the path, by the rule Vault would choose for it:
+// an exact match, otherwise the highest priority one of the matching glob and wildcard rules
+func (r *policyRules) allows(path, capability string) bool {
+	var matched string
+	found := false
+	for _, pattern := range sortedPolicyPaths(r) {
+		if !policyPathMatches(pattern, path) {
+			continue
+		}
+		if !found || policyPathPriority(pattern, matched) {
+			matched, found = pattern, true
+		}
+	}
+
+	if !found {
+		return false
+	}
+
+	capabilities := r.Paths[matched].Capabilities
+
+	return !hasCapability(capabilities, "deny") && hasCapability(capabilities, capability)
+}
+
+// policyPathMatches checks if a policy path matches a request path, * is a glob at the end of the
+// policy path, + is a wildcard for a single path segment
+func policyPathMatches(pattern, path string) bool {
+	glob := strings.HasSuffix(pattern, "*")
+	pattern = strings.TrimSuffix(pattern, "*")
+
+	if !strings.Contains(pattern, "+") {
+		if glob {
+			return strings.HasPrefix(path, pattern)
+		}
+
+		return pattern == path
+	}
+
+	patternSegments := strings.Split(pattern, "/")
+	pathSegments := strings.Split(path, "/")
+	for i, segment := range patternSegments {
+		if i >= len(pathSegments) {
+			return false
+		}
+
+		last := i == len(patternSegments)-1
+		switch {
+		case segment == "+":
+		case last && glob:
+			return strings.HasPrefix(pathSegments[i], segment)
+		case segment != pathSegments[i]:
+			return false
+		}
+	}
+
+	return glob || len(pathSegments) == len(patternSegments)
+}
+
+// policyPathPriority checks if the policy path p1 has a higher priority than p2 when both match,
+// following the rules of Vault: exact paths first, then the one with the later first wildcard or
+// glob, the one not ending with a glob, the one with fewer wildcards, the longer one, and finally
+// the lexicographically greater one.
+func policyPathPriority(p1, p2 string) bool {
+	w1, w2 := strings.IndexAny(p1, "+*"), strings.IndexAny(p2, "+*")
+	if (w1 == -1) != (w2 == -1) {
+		return w1 == -1
+	}
+	if w1 != w2 {
+		return w1 > w2
+	}
+
+	g1, g2 := strings.HasSuffix(p1, "*"), strings.HasSuffix(p2, "*")
+	if g1 != g2 {
+		return g2
+	}
+
+	if c1, c2 := strings.Count(p1, "+"), strings.Count(p2, "+"); c1 != c2 {
+		return c1 < c2
+	}
+
+	if len(p1) != len(p2) {
+		return len(p1) > len(p2)
+	}
+
+	return p1 > p2
+}
+
+// matchesEveryPath checks if a policy path consists of wildcards and globs only, like * or +/*
+func matchesEveryPath(path string) bool {
+	return strings.Trim(path, "+*/") == ""
+}
+
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortedPolicyPaths(rules *policyRules) []string {
+	paths := make([]string, 0, len(rules.Paths))
+	for path := range rules.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
@@ -0,0 +1,269 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+
+	"emperror.dev/errors"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// rekeyOperations wraps the sys/rekey or the sys/rekey-recovery-key endpoints,
+// depending on which kind of keys are stored for the Vault instance.
+type rekeyOperations struct {
+	status             func() (*api.RekeyStatusResponse, error)
+	init               func(*api.RekeyInitRequest) (*api.RekeyStatusResponse, error)
+	update             func(shard, nonce string) (*api.RekeyUpdateResponse, error)
+	verificationUpdate func(shard, nonce string) (*api.RekeyVerificationUpdateResponse, error)
+	cancel             func() error
+}
+
+// Rekey generates new unseal keys (or recovery keys in case of auto-unseal) with the
+// configured shares and threshold, authorized by the keys in the key store.
+// The new keys are staged in the key store and are activated in Vault only after that,
+// through the rekey verification, then they replace the stored keys. The new keys are
+// encrypted to the age recipients if configured, the stored keys have to be unencrypted.
+func (v *vault) Rekey(ctx context.Context) error {
+	defer runtime.GC()
+
+	if len(v.config.PGPKeys) > 0 {
+		return errors.New("the new keys can't be encrypted with PGP keys, since they have to be verified, use age recipients instead")
+	}
+
+	sealStatus, err := v.cl.Sys().SealStatus()
+	if err != nil {
+		return errors.Wrap(err, "error checking status")
+	}
+	if sealStatus.Sealed {
+		return errors.New("vault is sealed, can't rekey")
+	}
+
+	keyForID := v.unsealKeyForID
+	stagedKeyForID := v.stagedUnsealKeyForID
+	ops := rekeyOperations{
+		status:             v.cl.Sys().RekeyStatus,
+		init:               v.cl.Sys().RekeyInit,
+		update:             v.cl.Sys().RekeyUpdate,
+		verificationUpdate: v.cl.Sys().RekeyVerificationUpdate,
+		cancel:             v.cl.Sys().RekeyCancel,
+	}
+	if sealStatus.RecoverySeal {
+		keyForID = v.recoveryKeyForID
+		stagedKeyForID = v.stagedRecoveryKeyForID
+		ops = rekeyOperations{
+			status:             v.cl.Sys().RekeyRecoveryKeyStatus,
+			init:               v.cl.Sys().RekeyRecoveryKeyInit,
+			update:             v.cl.Sys().RekeyRecoveryKeyUpdate,
+			verificationUpdate: v.cl.Sys().RekeyRecoveryKeyVerificationUpdate,
+			cancel:             v.cl.Sys().RekeyRecoveryKeyCancel,
+		}
+	}
+
+	status, err := ops.status()
+	if err != nil {
+		return errors.Wrap(err, "error checking rekey status")
+	}
+	if status.Started {
+		return errors.New("a rekey operation is already in progress, cancel it first with 'vault operator rekey -cancel'")
+	}
+
+	// Don't start a rekey which can't be completed with the stored keys
+	if _, err := v.storedKey(ctx, keyForID(0)); err != nil {
+		return err
+	}
+
+	status, err = ops.init(&api.RekeyInitRequest{
+		SecretShares:        v.config.SecretShares,
+		SecretThreshold:     v.config.SecretThreshold,
+		RequireVerification: true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "error starting rekey")
+	}
+
+	logrus.Infof("rekey started, submitting %d of the stored keys", status.Required)
+
+	newKeys, verificationNonce, err := v.rekeyUpdate(ctx, ops, status.Nonce, keyForID)
+	if err != nil {
+		return v.cancelRekey(ops, err)
+	}
+
+	values := make([][]byte, len(newKeys))
+	for i, key := range newKeys {
+		values[i], err = v.encryptShare(i, key)
+		if err != nil {
+			return v.cancelRekey(ops, errors.Wrapf(err, "error encrypting new key %d", i))
+		}
+	}
+
+	// Stage the new keys first, so they are never lost even if replacing the stored ones fails
+	for i, value := range values {
+		if err := v.setAndVerify(ctx, stagedKeyForID(i), value); err != nil {
+			return v.cancelRekey(ops, errors.Wrap(err, "error staging new key"))
+		}
+	}
+
+	logrus.Info("new keys staged in key store, verifying rekey")
+
+	verified := false
+	for i := 0; i < len(newKeys) && !verified; i++ {
+		resp, err := ops.verificationUpdate(newKeys[i], verificationNonce)
+		if err != nil {
+			return v.cancelRekey(ops, errors.Wrap(err, "error verifying rekey"))
+		}
+		verified = resp.Complete
+	}
+	if !verified {
+		return v.cancelRekey(ops, errors.New("rekey verification didn't complete with the new keys"))
+	}
+
+	// From this point on only the new keys are valid
+	for i, value := range values {
+		keyID := keyForID(i)
+		if err := v.setAndVerify(ctx, keyID, value); err != nil {
+			return errors.Wrapf(err, "error replacing key '%s', the new keys are available in the key store as '%s'", keyID, stagedKeyForID(i))
+		}
+
+		logrus.WithField("key", keyID).Info("key replaced in key store")
+	}
+
+	// Remove the keys of the previous rekey which are not part of the new key set
+	for i := len(newKeys); ; i++ {
+		found, err := v.deleteKey(ctx, keyForID(i))
+		if err != nil {
+			return err
+		}
+		if !found {
+			break
+		}
+	}
+
+	for i := range newKeys {
+		if _, err := v.deleteKey(ctx, stagedKeyForID(i)); err != nil {
+			return err
+		}
+	}
+
+	if v.sharesEncrypted() {
+		logrus.Warn("new keys are encrypted to custodians, the stored keys can't be used to unseal vault automatically")
+	}
+
+	logrus.Info("vault rekeyed successfully")
+
+	return nil
+}
+
+// rekeyUpdate submits the stored keys until the rekey completes, returns the new keys and the verification nonce
+func (v *vault) rekeyUpdate(ctx context.Context, ops rekeyOperations, nonce string, keyForID func(int) string) ([]string, string, error) {
+	for i := 0; ; i++ {
+		k, err := v.storedKey(ctx, keyForID(i))
+		if err != nil {
+			return nil, "", err
+		}
+
+		resp, err := ops.update(string(k), nonce)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "error sending rekey update to vault")
+		}
+
+		if resp.Complete {
+			if !resp.VerificationRequired {
+				return nil, "", errors.New("vault didn't require verification for the rekey")
+			}
+
+			return resp.Keys, resp.VerificationNonce, nil
+		}
+	}
+}
+
+// storedKey reads an unseal or recovery key from the key store, the keys encrypted to custodians
+// can't be submitted to Vault.
+func (v *vault) storedKey(ctx context.Context, keyID string) ([]byte, error) {
+	k, err := kv.GetWithContext(ctx, v.keyStore, keyID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get key '%s'", keyID)
+	}
+
+	if encryptedToCustodian(k) {
+		return nil, errors.Errorf("key '%s' is encrypted to a custodian, it can't be submitted to vault", keyID)
+	}
+
+	return k, nil
+}
+
+func (v *vault) cancelRekey(ops rekeyOperations, err error) error {
+	if cancelErr := ops.cancel(); cancelErr != nil {
+		return errors.Combine(err, errors.Wrap(cancelErr, "error canceling rekey"))
+	}
+
+	logrus.Warn("rekey canceled, the stored keys remain valid")
+
+	return err
+}
+
+// setAndVerify overwrites the value of key in the key store and reads it back
+func (v *vault) setAndVerify(ctx context.Context, key string, value []byte) error {
+	if err := kv.SetWithContext(ctx, v.keyStore, key, value); err != nil {
+		return errors.Wrapf(err, "error setting key '%s'", key)
+	}
+
+	stored, err := kv.GetWithContext(ctx, v.keyStore, key)
+	if err != nil {
+		return errors.Wrapf(err, "error reading back key '%s'", key)
+	}
+	if !bytes.Equal(stored, value) {
+		return errors.Errorf("key '%s' read back from the key store doesn't match the written value", key)
+	}
+
+	return nil
+}
+
+// deleteKey removes key from the key store if the key store supports it, returns false if the key doesn't exist
+func (v *vault) deleteKey(ctx context.Context, key string) (bool, error) {
+	notFound, err := v.keyStoreNotFound(ctx, key)
+	if notFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "error checking key '%s'", key)
+	}
+
+	err = kv.Delete(v.keyStore, key)
+	if errors.Is(err, kv.ErrNotSupported) {
+		logrus.WithField("key", key).Warn("key store doesn't support deleting keys, remove the key manually")
+
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "error deleting key '%s'", key)
+	}
+
+	return true, nil
+}
+
+func (*vault) stagedUnsealKeyForID(i int) string {
+	return fmt.Sprint("vault-rekey-unseal-", i)
+}
+
+func (*vault) stagedRecoveryKeyForID(i int) string {
+	return fmt.Sprint("vault-rekey-recovery-", i)
+}
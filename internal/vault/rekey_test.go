@@ -0,0 +1,269 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+)
+
+// fakeOperator implements the sys/rekey and sys/generate-root flows of an unsealed Vault with a threshold of 2
+type fakeOperator struct {
+	sync.Mutex
+
+	unsealKeys map[string]bool
+	newKeys    map[string]bool
+	progress   int
+
+	otp       string
+	rootToken string
+	revoked   []string
+}
+
+func (f *fakeOperator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	key, _ := body["key"].(string)
+
+	var resp map[string]interface{}
+
+	switch r.URL.Path {
+	case "/v1/sys/seal-status":
+		resp = map[string]interface{}{"sealed": false, "type": "shamir"}
+
+	case "/v1/sys/rekey/init":
+		switch r.Method {
+		case http.MethodGet:
+			resp = map[string]interface{}{"started": f.newKeys != nil}
+		case http.MethodDelete:
+			f.newKeys = nil
+		default:
+			f.newKeys = map[string]bool{}
+			f.progress = 0
+			for i := 0; i < int(body["secret_shares"].(float64)); i++ {
+				f.newKeys[fmt.Sprint("new-", i)] = false
+			}
+			resp = map[string]interface{}{"started": true, "nonce": "rekey", "required": 2}
+		}
+
+	case "/v1/sys/rekey/update":
+		if !f.unsealKeys[key] {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.progress++
+		resp = map[string]interface{}{"nonce": "rekey", "complete": f.progress == 2}
+		if f.progress == 2 {
+			var keys []string
+			for i := 0; i < len(f.newKeys); i++ {
+				keys = append(keys, fmt.Sprint("new-", i))
+			}
+			f.progress = 0
+			resp["keys"] = keys
+			resp["verification_required"] = true
+			resp["verification_nonce"] = "verify"
+		}
+
+	case "/v1/sys/rekey/verify":
+		if _, ok := f.newKeys[key]; !ok || body["nonce"] != "verify" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.progress++
+		resp = map[string]interface{}{"nonce": "verify", "complete": f.progress == 2}
+		if f.progress == 2 {
+			f.unsealKeys = f.newKeys
+			f.newKeys = nil
+		}
+
+	case "/v1/sys/generate-root/attempt":
+		switch r.Method {
+		case http.MethodGet:
+			resp = map[string]interface{}{"started": f.otp != "", "otp_length": len(f.rootToken)}
+		case http.MethodDelete:
+			f.otp = ""
+		default:
+			f.otp = body["otp"].(string)
+			f.progress = 0
+			resp = map[string]interface{}{"started": true, "nonce": "root", "required": 2}
+		}
+
+	case "/v1/sys/generate-root/update":
+		if !f.unsealKeys[key] {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.progress++
+		resp = map[string]interface{}{"nonce": "root", "complete": f.progress == 2}
+		if f.progress == 2 {
+			encoded, _ := xorBytes([]byte(f.rootToken), []byte(f.otp))
+			resp["encoded_root_token"] = base64.RawStdEncoding.EncodeToString(encoded)
+		}
+
+	case "/v1/auth/token/revoke-orphan":
+		if r.Header.Get("X-Vault-Token") != f.rootToken {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		f.revoked = append(f.revoked, body["token"].(string))
+	}
+
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newFakeOperatorVault(t *testing.T, f *fakeOperator) (*vault, kv.Service) {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	cl, err := api.NewClient(&api.Config{Address: server.URL})
+	assert.NoError(t, err)
+
+	store, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, store.Set(fmt.Sprint("vault-unseal-", i), []byte(fmt.Sprint("old-", i))))
+		f.unsealKeys[fmt.Sprint("old-", i)] = true
+	}
+	assert.NoError(t, store.Set("vault-root", []byte("old-root")))
+
+	v, err := New(store, cl, Config{SecretShares: 2, SecretThreshold: 2, StoreRootToken: true})
+	assert.NoError(t, err)
+
+	return v.(*vault), store
+}
+
+func TestRekey(t *testing.T) {
+	f := &fakeOperator{unsealKeys: map[string]bool{}}
+	v, store := newFakeOperatorVault(t, f)
+
+	assert.NoError(t, v.Rekey(context.Background()))
+	assert.Equal(t, map[string]bool{"new-0": false, "new-1": false}, f.unsealKeys)
+
+	keys, err := kv.List(store, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-root", "vault-unseal-0", "vault-unseal-1"}, keys)
+
+	for i := 0; i < 2; i++ {
+		value, err := store.Get(fmt.Sprint("vault-unseal-", i))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprint("new-", i)), value)
+	}
+}
+
+func TestRekeyWrongKeys(t *testing.T) {
+	f := &fakeOperator{unsealKeys: map[string]bool{}}
+	v, store := newFakeOperatorVault(t, f)
+
+	assert.NoError(t, store.Set("vault-unseal-0", []byte("invalid")))
+
+	assert.Error(t, v.Rekey(context.Background()))
+	assert.Nil(t, f.newKeys, "rekey must be canceled")
+
+	value, err := store.Get("vault-unseal-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("old-1"), value)
+}
+
+func TestGenerateRoot(t *testing.T) {
+	f := &fakeOperator{unsealKeys: map[string]bool{}, rootToken: "hvs.abcdefghijklmnopqrstuvwx"}
+	v, store := newFakeOperatorVault(t, f)
+
+	assert.NoError(t, v.GenerateRoot(context.Background()))
+
+	value, err := store.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(f.rootToken), value)
+	assert.Equal(t, []string{"old-root"}, f.revoked)
+}
+
+func TestRekeyAgeRecipients(t *testing.T) {
+	f := &fakeOperator{unsealKeys: map[string]bool{}}
+	v, store := newFakeOperatorVault(t, f)
+
+	var identities []*age.X25519Identity
+	for i := 0; i < 2; i++ {
+		identity, err := age.GenerateX25519Identity()
+		assert.NoError(t, err)
+		identities = append(identities, identity)
+		v.shareRecipients = append(v.shareRecipients, identity.Recipient())
+	}
+
+	assert.NoError(t, v.Rekey(context.Background()))
+	assert.Equal(t, map[string]bool{"new-0": false, "new-1": false}, f.unsealKeys)
+
+	for i := 0; i < 2; i++ {
+		value, err := store.Get(fmt.Sprint("vault-unseal-", i))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprint("new-", i), ageDecrypt(t, identities[i], value))
+	}
+
+	// The encrypted keys can't be used for the next rekey, which isn't started at all
+	err := v.Rekey(context.Background())
+	assert.EqualError(t, err, "key 'vault-unseal-0' is encrypted to a custodian, it can't be submitted to vault")
+	assert.Nil(t, f.newKeys)
+}
+
+func TestGenerateRootAgeRecipient(t *testing.T) {
+	f := &fakeOperator{unsealKeys: map[string]bool{}, rootToken: "hvs.abcdefghijklmnopqrstuvwx"}
+	v, store := newFakeOperatorVault(t, f)
+
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	v.rootTokenRecipient = identity.Recipient()
+
+	assert.NoError(t, v.GenerateRoot(context.Background()))
+
+	value, err := store.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, f.rootToken, ageDecrypt(t, identity, value))
+	assert.Equal(t, []string{"old-root"}, f.revoked)
+
+	// The encrypted root token can't be revoked by the next generation
+	f.otp = ""
+	assert.NoError(t, v.GenerateRoot(context.Background()))
+	assert.Equal(t, []string{"old-root"}, f.revoked)
+
+	// Neither can the generation be started with encrypted keys
+	encrypted, err := ageEncrypt(identity.Recipient(), []byte("old-0"))
+	assert.NoError(t, err)
+	assert.NoError(t, store.Set("vault-unseal-0", encrypted))
+
+	f.otp = ""
+	err = v.GenerateRoot(context.Background())
+	assert.EqualError(t, err, "key 'vault-unseal-0' is encrypted to a custodian, it can't be submitted to vault")
+	assert.Empty(t, f.otp)
+}
@@ -0,0 +1,267 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const configSchemaID = "https://github.com/banzaicloud/bank-vaults/vault-config.schema.json"
+
+// builtinAuthTypes are the auth methods Vault ships with, auth plugins registered
+// in the plugins section of the configuration are accepted too
+var builtinAuthTypes = []string{
+	"alicloud",
+	"approle",
+	"aws",
+	"azure",
+	"cert",
+	"cf",
+	"gcp",
+	"github",
+	"jwt",
+	"kerberos",
+	"kubernetes",
+	"ldap",
+	"oci",
+	"oidc",
+	"okta",
+	"radius",
+	"token",
+	"userpass",
+}
+
+// Schema is the subset of JSON Schema used to describe the externalConfig
+type Schema struct {
+	SchemaURI   string `json:"$schema,omitempty"`
+	ID          string `json:"$id,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// Type is empty if any value is allowed
+	Type string   `json:"type,omitempty"`
+	Enum []string `json:"enum,omitempty"`
+
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	// AdditionalProperties is either a bool or a *Schema
+	AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+
+	Items *Schema `json:"items,omitempty"`
+
+	// pluginType accepts the names of the plugins of this type besides the Enum
+	pluginType string
+}
+
+// ConfigSchema returns the JSON Schema of the externalConfig, the typed sections are
+// generated from their structs, the untyped ones are described by hand.
+func ConfigSchema() *Schema {
+	schema := schemaForType(reflect.TypeOf(externalConfig{}), "json")
+	schema.SchemaURI = "http://json-schema.org/draft-07/schema#"
+	schema.ID = configSchemaID
+	schema.Title = "Bank-Vaults Vault configuration"
+
+	authSchema := schema.Properties["auth"].Items
+	authSchema.Required = []string{"type"}
+	authSchema.Properties["type"].Enum = builtinAuthTypes
+	authSchema.Properties["type"].pluginType = "auth"
+	// the mount options are decoded with mapstructure, which ignores unknown keys
+	authSchema.Properties["options"] = schemaForType(reflect.TypeOf(api.AuthConfigInput{}), "mapstructure")
+
+	policiesSchema := schema.Properties["policies"].Items
+	policiesSchema.Required = []string{"name", "rules"}
+	policiesSchema.Properties["vars"].Description = "Variables of the [[ ]] templates in the name, rules and test paths"
+	policiesSchema.Properties["for_each"].Description = "Renders the policy for every item, a map item is merged over the vars, any other is available as .item"
+	policiesSchema.Properties["tests"].Items.Required = []string{"path", "capability", "allowed"}
+	policiesSchema.Properties["tests"].Items.Properties["capability"].Enum = []string{"create", "read", "update", "patch", "delete", "list", "sudo"}
+
+	secretsSchema := schema.Properties["secrets"].Items
+	secretsSchema.Required = []string{"type"}
+	secretsSchema.Properties["config"] = schemaForType(reflect.TypeOf(api.MountConfigInput{}), "mapstructure")
+
+	schema.Properties["purgeUnmanagedConfig"].Description = "Removes the auth methods, policies (including the password and Sentinel ones), secret engines, audit devices, quotas, plugins, groups, entities and their aliases which are not in the configuration"
+	schema.Properties["passwordPolicies"].Description = "Password generation policies to write"
+	schema.Properties["passwordPolicies"].Items.Required = []string{"name", "policy"}
+
+	sentinelEnforcementLevels := []string{"advisory", "soft-mandatory", "hard-mandatory"}
+	schema.Properties["rgp"].Description = "Role governing Sentinel policies to write (Vault Enterprise)"
+	rgpSchema := schema.Properties["rgp"].Items
+	delete(rgpSchema.Properties, "paths")
+	rgpSchema.Required = []string{"name", "policy", "enforcement_level"}
+	rgpSchema.Properties["enforcement_level"].Enum = sentinelEnforcementLevels
+	schema.Properties["egp"].Description = "Endpoint governing Sentinel policies to write (Vault Enterprise)"
+	egpSchema := schema.Properties["egp"].Items
+	egpSchema.Required = []string{"name", "policy", "enforcement_level", "paths"}
+	egpSchema.Properties["enforcement_level"].Enum = sentinelEnforcementLevels
+	schema.Properties["auth"].Description = "Auth methods to enable and configure"
+	schema.Properties["policies"].Description = "Policies to write"
+	schema.Properties["secrets"].Description = "Secret engines to mount and configure"
+
+	schema.Properties["audit"] = &Schema{
+		Description: "Audit devices to enable",
+		Type:        "array",
+		Items: objectSchema(map[string]*Schema{
+			"type":        {Type: "string", Enum: []string{"file", "socket", "syslog"}},
+			"path":        {Type: "string"},
+			"description": {Type: "string"},
+			"options":     {Type: "object", AdditionalProperties: &Schema{}},
+			"local":       {Type: "boolean"},
+		}, "type"),
+	}
+
+	schema.Properties["plugins"] = &Schema{
+		Description: "Plugins to register in the plugin catalog",
+		Type:        "array",
+		Items: objectSchema(map[string]*Schema{
+			"plugin_name": {Type: "string"},
+			"type":        {Type: "string", Enum: []string{"auth", "database", "secret"}},
+			"command":     {Type: "string"},
+			"sha256":      {Type: "string"},
+		}, "plugin_name", "type", "command", "sha256"),
+	}
+
+	schema.Properties["quotas"] = &Schema{
+		Description: "Rate limit and lease count (Vault Enterprise) quotas, scoped to a namespace, mount or role by their path and role",
+		Type:        "array",
+		Items: objectSchema(map[string]*Schema{
+			"name":           {Type: "string"},
+			"type":           {Type: "string", Enum: quotaTypes},
+			"path":           {Type: "string"},
+			"role":           {Type: "string"},
+			"rate":           {Type: "number"},
+			"interval":       {},
+			"block_interval": {},
+			"max_leases":     {Type: "integer"},
+			"inheritable":    {Type: "boolean"},
+		}, "name", "type"),
+	}
+
+	schema.Properties["startupSecrets"] = &Schema{
+		Description: "Secrets to write on startup",
+		Type:        "array",
+		Items: objectSchema(map[string]*Schema{
+			"type": {Type: "string", Enum: []string{"kv", "pki"}},
+			"path": {Type: "string"},
+			"data": {Type: "object", AdditionalProperties: &Schema{}},
+		}, "type", "path"),
+	}
+
+	schema.Properties["groups"] = &Schema{
+		Description: "Identity groups to create",
+		Type:        "array",
+		Items: objectSchema(map[string]*Schema{
+			"name":     {Type: "string"},
+			"type":     {Type: "string", Enum: []string{"external", "internal"}},
+			"policies": {Type: "array", Items: &Schema{Type: "string"}},
+			"metadata": {Type: "object", AdditionalProperties: &Schema{Type: "string"}},
+		}, "name", "type"),
+	}
+
+	schema.Properties["group-aliases"] = &Schema{
+		Description: "Aliases of the external identity groups on the auth methods",
+		Type:        "array",
+		Items: objectSchema(map[string]*Schema{
+			"name":      {Type: "string"},
+			"mountpath": {Type: "string"},
+			"group":     {Type: "string"},
+		}, "name", "mountpath", "group"),
+	}
+
+	schema.Properties["entities"] = &Schema{
+		Description: "Identity entities to create, with their membership in internal groups",
+		Type:        "array",
+		Items: objectSchema(map[string]*Schema{
+			"name":     {Type: "string"},
+			"policies": {Type: "array", Items: &Schema{Type: "string"}},
+			"metadata": {Type: "object", AdditionalProperties: &Schema{Type: "string"}},
+			"disabled": {Type: "boolean"},
+			"groups":   {Type: "array", Items: &Schema{Type: "string"}},
+		}, "name"),
+	}
+
+	schema.Properties["entity-aliases"] = &Schema{
+		Description: "Aliases of the identity entities on the auth methods",
+		Type:        "array",
+		Items: objectSchema(map[string]*Schema{
+			"name":      {Type: "string"},
+			"mountpath": {Type: "string"},
+			"entity":    {Type: "string"},
+		}, "name", "mountpath", "entity"),
+	}
+
+	namespaceProperties := map[string]*Schema{"name": {Type: "string"}}
+	for _, section := range []string{"purgeUnmanagedConfig", "auth", "policies", "secrets", "groups", "group-aliases"} {
+		namespaceProperties[section] = schema.Properties[section]
+	}
+	schema.Properties["namespaces"] = &Schema{
+		Description: "Namespaces to create (Vault Enterprise), with the sections to apply within them",
+		Type:        "array",
+		Items:       objectSchema(namespaceProperties, "name"),
+	}
+
+	schema.Properties[configIncludeKey] = &Schema{
+		Description: "Further files, directories or glob patterns to load, relative to this file",
+	}
+
+	return schema
+}
+
+func objectSchema(properties map[string]*Schema, required ...string) *Schema {
+	return &Schema{
+		Type:                 "object",
+		Properties:           properties,
+		Required:             required,
+		AdditionalProperties: false,
+	}
+}
+
+// schemaForType describes a type by the fields having the tag, like viper (json)
+// or mapstructure decodes the configuration into the structs
+func schemaForType(t reflect.Type, tag string) *Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaForType(t.Elem(), tag)
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaForType(t.Elem(), tag)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaForType(t.Elem(), tag)}
+	case reflect.Struct:
+		properties := map[string]*Schema{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get(tag), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			properties[name] = schemaForType(field.Type, tag)
+		}
+
+		return objectSchema(properties)
+	default:
+		return &Schema{}
+	}
+}
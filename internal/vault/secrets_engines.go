@@ -18,14 +18,19 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 
 	"emperror.dev/errors"
 	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
 	"github.com/mitchellh/mapstructure"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cast"
+	"github.com/spf13/viper"
 
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
 	vaultpkg "github.com/banzaicloud/bank-vaults/pkg/sdk/vault"
 )
 
@@ -38,6 +43,8 @@ var secretEnginesWihtoutNameConfig = map[string]bool{
 	"gcp":      true,
 	"gcpkms":   true,
 	"kv":       true,
+	"ldap":     true,
+	"openldap": true,
 }
 
 type secretEngine struct {
@@ -86,32 +93,260 @@ func (v *vault) mountExists(path string) (bool, error) {
 	return mounts[path+"/"] != nil, nil
 }
 
-func (v *vault) rotateSecretEngineCredentials(secretEngineType, path, name, configPath string) error {
-	var rotatePath string
-	switch secretEngineType {
-	case "aws":
-		rotatePath = fmt.Sprintf("%s/config/rotate-root", path)
-	case "database":
-		rotatePath = fmt.Sprintf("%s/rotate-root/%s", path, name)
-	case "gcp":
-		rotatePath = fmt.Sprintf("%s/%s/rotate", path, name)
+// secretEngineConfigPath returns the path of a configuration entry under a secret engine.
+func secretEngineConfigPath(path, configOption string, name interface{}) string {
+	if name != nil {
+		return fmt.Sprintf("%s/%s/%s", path, configOption, name)
+	}
+
+	return fmt.Sprintf("%s/%s", path, configOption)
+}
+
+// secretEngineConfigExists checks if a configuration entry is already present under a secret engine.
+func (v *vault) secretEngineConfigExists(path, configOption, configPath string) (bool, error) {
+	if configOption == "root/generate" { // the pki generate call is a different beast
+		req := v.cl.NewRequest("GET", fmt.Sprintf("/v1/%s/ca", path))
+		resp, err := v.cl.RawRequestWithContext(context.Background(), req)
+		if resp != nil {
+			defer resp.Body.Close()
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to check pki CA")
+		}
+
+		return resp.StatusCode == http.StatusOK, nil
+	}
+
+	secret, err := v.cl.Logical().Read(configPath)
+	if err != nil {
+		return false, errors.Wrapf(err, "error reading configPath %s", configPath)
+	}
+
+	return secret != nil && secret.Data != nil, nil
+}
+
+// credentialRotation is the rotation of the root credentials set by a secret engine config entry
+type credentialRotation struct {
+	path string
+	// period is the time after which the credentials are rotated again, 0 rotates them only once
+	period time.Duration
+}
+
+// credentialRotationPath returns the endpoint rotating the root credentials of a config entry,
+// if the secret engine supports rotating them.
+func credentialRotationPath(secretEngineType, path, configOption string, name interface{}) (string, bool) {
+	switch {
+	case secretEngineType == "aws" && configOption == "config/root":
+		return fmt.Sprintf("%s/config/rotate-root", path), true
+	case secretEngineType == "database" && configOption == "config":
+		return fmt.Sprintf("%s/rotate-root/%s", path, name), true
+	case secretEngineType == "gcp" && configOption == "config":
+		return fmt.Sprintf("%s/config/rotate-root", path), true
+	case secretEngineType == "gcp" && configOption == "roleset":
+		return fmt.Sprintf("%s/roleset/%s/rotate", path, name), true
+	case (secretEngineType == "ad" || secretEngineType == "azure" ||
+		secretEngineType == "ldap" || secretEngineType == "openldap") && configOption == "config":
+		return fmt.Sprintf("%s/rotate-root", path), true
 	default:
-		return errors.Errorf("secret engine type '%s' doesn't support credential rotation", secretEngineType)
+		return "", false
 	}
+}
 
-	if _, ok := v.rotateCache[rotatePath]; !ok {
-		logrus.Infoln("doing credential rotation at", rotatePath)
+// credentialRotationFor returns the rotation requested by the rotate and rotation_period keys of a config entry,
+// it is nil if none was requested. The keys are removed from the config data, so they aren't pushed to Vault.
+func credentialRotationFor(secretEngine secretEngine, configOption string, name interface{}, subConfigData map[string]interface{}) (*credentialRotation, error) {
+	rotate := cast.ToBool(subConfigData["rotate"])
+	delete(subConfigData, "rotate")
 
-		_, err := v.writeWithWarningCheck(rotatePath, nil)
+	var rotationPeriod time.Duration
+	if period, ok := subConfigData["rotation_period"]; ok {
+		delete(subConfigData, "rotation_period")
+
+		var err error
+		rotationPeriod, err = parseutil.ParseDurationSecond(period)
 		if err != nil {
-			return errors.Wrapf(err, "error rotating credentials for '%s' config in vault", configPath)
+			return nil, errors.Wrapf(err, "error parsing rotation_period of %s/%s", secretEngine.Path, configOption)
 		}
+	}
+
+	if !rotate && rotationPeriod == 0 {
+		return nil, nil
+	}
+
+	rotatePath, ok := credentialRotationPath(secretEngine.Type, secretEngine.Path, configOption, name)
+	if !ok {
+		logrus.Warnf("secret engine type '%s' doesn't support credential rotation of %s, ignoring it", secretEngine.Type, configOption)
+
+		return nil, nil
+	}
+
+	return &credentialRotation{path: rotatePath, period: rotationPeriod}, nil
+}
+
+// rotateSecretEngineCredentials rotates the credentials if they were never rotated, or if the rotation
+// period has passed since the last rotation. The time of the rotation is persisted in the key store,
+// so the credentials aren't rotated again when the configurer restarts.
+func (v *vault) rotateSecretEngineCredentials(ctx context.Context, rotation credentialRotation) error {
+	rotatedAt, err := v.lastCredentialRotation(ctx, rotation.path)
+	if err != nil {
+		return err
+	}
+
+	if !rotatedAt.IsZero() && (rotation.period == 0 || time.Since(rotatedAt) < rotation.period) {
+		logrus.Debugf("credentials were rotated previously at %s, on %s", rotation.path, rotatedAt.Format(time.RFC3339))
+
+		return nil
+	}
+
+	logrus.Infoln("doing credential rotation at", rotation.path)
+
+	_, err = v.writeWithWarningCheck(rotation.path, nil)
+	if err != nil {
+		return errors.Wrapf(err, "error rotating credentials at '%s' in vault", rotation.path)
+	}
+
+	logrus.Infoln("credential got rotated at", rotation.path)
+
+	rotatedAt = time.Now().UTC()
+	v.rotateCache[v.rotationKey(rotation.path)] = rotatedAt
+
+	// The credentials are rotated already, failing here would rotate them again on the retry
+	err = kv.SetWithContext(ctx, v.keyStore, v.rotationKey(rotation.path), []byte(rotatedAt.Format(time.RFC3339)))
+	if err != nil {
+		logrus.Errorf("error persisting the credential rotation at %s, it will be rotated again after a restart: %s", rotation.path, err.Error())
+	}
+
+	return nil
+}
 
-		logrus.Infoln("credential got rotated at", rotatePath)
+// lastCredentialRotation returns the time of the last rotation at rotatePath, or the zero time if there was none.
+func (v *vault) lastCredentialRotation(ctx context.Context, rotatePath string) (time.Time, error) {
+	if rotatedAt, ok := v.rotateCache[v.rotationKey(rotatePath)]; ok {
+		return rotatedAt, nil
+	}
+
+	data, err := kv.GetWithContext(ctx, v.keyStore, v.rotationKey(rotatePath))
+	if isNotFoundError(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "error reading the last credential rotation at %s", rotatePath)
+	}
+
+	// cleared by clearCredentialRotation in the key stores which can't delete keys
+	if len(data) == 0 {
+		return time.Time{}, nil
+	}
+
+	rotatedAt, err := time.Parse(time.RFC3339, string(data))
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "error parsing the last credential rotation at %s", rotatePath)
+	}
+	v.rotateCache[v.rotationKey(rotatePath)] = rotatedAt
+
+	return rotatedAt, nil
+}
+
+// clearCredentialRotation forgets the last rotation at rotatePath, so the credentials of a newly mounted
+// secret engine are rotated even if an earlier mount at the same path was rotated already.
+func (v *vault) clearCredentialRotation(ctx context.Context, rotatePath string) error {
+	key := v.rotationKey(rotatePath)
+	delete(v.rotateCache, key)
+
+	notFound, err := v.keyStoreNotFound(ctx, key)
+	if err != nil {
+		return errors.Wrapf(err, "error reading the last credential rotation at %s", rotatePath)
+	}
+	if notFound {
+		return nil
+	}
+
+	err = kv.Delete(v.keyStore, key)
+	if errors.Is(err, kv.ErrNotSupported) {
+		err = kv.SetWithContext(ctx, v.keyStore, key, []byte{})
+	}
+
+	return errors.Wrapf(err, "error clearing the last credential rotation at %s", rotatePath)
+}
+
+// RotateCredentials rotates the root credentials of the secret engines in the configuration (and in its
+// namespaces) which are due, without applying the rest of it. The config entries which aren't in Vault
+// yet are skipped, since Configure writes them first.
+func (v *vault) RotateCredentials(ctx context.Context, config *viper.Viper) error {
+	clearToken, err := v.useRootToken(ctx)
+	if err != nil {
+		return err
+	}
+	defer clearToken()
+
+	if err := v.rotateCredentials(ctx, config); err != nil {
+		return err
+	}
+
+	namespaces, err := getNamespacesConfig(config)
+	if err != nil {
+		return err
+	}
+
+	for _, namespace := range namespaces {
+		nv, err := v.namespaced(namespace.name)
+		if err != nil {
+			return err
+		}
 
-		v.rotateCache[rotatePath] = true
-	} else {
-		logrus.Infoln("credentials were rotated previously for", rotatePath)
+		if err := nv.rotateCredentials(ctx, namespace.config); err != nil {
+			return errors.Wrapf(err, "error rotating credentials of namespace %s", namespace.name)
+		}
+	}
+
+	return nil
+}
+
+func (v *vault) rotateCredentials(ctx context.Context, config *viper.Viper) error {
+	var rotationConfig externalConfig
+	err := config.Unmarshal(&rotationConfig)
+	if err != nil {
+		return errors.Wrap(err, "error loading externalConfig")
+	}
+
+	for _, secretEngine := range rotationConfig.Secrets {
+		secretEngine.setPath()
+
+		for configOption, configData := range secretEngine.Configuration {
+			configData, err := cast.ToSliceE(configData)
+			if err != nil {
+				return errors.Wrap(err, "error converting config data for secret engine")
+			}
+			for _, subConfigData := range configData {
+				subConfigData, err := cast.ToStringMapE(subConfigData)
+				if err != nil {
+					return errors.Wrap(err, "error converting sub config data for secret engine")
+				}
+
+				name := subConfigData["name"]
+				rotation, err := credentialRotationFor(secretEngine, configOption, name, subConfigData)
+				if err != nil {
+					return err
+				}
+				if rotation == nil {
+					continue
+				}
+
+				configPath := secretEngineConfigPath(secretEngine.Path, configOption, name)
+				exists, err := v.secretEngineConfigExists(secretEngine.Path, configOption, configPath)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					continue
+				}
+
+				err = v.rotateSecretEngineCredentials(ctx, *rotation)
+				if err != nil {
+					return errors.Wrapf(err, "error rotating credentials for '%s' config in vault", configPath)
+				}
+			}
+		}
 	}
 
 	return nil
@@ -171,7 +406,7 @@ func configNeedsNoName(secretEngineType string, configOption string) bool {
 	return false
 }
 
-func (v *vault) addManagedSecretsEngines(managedSecretsEngines []secretEngine) error {
+func (v *vault) addManagedSecretsEngines(ctx context.Context, managedSecretsEngines []secretEngine) error {
 	for _, secretEngine := range managedSecretsEngines {
 		secretEngine.setPath()
 
@@ -239,12 +474,7 @@ func (v *vault) addManagedSecretsEngines(managedSecretsEngines []secretEngine) e
 					}
 				}
 
-				var configPath string
-				if name != nil {
-					configPath = fmt.Sprintf("%s/%s/%s", secretEngine.Path, configOption, name)
-				} else {
-					configPath = fmt.Sprintf("%s/%s", secretEngine.Path, configOption)
-				}
+				configPath := secretEngineConfigPath(secretEngine.Path, configOption, name)
 
 				// Control if the configs should be updated or just Created once and skipped later on
 				// This is a workaround to secrets backend like GCP that will destroy and recreate secrets at every iteration
@@ -252,9 +482,13 @@ func (v *vault) addManagedSecretsEngines(managedSecretsEngines []secretEngine) e
 				// Delete the create_only key from the map, so we don't push it to vault
 				delete(subConfigData, "create_only")
 
-				rotate := cast.ToBool(subConfigData["rotate"])
-				// Delete the rotate key from the map, so we don't push it to vault
-				delete(subConfigData, "rotate")
+				// rotation_period implies rotate
+				rotate := cast.ToBool(subConfigData["rotate"]) || subConfigData["rotation_period"] != nil
+				// The rotate and rotation_period keys are deleted from the map, so we don't push them to vault
+				rotation, err := credentialRotationFor(secretEngine, configOption, name, subConfigData)
+				if err != nil {
+					return err
+				}
 
 				saveTo := cast.ToString(subConfigData["save_to"])
 				// Delete the rotate key from the map, so we don't push it to vault
@@ -262,27 +496,9 @@ func (v *vault) addManagedSecretsEngines(managedSecretsEngines []secretEngine) e
 
 				shouldUpdate := true
 				if (createOnly || rotate) && mountExists {
-					secretExists := false
-					if configOption == "root/generate" { // the pki generate call is a different beast
-						req := v.cl.NewRequest("GET", fmt.Sprintf("/v1/%s/ca", secretEngine.Path))
-						resp, err := v.cl.RawRequestWithContext(context.Background(), req)
-						if resp != nil {
-							defer resp.Body.Close()
-						}
-						if err != nil {
-							return errors.Wrapf(err, "failed to check pki CA")
-						}
-						if resp.StatusCode == http.StatusOK {
-							secretExists = true
-						}
-					} else {
-						secret, err := v.cl.Logical().Read(configPath)
-						if err != nil {
-							return errors.Wrapf(err, "error reading configPath %s", configPath)
-						}
-						if secret != nil && secret.Data != nil {
-							secretExists = true
-						}
+					secretExists, err := v.secretEngineConfigExists(secretEngine.Path, configOption, configPath)
+					if err != nil {
+						return err
 					}
 
 					if secretExists {
@@ -315,17 +531,18 @@ func (v *vault) addManagedSecretsEngines(managedSecretsEngines []secretEngine) e
 				}
 
 				// For secret engines where the root credentials are rotatable we don't wan't to reconfigure again
-				// with the old credentials, because that would cause access denied issues. Currently these are:
-				// - AWS
-				// - Database
-				if rotate && mountExists &&
-					((secretEngine.Type == "database" && configOption == "config") ||
-						(secretEngine.Type == "aws" && configOption == "config/root")) {
-					// TODO we need to find out if it was rotated or not
-					err = v.rotateSecretEngineCredentials(secretEngine.Type, secretEngine.Path, name.(string), configPath)
+				// with the old credentials, because that would cause access denied issues.
+				if rotation != nil && mountExists {
+					err = v.rotateSecretEngineCredentials(ctx, *rotation)
 					if err != nil {
 						return errors.Wrapf(err, "error rotating credentials for '%s' config in vault", configPath)
 					}
+				} else if rotation != nil {
+					// The rotation state of an earlier mount at the same path doesn't apply to the new credentials
+					err = v.clearCredentialRotation(ctx, rotation.path)
+					if err != nil {
+						return err
+					}
 				}
 			}
 		}
@@ -351,12 +568,12 @@ func (v *vault) removeUnmanagedSecretsEngines(unmanagedSecretsEngines map[string
 	return nil
 }
 
-func (v *vault) configureSecretsEngines() error {
+func (v *vault) configureSecretsEngines(ctx context.Context) error {
 	managedSecretsEngines := extConfig.Secrets
 	existingSecretsEngines, _ := v.getExistingSecretsEngines()
 	unmanagedSecretsEngines := getUnmanagedSecretsEngines(existingSecretsEngines, managedSecretsEngines)
 
-	err := v.addManagedSecretsEngines(managedSecretsEngines)
+	err := v.addManagedSecretsEngines(ctx, managedSecretsEngines)
 	if err != nil {
 		return errors.Wrap(err, "error adding managed secrets engines")
 	}
@@ -368,3 +585,107 @@ func (v *vault) configureSecretsEngines() error {
 
 	return nil
 }
+
+func (v *vault) planSecretsEngines(plan *Plan, config externalConfig) error {
+	existingSecretsEngines, err := v.getExistingSecretsEngines()
+	if err != nil {
+		return err
+	}
+
+	mounts, err := v.cl.Sys().ListMounts()
+	if err != nil {
+		return errors.Wrap(err, "error reading mounts from vault")
+	}
+
+	for _, secretEngine := range config.Secrets {
+		secretEngine.setPath()
+
+		if !existingSecretsEngines[secretEngine.Path] {
+			plan.add("secrets", secretEngine.Path)
+			continue
+		}
+
+		changes, err := v.planSecretsEngine(secretEngine, mounts[secretEngine.Path+"/"])
+		if err != nil {
+			return errors.Wrapf(err, "error planning %s secret engine", secretEngine.Path)
+		}
+
+		if len(changes) > 0 {
+			plan.change("secrets", secretEngine.Path, changes...)
+		}
+	}
+
+	if config.PurgeUnmanagedConfig.Enabled && !config.PurgeUnmanagedConfig.Exclude.Secrets {
+		for secretEnginePath := range getUnmanagedSecretsEngines(existingSecretsEngines, config.Secrets) {
+			plan.remove("secrets", secretEnginePath)
+		}
+	}
+
+	return nil
+}
+
+func (v *vault) planSecretsEngine(secretEngine secretEngine, mount *api.MountOutput) ([]string, error) {
+	var changes []string
+
+	if mount != nil {
+		if len(mountConfigChanges(secretEngine.Config, mount.Config)) > 0 {
+			changes = append(changes, "config")
+		}
+
+		if secretEngine.Options != nil && !valuesEqual(secretEngine.Options, mount.Options) {
+			changes = append(changes, "options")
+		}
+	}
+
+	for configOption, configData := range secretEngine.Configuration {
+		configData, err := cast.ToSliceE(configData)
+		if err != nil {
+			return nil, errors.Wrap(err, "error converting config data for secret engine")
+		}
+
+		for _, subConfigData := range configData {
+			subConfigData, err := cast.ToStringMapE(subConfigData)
+			if err != nil {
+				return nil, errors.Wrap(err, "error converting sub config data for secret engine")
+			}
+
+			name := subConfigData["name"]
+			configPath := secretEngineConfigPath(secretEngine.Path, configOption, name)
+			changeName := strings.TrimPrefix(configPath, secretEngine.Path+"/")
+
+			createOnly := cast.ToBool(subConfigData["create_only"])
+			rotate := cast.ToBool(subConfigData["rotate"]) || subConfigData["rotation_period"] != nil
+
+			desired := make(map[string]interface{}, len(subConfigData))
+			for k, v := range subConfigData {
+				if k != "create_only" && k != "rotate" && k != "rotation_period" && k != "save_to" {
+					desired[k] = v
+				}
+			}
+
+			if createOnly || rotate || configOption == "root/generate" {
+				exists, err := v.secretEngineConfigExists(secretEngine.Path, configOption, configPath)
+				if err != nil {
+					return nil, err
+				}
+				if !exists {
+					changes = append(changes, changeName)
+				}
+
+				continue
+			}
+
+			exists, fields, err := v.planPath(configPath, desired)
+			if err != nil {
+				return nil, err
+			}
+			if !exists || len(fields) > 0 {
+				changes = append(changes, changeName)
+			}
+		}
+	}
+
+	sort.Strings(changes)
+
+	return changes, nil
+}
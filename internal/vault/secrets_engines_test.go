@@ -0,0 +1,165 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+)
+
+func TestRotateCredentials(t *testing.T) {
+	fake := &fakeVault{responses: map[string]string{
+		"GET /v1/database/config/my-mysql": `{"data": {"plugin_name": "mysql-database-plugin"}}`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cl, err := api.NewClient(&api.Config{Address: server.URL})
+	assert.NoError(t, err)
+
+	store, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, store.Set("vault-root", []byte("root")))
+
+	config := viper.New()
+	config.SetConfigType("yaml")
+	assert.NoError(t, config.ReadConfig(strings.NewReader(`
+secrets:
+  - type: database
+    configuration:
+      config:
+        - name: my-mysql
+          rotation_period: 720h
+  - type: aws
+    configuration:
+      config/root:
+        - access_key: key
+          rotate: true
+`)))
+
+	rotate := func() {
+		fake.written = nil
+
+		v, err := New(store, cl, Config{})
+		assert.NoError(t, err)
+		assert.NoError(t, v.RotateCredentials(context.Background(), config))
+	}
+
+	// the aws config isn't in Vault yet, so only the database is rotated
+	rotate()
+	assert.Equal(t, []string{"/v1/database/rotate-root/my-mysql"}, writtenPaths(fake))
+
+	// the rotation is persisted, so a restarted configurer doesn't rotate again
+	rotate()
+	assert.Empty(t, writtenPaths(fake))
+
+	// until the rotation period passes
+	expiredAt := time.Now().Add(-721 * time.Hour).Format(time.RFC3339)
+	assert.NoError(t, store.Set("vault-rotation-database.rotate-root.my-mysql", []byte(expiredAt)))
+	rotate()
+	assert.Equal(t, []string{"/v1/database/rotate-root/my-mysql"}, writtenPaths(fake))
+
+	rotatedAt, err := store.Get("vault-rotation-database.rotate-root.my-mysql")
+	assert.NoError(t, err)
+	assert.NotEqual(t, expiredAt, string(rotatedAt))
+}
+
+func TestClearCredentialRotation(t *testing.T) {
+	fake := &fakeVault{responses: map[string]string{
+		"GET /v1/sys/mounts": `{"data": {}}`,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cl, err := api.NewClient(&api.Config{Address: server.URL})
+	assert.NoError(t, err)
+
+	deleting, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	// The same store without Delete support, where the rotation is overwritten
+	overwriting := struct{ kv.Service }{deleting}
+
+	rotatedAt := time.Now().UTC().Format(time.RFC3339)
+
+	for _, store := range []kv.Service{deleting, overwriting} {
+		assert.NoError(t, deleting.Set("vault-rotation-database.rotate-root.my-mysql", []byte(rotatedAt)))
+
+		secretEngines := []secretEngine{{
+			Type: "database",
+			Configuration: map[string]interface{}{
+				"config": []interface{}{map[string]interface{}{"name": "my-mysql", "rotate": true}},
+			},
+		}}
+
+		v, err := New(store, cl, Config{})
+		assert.NoError(t, err)
+
+		// The database is mounted again, the rotation of the earlier mount is forgotten
+		assert.NoError(t, v.(*vault).addManagedSecretsEngines(context.Background(), secretEngines))
+		assert.Contains(t, fake.written, "/v1/sys/mounts/database")
+		assert.Contains(t, fake.written, "/v1/database/config/my-mysql")
+
+		last, err := v.(*vault).lastCredentialRotation(context.Background(), "database/rotate-root/my-mysql")
+		assert.NoError(t, err)
+		assert.True(t, last.IsZero())
+	}
+
+	_, err = deleting.Get("vault-rotation-database.rotate-root.my-mysql")
+	assert.NoError(t, err, "overwritten by the store without Delete support")
+}
+
+func TestCredentialRotationPath(t *testing.T) {
+	tests := []struct {
+		engineType   string
+		configOption string
+		name         interface{}
+		path         string
+	}{
+		{"aws", "config/root", nil, "aws/config/rotate-root"},
+		{"database", "config", "my-mysql", "database/rotate-root/my-mysql"},
+		{"gcp", "config", nil, "gcp/config/rotate-root"},
+		{"gcp", "roleset", "my-roleset", "gcp/roleset/my-roleset/rotate"},
+		{"ldap", "config", nil, "ldap/rotate-root"},
+		{"azure", "config", nil, "azure/rotate-root"},
+		{"ad", "config", nil, "ad/rotate-root"},
+		{"rabbitmq", "config/connection", nil, ""},
+	}
+
+	for _, test := range tests {
+		path, ok := credentialRotationPath(test.engineType, test.engineType, test.configOption, test.name)
+		assert.Equal(t, test.path, path)
+		assert.Equal(t, test.path != "", ok)
+	}
+}
+
+func writtenPaths(fake *fakeVault) []string {
+	paths := []string{}
+	for path := range fake.written {
+		paths = append(paths, path)
+	}
+
+	return paths
+}
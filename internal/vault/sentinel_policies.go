@@ -0,0 +1,138 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"emperror.dev/errors"
+)
+
+// sentinelPolicy is a Vault Enterprise role governing (rgp) or endpoint governing (egp) policy,
+// Paths are the request paths an egp policy applies to.
+type sentinelPolicy struct {
+	Name             string   `json:"name"`
+	Policy           string   `json:"policy"`
+	EnforcementLevel string   `json:"enforcement_level" mapstructure:"enforcement_level"`
+	Paths            []string `json:"paths"`
+}
+
+func (p *sentinelPolicy) data(kind string) (map[string]interface{}, error) {
+	data := map[string]interface{}{
+		"policy":            p.Policy,
+		"enforcement_level": p.EnforcementLevel,
+	}
+
+	switch kind {
+	case "egp":
+		if len(p.Paths) == 0 {
+			return nil, errors.Errorf("egp policy %s has no paths", p.Name)
+		}
+		data["paths"] = p.Paths
+	case "rgp":
+		if len(p.Paths) > 0 {
+			return nil, errors.Errorf("rgp policy %s can't have paths", p.Name)
+		}
+	}
+
+	return data, nil
+}
+
+// sentinelPolicyKind holds the managed policies of a kind of sentinel policies, and if they are excluded from purging
+type sentinelPolicyKind struct {
+	kind     string
+	policies []sentinelPolicy
+	exclude  bool
+}
+
+func sentinelPolicyKinds(config externalConfig) []sentinelPolicyKind {
+	return []sentinelPolicyKind{
+		{kind: "rgp", policies: config.RGPs, exclude: config.PurgeUnmanagedConfig.Exclude.RGPs},
+		{kind: "egp", policies: config.EGPs, exclude: config.PurgeUnmanagedConfig.Exclude.EGPs},
+	}
+}
+
+func (v *vault) configureSentinelPolicies() error {
+	for _, managed := range sentinelPolicyKinds(extConfig) {
+		kind := managed.kind
+
+		managedNames := make([]string, 0, len(managed.policies))
+		for _, sentinelPolicy := range managed.policies {
+			data, err := sentinelPolicy.data(kind)
+			if err != nil {
+				return err
+			}
+
+			_, err = v.writeWithWarningCheck("sys/policies/"+kind+"/"+sentinelPolicy.Name, data)
+			if err != nil {
+				return errors.Wrapf(err, "error putting %s %s policy into vault", sentinelPolicy.Name, kind)
+			}
+			managedNames = append(managedNames, sentinelPolicy.Name)
+		}
+
+		if extConfig.PurgeUnmanagedConfig.Enabled && !managed.exclude {
+			if err := v.removeUnmanagedPoliciesOfKind(kind, managedNames); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v *vault) planSentinelPolicies(plan *Plan, config externalConfig) error {
+	for _, managed := range sentinelPolicyKinds(config) {
+		kind := managed.kind
+
+		existingPolicies, err := v.getExistingPoliciesOfKind(kind)
+		if err != nil {
+			return err
+		}
+
+		managedNames := make([]string, 0, len(managed.policies))
+		for _, sentinelPolicy := range managed.policies {
+			managedNames = append(managedNames, sentinelPolicy.Name)
+
+			data, err := sentinelPolicy.data(kind)
+			if err != nil {
+				return err
+			}
+
+			if !existingPolicies[sentinelPolicy.Name] {
+				plan.add(kind, sentinelPolicy.Name)
+
+				continue
+			}
+
+			_, fields, err := v.planPath("sys/policies/"+kind+"/"+sentinelPolicy.Name, data)
+			if err != nil {
+				return err
+			}
+			if len(fields) > 0 {
+				plan.change(kind, sentinelPolicy.Name, fields...)
+			}
+		}
+
+		if config.PurgeUnmanagedConfig.Enabled && !managed.exclude {
+			unmanagedPolicies, err := v.getUnmanagedPoliciesOfKind(kind, managedNames)
+			if err != nil {
+				return err
+			}
+			for name := range unmanagedPolicies {
+				plan.remove(kind, name)
+			}
+		}
+	}
+
+	return nil
+}
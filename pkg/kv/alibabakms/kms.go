@@ -29,7 +29,11 @@ type alibabaKMS struct {
 	kmsID string
 }
 
-var _ kv.Service = &alibabaKMS{}
+var (
+	_ kv.Service = &alibabaKMS{}
+	_ kv.Lister  = &alibabaKMS{}
+	_ kv.Deleter = &alibabaKMS{}
+)
 
 // New creates a new kv.Service encrypted by Alibaba KMS
 func New(regionID, accessKeyID, accessKeySecret, kmsID string, store kv.Service) (kv.Service, error) {
@@ -83,3 +87,11 @@ func (a *alibabaKMS) Set(key string, val []byte) error {
 
 	return a.store.Set(key, cipherText)
 }
+
+func (a *alibabaKMS) List(prefix string) ([]string, error) {
+	return kv.List(a.store, prefix)
+}
+
+func (a *alibabaKMS) Delete(key string) error {
+	return kv.Delete(a.store, key)
+}
@@ -18,6 +18,8 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strings"
 
 	"emperror.dev/errors"
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
@@ -31,6 +33,11 @@ type ossStorage struct {
 	prefix string
 }
 
+var (
+	_ kv.Lister  = &ossStorage{}
+	_ kv.Deleter = &ossStorage{}
+)
+
 // New creates a new kv.Service backed by AWS S3
 func New(endpoint, accessKeyID, accessKeySecret, bucket, prefix string) (kv.Service, error) {
 	client, err := oss.New(endpoint, accessKeyID, accessKeySecret)
@@ -84,6 +91,53 @@ func (o *ossStorage) Get(key string) ([]byte, error) {
 	return b, nil
 }
 
+func (o *ossStorage) List(prefix string) ([]string, error) {
+	objectPrefix := objectNameWithPrefix(o.prefix, prefix)
+
+	bucket, err := o.client.Bucket(o.bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	marker := ""
+	for {
+		result, err := bucket.ListObjects(oss.Prefix(objectPrefix), oss.Marker(marker))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing objects with prefix '%s' in OSS bucket '%s'", objectPrefix, o.bucket)
+		}
+
+		for _, object := range result.Objects {
+			keys = append(keys, strings.TrimPrefix(object.Key, o.prefix))
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextMarker
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (o *ossStorage) Delete(key string) error {
+	objectKey := objectNameWithPrefix(o.prefix, key)
+
+	bucket, err := o.client.Bucket(o.bucket)
+	if err != nil {
+		return err
+	}
+
+	// OSS doesn't report an error for missing objects
+	if err := bucket.DeleteObject(objectKey); err != nil {
+		return errors.Wrapf(err, "error deleting key '%s' from OSS bucket '%s'", objectKey, o.bucket)
+	}
+
+	return nil
+}
+
 func objectNameWithPrefix(prefix, key string) string {
 	return fmt.Sprintf("%s%s", prefix, key)
 }
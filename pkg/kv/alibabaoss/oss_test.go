@@ -0,0 +1,130 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alibabaoss
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// fakeOSS is an in-memory OSS bucket serving the object calls used by ossStorage,
+// the client addresses the buckets in the path since the endpoint is an IP address
+type fakeOSS struct {
+	bucket  string
+	objects map[string][]byte
+}
+
+func (f *fakeOSS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/"+f.bucket+"/") {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+f.bucket+"/")
+
+	switch {
+	case r.Method == http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+		f.objects[key] = data
+
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("marker"))
+
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+
+			return
+		}
+		_, _ = w.Write(data)
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+// list returns the first object after marker to exercise pagination
+func (f *fakeOSS) list(w http.ResponseWriter, prefix, marker string) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > marker {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := oss.ListObjectsResult{Prefix: prefix, Marker: marker}
+	if len(keys) > 0 {
+		result.Objects = []oss.ObjectProperties{{Key: keys[0]}}
+		result.IsTruncated = len(keys) > 1
+		result.NextMarker = keys[0]
+	}
+
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func TestListDelete(t *testing.T) {
+	fake := &fakeOSS{bucket: "bucket", objects: map[string][]byte{"other/vault-unseal-0": nil}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := oss.New(server.URL, "access-key-id", "access-key-secret")
+	assert.NoError(t, err)
+
+	service := &ossStorage{client: client, bucket: "bucket", prefix: "cluster/"}
+
+	for _, key := range []string{"vault-unseal-1", "vault-root", "vault-unseal-0"} {
+		assert.NoError(t, service.Set(key, []byte(key)))
+	}
+
+	value, err := service.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("vault-root"), value)
+
+	keys, err := kv.List(service, "vault-unseal-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-unseal-0", "vault-unseal-1"}, keys)
+
+	assert.NoError(t, kv.Delete(service, "vault-unseal-1"))
+	assert.NotContains(t, fake.objects, "cluster/vault-unseal-1")
+
+	_, err = service.Get("vault-unseal-1")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	keys, err = kv.List(service, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-root", "vault-unseal-0"}, keys)
+}
@@ -15,6 +15,8 @@
 package awskms
 
 import (
+	"context"
+
 	"emperror.dev/errors"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -37,7 +39,12 @@ type awsKMS struct {
 	kmsID string
 }
 
-var _ kv.Service = &awsKMS{}
+var (
+	_ kv.Service        = &awsKMS{}
+	_ kv.Lister         = &awsKMS{}
+	_ kv.Deleter        = &awsKMS{}
+	_ kv.ContextService = &awsKMS{}
+)
 
 // NewWithSession creates a new kv.Service encrypted by AWS KMS with and existing AWS Session
 func NewWithSession(sess *session.Session, store kv.Service, kmsID string) (kv.Service, error) {
@@ -59,8 +66,8 @@ func New(store kv.Service, region string, kmsID string) (kv.Service, error) {
 	return NewWithSession(sess, store, kmsID)
 }
 
-func (a *awsKMS) decrypt(cipherText []byte) ([]byte, error) {
-	out, err := a.kmsService.Decrypt(&kms.DecryptInput{
+func (a *awsKMS) decrypt(ctx context.Context, cipherText []byte) ([]byte, error) {
+	out, err := a.kmsService.DecryptWithContext(ctx, &kms.DecryptInput{
 		CiphertextBlob: cipherText,
 		EncryptionContext: map[string]*string{
 			"Tool": aws.String("bank-vaults"),
@@ -75,16 +82,20 @@ func (a *awsKMS) decrypt(cipherText []byte) ([]byte, error) {
 }
 
 func (a *awsKMS) Get(key string) ([]byte, error) {
-	cipherText, err := a.store.Get(key)
+	return a.GetWithContext(context.Background(), key)
+}
+
+func (a *awsKMS) GetWithContext(ctx context.Context, key string) ([]byte, error) {
+	cipherText, err := kv.GetWithContext(ctx, a.store, key)
 	if err != nil {
 		return nil, errors.WrapIf(err, "failed to get data for KMS client")
 	}
 
-	return a.decrypt(cipherText)
+	return a.decrypt(ctx, cipherText)
 }
 
-func (a *awsKMS) encrypt(plainText []byte) ([]byte, error) {
-	out, err := a.kmsService.Encrypt(&kms.EncryptInput{
+func (a *awsKMS) encrypt(ctx context.Context, plainText []byte) ([]byte, error) {
+	out, err := a.kmsService.EncryptWithContext(ctx, &kms.EncryptInput{
 		KeyId:     aws.String(a.kmsID),
 		Plaintext: plainText,
 		EncryptionContext: map[string]*string{
@@ -100,10 +111,23 @@ func (a *awsKMS) encrypt(plainText []byte) ([]byte, error) {
 }
 
 func (a *awsKMS) Set(key string, val []byte) error {
-	cipherText, err := a.encrypt(val)
+	return a.SetWithContext(context.Background(), key, val)
+}
+
+func (a *awsKMS) SetWithContext(ctx context.Context, key string, val []byte) error {
+	cipherText, err := a.encrypt(ctx, val)
 	if err != nil {
 		return err
 	}
 
-	return a.store.Set(key, cipherText)
+	return kv.SetWithContext(ctx, a.store, key, cipherText)
+}
+
+// List lists the keys of the underlying store, only the values are encrypted with KMS.
+func (a *awsKMS) List(prefix string) ([]string, error) {
+	return kv.List(a.store, prefix)
+}
+
+func (a *awsKMS) Delete(key string) error {
+	return kv.Delete(a.store, key)
 }
@@ -18,6 +18,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"path"
+	"sort"
+	"strings"
 
 	"emperror.dev/errors"
 	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
@@ -34,7 +37,11 @@ type azureKeyVault struct {
 	vaultBaseURL string
 }
 
-var _ kv.Service = &azureKeyVault{}
+var (
+	_ kv.Service = &azureKeyVault{}
+	_ kv.Lister  = &azureKeyVault{}
+	_ kv.Deleter = &azureKeyVault{}
+)
 
 // New creates a new kv.Service backed by Azure Key Vault
 func New(name string) (kv.Service, error) {
@@ -75,3 +82,45 @@ func (a *azureKeyVault) Set(key string, val []byte) error {
 
 	return errors.Wrapf(err, "failed to set key: %s", key)
 }
+
+func (a *azureKeyVault) List(prefix string) ([]string, error) {
+	ctx := context.Background()
+
+	it, err := a.client.GetSecretsComplete(ctx, a.vaultBaseURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list keys")
+	}
+
+	var keys []string
+	for it.NotDone() {
+		// The secret ID is a URL ending with the secret name
+		if id := it.Value().ID; id != nil {
+			if key := path.Base(*id); strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+
+		if err := it.NextWithContext(ctx); err != nil {
+			return nil, errors.Wrap(err, "failed to list keys")
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Delete deletes the secret, if soft-delete is enabled on the Key Vault it stays recoverable until it gets purged.
+func (a *azureKeyVault) Delete(key string) error {
+	_, err := a.client.DeleteSecret(context.Background(), a.vaultBaseURL, key)
+	if err != nil {
+		var aerr autorest.DetailedError
+		if errors.As(err, &aerr) && aerr.StatusCode == http.StatusNotFound {
+			return nil
+		}
+
+		return errors.Wrapf(err, "failed to delete key: %s", key)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azurekv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// fakeKeyVault is an in-memory Key Vault serving the secret calls used by azureKeyVault
+type fakeKeyVault struct {
+	secrets map[string]string
+}
+
+func (f *fakeKeyVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path == "/secrets" {
+		f.list(w, r)
+
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/secrets/"), "/")
+	value, ok := f.secrets[name]
+
+	switch r.Method {
+	case http.MethodPut:
+		var parameters keyvault.SecretSetParameters
+		if err := json.NewDecoder(r.Body).Decode(&parameters); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+		f.secrets[name] = *parameters.Value
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": "http://" + r.Host + "/secrets/" + name, "value": *parameters.Value})
+
+	case http.MethodGet, http.MethodDelete:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"code": "SecretNotFound"}}`))
+
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(f.secrets, name)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": "http://" + r.Host + "/secrets/" + name, "value": value})
+
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+// list returns every secret on a separate page to exercise pagination
+func (f *fakeKeyVault) list(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	for name := range f.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	response := map[string]interface{}{"value": []interface{}{}}
+	if page < len(names) {
+		response["value"] = []interface{}{map[string]string{"id": "http://" + r.Host + "/secrets/" + names[page]}}
+	}
+	if page+1 < len(names) {
+		response["nextLink"] = "http://" + r.Host + "/secrets?api-version=2016-10-01&page=" + strconv.Itoa(page+1)
+	}
+
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func TestListDelete(t *testing.T) {
+	fake := &fakeKeyVault{secrets: map[string]string{"other": "value"}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := keyvault.New()
+	service := &azureKeyVault{client: &client, vaultBaseURL: server.URL}
+
+	for _, key := range []string{"vault-unseal-1", "vault-root", "vault-unseal-0"} {
+		assert.NoError(t, service.Set(key, []byte(key)))
+	}
+
+	value, err := service.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("vault-root"), value)
+
+	keys, err := kv.List(service, "vault-unseal-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-unseal-0", "vault-unseal-1"}, keys)
+
+	assert.NoError(t, kv.Delete(service, "vault-unseal-1"))
+	assert.NotContains(t, fake.secrets, "vault-unseal-1")
+
+	// Deleting a missing key is not an error
+	assert.NoError(t, kv.Delete(service, "vault-unseal-1"))
+
+	_, err = service.Get("vault-unseal-1")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	keys, err = kv.List(service, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other", "vault-root", "vault-unseal-0"}, keys)
+}
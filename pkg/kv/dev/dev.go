@@ -17,6 +17,7 @@ package dev
 import (
 	"io/ioutil"
 	"os"
+	"strings"
 
 	"emperror.dev/errors"
 
@@ -27,6 +28,11 @@ type dev struct {
 	rootToken []byte
 }
 
+var (
+	_ kv.Lister  = &dev{}
+	_ kv.Deleter = &dev{}
+)
+
 // New creates a new kv.Service backed by memory, only the root token is stored, should be used with: vault server -dev
 func New() (service kv.Service, err error) {
 	rootToken := []byte(os.Getenv("VAULT_TOKEN"))
@@ -54,3 +60,17 @@ func (d *dev) Get(key string) ([]byte, error) {
 
 	return nil, kv.NewNotFoundError("key '%s' is not present in dev mode, only visible in server logs", key)
 }
+
+// List returns the root token key only, since nothing else is stored in dev mode.
+func (d *dev) List(prefix string) ([]string, error) {
+	if strings.HasPrefix("vault-root", prefix) {
+		return []string{"vault-root"}, nil
+	}
+
+	return nil, nil
+}
+
+// Delete is not supported, since nothing is stored in dev mode.
+func (d *dev) Delete(key string) error {
+	return errors.WithStack(kv.ErrNotSupported)
+}
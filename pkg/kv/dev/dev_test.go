@@ -0,0 +1,43 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dev
+
+import (
+	"os"
+	"testing"
+
+	"emperror.dev/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+func TestListDelete(t *testing.T) {
+	os.Setenv("VAULT_TOKEN", "root")
+	defer os.Unsetenv("VAULT_TOKEN")
+
+	service, err := New()
+	assert.NoError(t, err)
+
+	keys, err := kv.List(service, "vault-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-root"}, keys)
+
+	keys, err = kv.List(service, "vault-unseal-")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	assert.True(t, errors.Is(kv.Delete(service, "vault-unseal-0"), kv.ErrNotSupported))
+}
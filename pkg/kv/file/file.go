@@ -18,6 +18,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 
 	"emperror.dev/errors"
 
@@ -28,6 +29,11 @@ type file struct {
 	path string
 }
 
+var (
+	_ kv.Lister  = &file{}
+	_ kv.Deleter = &file{}
+)
+
 // New creates a new kv.Service backed by files, without any encryption
 func New(path string) (service kv.Service, err error) {
 	service = &file{path: path}
@@ -47,3 +53,29 @@ func (f *file) Get(key string) ([]byte, error) {
 
 	return val, errors.WrapIff(err, "failed to read file for key: %s", key)
 }
+
+func (f *file) List(prefix string) ([]string, error) {
+	files, err := ioutil.ReadDir(f.path)
+	if err != nil {
+		return nil, errors.WrapIff(err, "failed to read directory: %s", f.path)
+	}
+
+	// ReadDir returns the files sorted by name
+	var keys []string
+	for _, file := range files {
+		if file.Mode().IsRegular() && strings.HasPrefix(file.Name(), prefix) {
+			keys = append(keys, file.Name())
+		}
+	}
+
+	return keys, nil
+}
+
+func (f *file) Delete(key string) error {
+	err := os.Remove(path.Join(f.path, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return errors.WrapIff(err, "failed to remove file for key: %s", key)
+}
@@ -36,7 +36,12 @@ type googleKms struct {
 	keyPath string
 }
 
-var _ kv.Service = &googleKms{}
+var (
+	_ kv.Service        = &googleKms{}
+	_ kv.Lister         = &googleKms{}
+	_ kv.Deleter        = &googleKms{}
+	_ kv.ContextService = &googleKms{}
+)
 
 // New creates a new kv.Service encrypted by Google KMS
 func New(store kv.Service, project, location, keyring, cryptoKey string) (kv.Service, error) {
@@ -58,10 +63,10 @@ func New(store kv.Service, project, location, keyring, cryptoKey string) (kv.Ser
 	}, nil
 }
 
-func (g *googleKms) encrypt(s []byte) ([]byte, error) {
+func (g *googleKms) encrypt(ctx context.Context, s []byte) ([]byte, error) {
 	resp, err := g.svc.Projects.Locations.KeyRings.CryptoKeys.Encrypt(g.keyPath, &cloudkms.EncryptRequest{
 		Plaintext: base64.StdEncoding.EncodeToString(s),
-	}).Do()
+	}).Context(ctx).Do()
 	if err != nil {
 		return nil, errors.Wrap(err, "error encrypting data")
 	}
@@ -69,10 +74,10 @@ func (g *googleKms) encrypt(s []byte) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(resp.Ciphertext)
 }
 
-func (g *googleKms) decrypt(s []byte) ([]byte, error) {
+func (g *googleKms) decrypt(ctx context.Context, s []byte) ([]byte, error) {
 	resp, err := g.svc.Projects.Locations.KeyRings.CryptoKeys.Decrypt(g.keyPath, &cloudkms.DecryptRequest{
 		Ciphertext: base64.StdEncoding.EncodeToString(s),
-	}).Do()
+	}).Context(ctx).Do()
 	if err != nil {
 		return nil, errors.Wrap(err, "error decrypting data")
 	}
@@ -81,19 +86,35 @@ func (g *googleKms) decrypt(s []byte) ([]byte, error) {
 }
 
 func (g *googleKms) Get(key string) ([]byte, error) {
-	cipherText, err := g.store.Get(key)
+	return g.GetWithContext(context.Background(), key)
+}
+
+func (g *googleKms) GetWithContext(ctx context.Context, key string) ([]byte, error) {
+	cipherText, err := kv.GetWithContext(ctx, g.store, key)
 	if err != nil {
 		return nil, errors.Wrap(err, "error getting data")
 	}
 
-	return g.decrypt(cipherText)
+	return g.decrypt(ctx, cipherText)
 }
 
 func (g *googleKms) Set(key string, val []byte) error {
-	cipherText, err := g.encrypt(val)
+	return g.SetWithContext(context.Background(), key, val)
+}
+
+func (g *googleKms) SetWithContext(ctx context.Context, key string, val []byte) error {
+	cipherText, err := g.encrypt(ctx, val)
 	if err != nil {
 		return errors.Wrap(err, "error setting data")
 	}
 
-	return g.store.Set(key, cipherText)
+	return kv.SetWithContext(ctx, g.store, key, cipherText)
+}
+
+func (g *googleKms) List(prefix string) ([]string, error) {
+	return kv.List(g.store, prefix)
+}
+
+func (g *googleKms) Delete(key string) error {
+	return kv.Delete(g.store, key)
 }
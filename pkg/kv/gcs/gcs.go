@@ -18,9 +18,12 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strings"
 
 	"cloud.google.com/go/storage"
 	"emperror.dev/errors"
+	"google.golang.org/api/iterator"
 
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
 )
@@ -31,6 +34,12 @@ type gcsStorage struct {
 	prefix string
 }
 
+var (
+	_ kv.Lister         = &gcsStorage{}
+	_ kv.Deleter        = &gcsStorage{}
+	_ kv.ContextService = &gcsStorage{}
+)
+
 // New creates a new kv.Service backed by Google GCS
 func New(bucket, prefix string) (kv.Service, error) {
 	cl, err := storage.NewClient(context.Background())
@@ -42,7 +51,10 @@ func New(bucket, prefix string) (kv.Service, error) {
 }
 
 func (g *gcsStorage) Set(key string, val []byte) error {
-	ctx := context.Background()
+	return g.SetWithContext(context.Background(), key, val)
+}
+
+func (g *gcsStorage) SetWithContext(ctx context.Context, key string, val []byte) error {
 	n := objectNameWithPrefix(g.prefix, key)
 	w := g.cl.Bucket(g.bucket).Object(n).NewWriter(ctx)
 	defer w.Close()
@@ -55,7 +67,10 @@ func (g *gcsStorage) Set(key string, val []byte) error {
 }
 
 func (g *gcsStorage) Get(key string) ([]byte, error) {
-	ctx := context.Background()
+	return g.GetWithContext(context.Background(), key)
+}
+
+func (g *gcsStorage) GetWithContext(ctx context.Context, key string) ([]byte, error) {
 	n := objectNameWithPrefix(g.prefix, key)
 
 	r, err := g.cl.Bucket(g.bucket).Object(n).NewReader(ctx)
@@ -77,6 +92,41 @@ func (g *gcsStorage) Get(key string) ([]byte, error) {
 	return b, nil
 }
 
+func (g *gcsStorage) List(prefix string) ([]string, error) {
+	ctx := context.Background()
+	n := objectNameWithPrefix(g.prefix, prefix)
+
+	var keys []string
+	it := g.cl.Bucket(g.bucket).Objects(ctx, &storage.Query{Prefix: n})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing objects with prefix '%s' in gcs bucket '%s'", n, g.bucket)
+		}
+
+		keys = append(keys, strings.TrimPrefix(attrs.Name, g.prefix))
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (g *gcsStorage) Delete(key string) error {
+	ctx := context.Background()
+	n := objectNameWithPrefix(g.prefix, key)
+
+	err := g.cl.Bucket(g.bucket).Object(n).Delete(ctx)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return errors.Wrapf(err, "error deleting key '%s' from gcs bucket '%s'", n, g.bucket)
+	}
+
+	return nil
+}
+
 func objectNameWithPrefix(prefix, key string) string {
 	return fmt.Sprintf("%s%s", prefix, key)
 }
@@ -0,0 +1,172 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// fakeGCS is an in-memory GCS bucket serving the JSON API calls and the downloads used by gcsStorage
+type fakeGCS struct {
+	bucket  string
+	objects map[string][]byte
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	objectsPath := "/b/" + f.bucket + "/o"
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1"+objectsPath:
+		name, data, err := readUpload(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+		f.objects[name] = data
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"bucket": f.bucket, "name": name, "size": strconv.Itoa(len(data))})
+
+	case r.Method == http.MethodGet && r.URL.Path == objectsPath:
+		// Return every object on a separate page to exercise pagination
+		var names []string
+		for name := range f.objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+		response := map[string]interface{}{"items": []interface{}{}}
+		if page < len(names) {
+			response["items"] = []interface{}{map[string]string{"bucket": f.bucket, "name": names[page]}}
+		}
+		if page+1 < len(names) {
+			response["nextPageToken"] = strconv.Itoa(page + 1)
+		}
+		_ = json.NewEncoder(w).Encode(response)
+
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, objectsPath+"/"):
+		name := strings.TrimPrefix(r.URL.Path, objectsPath+"/")
+		if _, ok := f.objects[name]; !ok {
+			http.Error(w, `{"error": {"code": 404, "message": "No such object"}}`, http.StatusNotFound)
+
+			return
+		}
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/"+f.bucket+"/"):
+		data, ok := f.objects[strings.TrimPrefix(r.URL.Path, "/"+f.bucket+"/")]
+		if !ok {
+			http.NotFound(w, r)
+
+			return
+		}
+		_, _ = w.Write(data)
+
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+// readUpload reads the name and the content of an object from a multipart upload request
+func readUpload(r *http.Request) (string, []byte, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	reader := multipart.NewReader(r.Body, params["boundary"])
+
+	part, err := reader.NextPart()
+	if err != nil {
+		return "", nil, err
+	}
+	var metadata struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(part).Decode(&metadata); err != nil {
+		return "", nil, err
+	}
+
+	part, err = reader.NextPart()
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := ioutil.ReadAll(part)
+
+	return metadata.Name, data, err
+}
+
+func TestListDelete(t *testing.T) {
+	fake := &fakeGCS{bucket: "bucket", objects: map[string][]byte{"other/vault-unseal-0": nil}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	os.Setenv("STORAGE_EMULATOR_HOST", serverURL.Host)
+	defer os.Unsetenv("STORAGE_EMULATOR_HOST")
+
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL))
+	assert.NoError(t, err)
+
+	service := &gcsStorage{cl: client, bucket: "bucket", prefix: "cluster/"}
+
+	for _, key := range []string{"vault-unseal-1", "vault-root", "vault-unseal-0"} {
+		assert.NoError(t, service.Set(key, []byte(key)))
+	}
+
+	value, err := service.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("vault-root"), value)
+
+	keys, err := kv.List(service, "vault-unseal-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-unseal-0", "vault-unseal-1"}, keys)
+
+	assert.NoError(t, kv.Delete(service, "vault-unseal-1"))
+	assert.NotContains(t, fake.objects, "cluster/vault-unseal-1")
+
+	// Deleting a missing key is not an error
+	assert.NoError(t, kv.Delete(service, "vault-unseal-1"))
+
+	_, err = service.Get("vault-unseal-1")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	keys, err = kv.List(service, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-root", "vault-unseal-0"}, keys)
+}
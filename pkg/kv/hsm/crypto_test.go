@@ -0,0 +1,53 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hsm
+
+import (
+	"testing"
+
+	"emperror.dev/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+)
+
+func TestListDelete(t *testing.T) {
+	store, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	// Only the values are encrypted with the HSM, the keys are listed and deleted in the underlying store
+	for _, key := range []string{"vault-unseal-0", "vault-unseal-1", "vault-root"} {
+		assert.NoError(t, store.Set(key, []byte("ciphertext")))
+	}
+
+	service := &hsmCrypto{storage: store}
+
+	keys, err := kv.List(service, "vault-unseal-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-unseal-0", "vault-unseal-1"}, keys)
+
+	assert.NoError(t, kv.Delete(service, "vault-unseal-1"))
+
+	_, err = store.Get("vault-unseal-1")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	// The underlying store doesn't support the optional operations
+	service = &hsmCrypto{storage: struct{ kv.Service }{store}}
+
+	_, err = kv.List(service, "")
+	assert.True(t, errors.Is(err, kv.ErrNotSupported))
+	assert.True(t, errors.Is(kv.Delete(service, "vault-root"), kv.ErrNotSupported))
+}
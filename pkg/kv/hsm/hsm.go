@@ -18,6 +18,8 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"sort"
+	"strings"
 
 	"emperror.dev/errors"
 	"github.com/miekg/pkcs11"
@@ -42,6 +44,12 @@ type hsmCrypto struct {
 	decrypt    cryptoFunc
 }
 
+var (
+	_ kv.Service = &hsmCrypto{}
+	_ kv.Lister  = &hsmCrypto{}
+	_ kv.Deleter = &hsmCrypto{}
+)
+
 // Config holds the HSM access information
 type Config struct {
 	ModulePath string
@@ -255,6 +263,14 @@ func (h *hsmCrypto) Set(key string, value []byte) error {
 	return h.storage.Set(key, ciphertext)
 }
 
+func (h *hsmCrypto) List(prefix string) ([]string, error) {
+	return kv.List(h.storage, prefix)
+}
+
+func (h *hsmCrypto) Delete(key string) error {
+	return kv.Delete(h.storage, key)
+}
+
 /*
 Purpose: Generate RSA keypair with a given tokenLabel and persistence.
 	tokenLabel: string to set as the token labels
@@ -293,6 +309,12 @@ type hsmStorage struct {
 	session p11.Session
 }
 
+var (
+	_ kv.Service = &hsmStorage{}
+	_ kv.Lister  = &hsmStorage{}
+	_ kv.Deleter = &hsmStorage{}
+)
+
 func (h *hsmStorage) Get(key string) ([]byte, error) {
 	attributes := []*pkcs11.Attribute{
 		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
@@ -322,3 +344,52 @@ func (h *hsmStorage) Set(key string, value []byte) error {
 
 	return errors.Wrap(err, "failed to write object to HSM")
 }
+
+func (h *hsmStorage) List(prefix string) ([]string, error) {
+	attributes := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+	}
+
+	objects, err := h.session.FindObjects(attributes)
+	if err != nil {
+		if err.Error() == noObjectsFoundErrMsg {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to list objects in HSM")
+	}
+
+	var keys []string
+	for _, object := range objects {
+		label, err := object.Label()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read object label from HSM")
+		}
+
+		if strings.HasPrefix(label, prefix) {
+			keys = append(keys, label)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (h *hsmStorage) Delete(key string) error {
+	attributes := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, key),
+	}
+
+	object, err := h.session.FindObject(attributes)
+	if err != nil {
+		if err.Error() == noObjectsFoundErrMsg {
+			return nil
+		}
+
+		return errors.Wrap(err, "failed to read object from HSM")
+	}
+
+	return errors.Wrap(object.Destroy(), "failed to delete object from HSM")
+}
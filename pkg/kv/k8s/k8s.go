@@ -18,6 +18,8 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"sort"
+	"strings"
 
 	"emperror.dev/errors"
 	v1 "k8s.io/api/core/v1"
@@ -35,13 +37,19 @@ import (
 const EnvK8SOwnerReference = "K8S_OWNER_REFERENCE"
 
 type k8sStorage struct {
-	client         *kubernetes.Clientset
+	client         kubernetes.Interface
 	namespace      string
 	secret         string
 	labels         map[string]string
 	ownerReference *metav1.OwnerReference
 }
 
+var (
+	_ kv.Lister         = &k8sStorage{}
+	_ kv.Deleter        = &k8sStorage{}
+	_ kv.ContextService = &k8sStorage{}
+)
+
 // New creates a new kv.Service backed by K8S Secrets
 func New(namespace, secret string, labels map[string]string) (kv.Service, error) {
 	kubeconfig := os.Getenv(clientcmd.RecommendedConfigPathEnvVar)
@@ -83,7 +91,11 @@ func New(namespace, secret string, labels map[string]string) (kv.Service, error)
 }
 
 func (k *k8sStorage) Set(key string, val []byte) error {
-	secret, err := k.client.CoreV1().Secrets(k.namespace).Get(context.Background(), k.secret, metav1.GetOptions{})
+	return k.SetWithContext(context.Background(), key, val)
+}
+
+func (k *k8sStorage) SetWithContext(ctx context.Context, key string, val []byte) error {
+	secret, err := k.client.CoreV1().Secrets(k.namespace).Get(ctx, k.secret, metav1.GetOptions{})
 
 	if k8serrors.IsNotFound(err) {
 		secret := &v1.Secret{
@@ -97,10 +109,10 @@ func (k *k8sStorage) Set(key string, val []byte) error {
 		if k.ownerReference != nil {
 			secret.ObjectMeta.SetOwnerReferences([]metav1.OwnerReference{*k.ownerReference})
 		}
-		_, err = k.client.CoreV1().Secrets(k.namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+		_, err = k.client.CoreV1().Secrets(k.namespace).Create(ctx, secret, metav1.CreateOptions{})
 	} else if err == nil {
 		secret.Data[key] = val
-		_, err = k.client.CoreV1().Secrets(k.namespace).Update(context.Background(), secret, metav1.UpdateOptions{})
+		_, err = k.client.CoreV1().Secrets(k.namespace).Update(ctx, secret, metav1.UpdateOptions{})
 	} else {
 		return errors.Wrapf(err, "error checking if '%s' secret exists", k.secret)
 	}
@@ -113,7 +125,11 @@ func (k *k8sStorage) Set(key string, val []byte) error {
 }
 
 func (k *k8sStorage) Get(key string) ([]byte, error) {
-	secret, err := k.client.CoreV1().Secrets(k.namespace).Get(context.Background(), k.secret, metav1.GetOptions{})
+	return k.GetWithContext(context.Background(), key)
+}
+
+func (k *k8sStorage) GetWithContext(ctx context.Context, key string) ([]byte, error) {
+	secret, err := k.client.CoreV1().Secrets(k.namespace).Get(ctx, k.secret, metav1.GetOptions{})
 	if err != nil {
 		if k8serrors.IsNotFound(err) {
 			return nil, kv.NewNotFoundError("error getting secret for key '%s': %s", key, err.Error())
@@ -129,3 +145,49 @@ func (k *k8sStorage) Get(key string) ([]byte, error) {
 
 	return val, nil
 }
+
+func (k *k8sStorage) List(prefix string) ([]string, error) {
+	secret, err := k.client.CoreV1().Secrets(k.namespace).Get(context.Background(), k.secret, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrapf(err, "error getting secret '%s'", k.secret)
+	}
+
+	var keys []string
+	for key := range secret.Data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (k *k8sStorage) Delete(key string) error {
+	secret, err := k.client.CoreV1().Secrets(k.namespace).Get(context.Background(), k.secret, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+
+		return errors.Wrapf(err, "error getting secret for key '%s'", key)
+	}
+
+	if _, ok := secret.Data[key]; !ok {
+		return nil
+	}
+
+	delete(secret.Data, key)
+
+	_, err = k.client.CoreV1().Secrets(k.namespace).Update(context.Background(), secret, metav1.UpdateOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error deleting secret key '%s' from secret '%s'", key, k.secret)
+	}
+
+	return nil
+}
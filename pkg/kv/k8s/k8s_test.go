@@ -0,0 +1,54 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+func TestListDelete(t *testing.T) {
+	service := &k8sStorage{
+		client:    fake.NewSimpleClientset(),
+		namespace: "default",
+		secret:    "vault-unseal-keys",
+	}
+
+	keys, err := kv.List(service, "")
+	assert.NoError(t, err)
+	assert.Empty(t, keys, "a missing secret has no keys")
+
+	for _, key := range []string{"vault-unseal-1", "vault-root", "vault-unseal-0"} {
+		assert.NoError(t, service.Set(key, []byte(key)))
+	}
+
+	keys, err = kv.List(service, "vault-unseal-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-unseal-0", "vault-unseal-1"}, keys)
+
+	assert.NoError(t, kv.Delete(service, "vault-unseal-1"))
+	assert.NoError(t, kv.Delete(service, "vault-unseal-1"), "deleting a missing key is not an error")
+
+	_, err = service.Get("vault-unseal-1")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	keys, err = kv.List(service, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-root", "vault-unseal-0"}, keys)
+}
@@ -15,6 +15,7 @@
 package kv
 
 import (
+	"context"
 	"fmt"
 
 	"emperror.dev/errors"
@@ -53,3 +54,75 @@ type Service interface {
 	Set(key string, value []byte) error
 	Get(key string) ([]byte, error)
 }
+
+// ContextService is an optional extension of Service for the backends which can
+// cancel their in-flight requests when the context is done.
+type ContextService interface {
+	SetWithContext(ctx context.Context, key string, value []byte) error
+	GetWithContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// ErrNotSupported is returned when the Service doesn't support the requested optional operation.
+var ErrNotSupported = errors.NewPlain("operation is not supported by the key/value Service")
+
+// Lister is an optional extension of Service for the backends which can enumerate their keys.
+type Lister interface {
+	// List returns the keys starting with prefix in lexical order.
+	List(prefix string) ([]string, error)
+}
+
+// Deleter is an optional extension of Service for the backends which can remove keys.
+type Deleter interface {
+	// Delete removes the key, removing a non-existent key is not an error.
+	Delete(key string) error
+}
+
+// List returns the keys of the Service starting with prefix,
+// or ErrNotSupported if the Service doesn't implement Lister.
+func List(service Service, prefix string) ([]string, error) {
+	lister, ok := service.(Lister)
+	if !ok {
+		return nil, errors.WithStack(ErrNotSupported)
+	}
+
+	return lister.List(prefix) // nolint:wrapcheck
+}
+
+// Delete removes the key from the Service,
+// or returns ErrNotSupported if the Service doesn't implement Deleter.
+func Delete(service Service, key string) error {
+	deleter, ok := service.(Deleter)
+	if !ok {
+		return errors.WithStack(ErrNotSupported)
+	}
+
+	return deleter.Delete(key) // nolint:wrapcheck
+}
+
+// GetWithContext reads the key from the Service with the context if the Service implements ContextService,
+// otherwise the context is only checked before the call.
+func GetWithContext(ctx context.Context, service Service, key string) ([]byte, error) {
+	if contextService, ok := service.(ContextService); ok {
+		return contextService.GetWithContext(ctx, key) // nolint:wrapcheck
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return service.Get(key) // nolint:wrapcheck
+}
+
+// SetWithContext writes the key to the Service with the context if the Service implements ContextService,
+// otherwise the context is only checked before the call.
+func SetWithContext(ctx context.Context, service Service, key string, value []byte) error {
+	if contextService, ok := service.(ContextService); ok {
+		return contextService.SetWithContext(ctx, key, value) // nolint:wrapcheck
+	}
+
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return service.Set(key, value) // nolint:wrapcheck
+}
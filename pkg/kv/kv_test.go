@@ -15,6 +15,7 @@
 package kv
 
 import (
+	"context"
 	"io"
 	"testing"
 
@@ -27,3 +28,33 @@ func TestIsNotFoundError(t *testing.T) {
 
 	assert.False(t, IsNotFoundError(io.EOF))
 }
+
+type setGetService struct{}
+
+func (setGetService) Set(key string, value []byte) error { return nil }
+
+func (setGetService) Get(key string) ([]byte, error) {
+	return nil, NewNotFoundError("key '%s' is not found", key)
+}
+
+func TestListDeleteNotSupported(t *testing.T) {
+	_, err := List(setGetService{}, "")
+	assert.ErrorIs(t, err, ErrNotSupported)
+
+	err = Delete(setGetService{}, "key")
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestWithContextFallback(t *testing.T) {
+	_, err := GetWithContext(context.Background(), setGetService{}, "key")
+	assert.True(t, IsNotFoundError(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = GetWithContext(ctx, setGetService{}, "key")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	err = SetWithContext(ctx, setGetService{}, "key", nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,250 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localcrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"emperror.dev/errors"
+	"filippo.io/age"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// DataKeyName is the key under which the encrypted data key is kept in the underlying store
+const DataKeyName = "localcrypt-data-key"
+
+const dataKeySize = 32
+
+type localCrypt struct {
+	store     kv.Service
+	identity  age.Identity
+	recipient age.Recipient
+
+	mu      sync.Mutex
+	dataKey cipher.AEAD
+}
+
+var (
+	_ kv.Service        = &localCrypt{}
+	_ kv.Lister         = &localCrypt{}
+	_ kv.Deleter        = &localCrypt{}
+	_ kv.ContextService = &localCrypt{}
+)
+
+// New creates a new kv.Service which encrypts values with AES-GCM using a data key.
+// The data key is generated on the first Set, it is stored in the underlying store
+// encrypted to the age recipient, and decrypted with the age identity.
+func New(store kv.Service, identity age.Identity, recipient age.Recipient) (kv.Service, error) {
+	if identity == nil || recipient == nil {
+		return nil, errors.New("both an age identity and recipient are required")
+	}
+
+	return &localCrypt{
+		store:     store,
+		identity:  identity,
+		recipient: recipient,
+	}, nil
+}
+
+// NewWithPassphrase creates a new kv.Service encrypted by a data key which is protected by the passphrase
+func NewWithPassphrase(store kv.Service, passphrase string) (kv.Service, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase can't be empty")
+	}
+
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create scrypt recipient")
+	}
+
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create scrypt identity")
+	}
+
+	return New(store, identity, recipient)
+}
+
+// NewWithKeyFile creates a new kv.Service encrypted by a data key which is protected by
+// the first age X25519 identity (as generated by age-keygen) found in keyFile
+func NewWithKeyFile(store kv.Service, keyFile string) (kv.Service, error) {
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open key file: %s", keyFile)
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse key file: %s", keyFile)
+	}
+
+	for _, identity := range identities {
+		if identity, ok := identity.(*age.X25519Identity); ok {
+			return New(store, identity, identity.Recipient())
+		}
+	}
+
+	return nil, errors.Errorf("no X25519 identity found in key file: %s", keyFile)
+}
+
+// aead returns the cipher of the data key, the data key is generated and stored if it doesn't exist yet and create is set
+func (l *localCrypt) aead(ctx context.Context, create bool) (cipher.AEAD, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.dataKey != nil {
+		return l.dataKey, nil
+	}
+
+	var dataKey []byte
+
+	encryptedDataKey, err := kv.GetWithContext(ctx, l.store, DataKeyName)
+	switch {
+	case err == nil:
+		r, err := age.Decrypt(bytes.NewReader(encryptedDataKey), l.identity)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decrypt data key")
+		}
+
+		dataKey, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decrypt data key")
+		}
+
+	case kv.IsNotFoundError(err) && create:
+		dataKey = make([]byte, dataKeySize)
+		if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+			return nil, errors.Wrap(err, "failed to generate data key")
+		}
+
+		var buf bytes.Buffer
+		w, err := age.Encrypt(&buf, l.recipient)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encrypt data key")
+		}
+		if _, err := w.Write(dataKey); err != nil {
+			return nil, errors.Wrap(err, "failed to encrypt data key")
+		}
+		if err := w.Close(); err != nil {
+			return nil, errors.Wrap(err, "failed to encrypt data key")
+		}
+
+		if err := kv.SetWithContext(ctx, l.store, DataKeyName, buf.Bytes()); err != nil {
+			return nil, errors.Wrap(err, "failed to store data key")
+		}
+
+	default:
+		return nil, errors.Wrap(err, "failed to get data key")
+	}
+
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher from data key")
+	}
+
+	l.dataKey, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher from data key")
+	}
+
+	return l.dataKey, nil
+}
+
+func (l *localCrypt) Get(key string) ([]byte, error) {
+	return l.GetWithContext(context.Background(), key)
+}
+
+func (l *localCrypt) GetWithContext(ctx context.Context, key string) ([]byte, error) {
+	cipherText, err := kv.GetWithContext(ctx, l.store, key)
+	if err != nil {
+		return nil, errors.WrapIf(err, "failed to get data for local encryption")
+	}
+
+	aead, err := l.aead(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cipherText) < aead.NonceSize() {
+		return nil, errors.Errorf("encrypted value of key '%s' is too short", key)
+	}
+
+	nonce, cipherText := cipherText[:aead.NonceSize()], cipherText[aead.NonceSize():]
+
+	// The key is authenticated as well, so values can't be swapped in the store
+	plainText, err := aead.Open(nil, nonce, cipherText, []byte(key))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt value of key '%s'", key)
+	}
+
+	return plainText, nil
+}
+
+func (l *localCrypt) Set(key string, val []byte) error {
+	return l.SetWithContext(context.Background(), key, val)
+}
+
+func (l *localCrypt) SetWithContext(ctx context.Context, key string, val []byte) error {
+	if key == DataKeyName {
+		return errors.Errorf("key '%s' is reserved for the data key", key)
+	}
+
+	aead, err := l.aead(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return errors.Wrap(err, "failed to generate nonce")
+	}
+
+	return kv.SetWithContext(ctx, l.store, key, aead.Seal(nonce, nonce, val, []byte(key)))
+}
+
+// List lists the keys of the underlying store, except the data key.
+func (l *localCrypt) List(prefix string) ([]string, error) {
+	keys, err := kv.List(l.store, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := keys[:0]
+	for _, key := range keys {
+		if key != DataKeyName {
+			filtered = append(filtered, key)
+		}
+	}
+
+	return filtered, nil
+}
+
+func (l *localCrypt) Delete(key string) error {
+	if key == DataKeyName {
+		return errors.Errorf("key '%s' is reserved for the data key", key)
+	}
+
+	return kv.Delete(l.store, key)
+}
@@ -0,0 +1,94 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localcrypt
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+)
+
+func TestPassphrase(t *testing.T) {
+	store, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	service, err := NewWithPassphrase(store, "correct horse battery staple")
+	assert.NoError(t, err)
+
+	_, err = service.Get("vault-root")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	assert.NoError(t, service.Set("vault-root", []byte("root-token")))
+
+	cipherText, err := store.Get("vault-root")
+	assert.NoError(t, err)
+	assert.NotContains(t, string(cipherText), "root-token")
+
+	// A new instance has to decrypt the stored data key
+	service, err = NewWithPassphrase(store, "correct horse battery staple")
+	assert.NoError(t, err)
+
+	value, err := service.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("root-token"), value)
+
+	service, err = NewWithPassphrase(store, "wrong")
+	assert.NoError(t, err)
+
+	_, err = service.Get("vault-root")
+	assert.Error(t, err)
+}
+
+func TestKeyFile(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+
+	keyFile := filepath.Join(t.TempDir(), "key.txt")
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte("# created: now\n"+identity.String()+"\n"), 0600))
+
+	store, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	service, err := NewWithKeyFile(store, keyFile)
+	assert.NoError(t, err)
+
+	for _, key := range []string{"vault-unseal-0", "vault-unseal-1"} {
+		assert.NoError(t, service.Set(key, []byte(key)))
+	}
+
+	keys, err := kv.List(service, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-unseal-0", "vault-unseal-1"}, keys)
+
+	// Values are bound to their keys
+	cipherText, err := store.Get("vault-unseal-0")
+	assert.NoError(t, err)
+	assert.NoError(t, store.Set("vault-unseal-1", cipherText))
+
+	_, err = service.Get("vault-unseal-1")
+	assert.Error(t, err)
+
+	assert.Error(t, service.Set(DataKeyName, nil))
+	assert.Error(t, kv.Delete(service, DataKeyName))
+
+	_, err = NewWithKeyFile(store, filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
@@ -15,44 +15,160 @@
 package multi
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
 	"emperror.dev/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
 )
 
+var (
+	divergentReads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "vault",
+		Subsystem: "kv_multi",
+		Name:      "divergent_reads_total",
+		Help:      "Number of quorum reads where some key/value Services held a stale or missing value.",
+	})
+	repairs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "vault",
+		Subsystem: "kv_multi",
+		Name:      "repairs_total",
+		Help:      "Number of stale or missing values written back to key/value Services.",
+	})
+	backendErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vault",
+		Subsystem: "kv_multi",
+		Name:      "backend_errors_total",
+		Help:      "Number of failed operations by key/value Service index.",
+	}, []string{"operation", "backend"})
+)
+
+// RegisterMetrics registers the divergence and repair metrics of all multi key/value Services.
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{divergentReads, repairs, backendErrors} {
+		if err := registerer.Register(collector); err != nil {
+			return err // nolint:wrapcheck
+		}
+	}
+
+	return nil
+}
+
+// Config holds the consistency settings of a multi key/value Service
+type Config struct {
+	// Quorum makes Get read all key/value Services and return the value held by the majority of them,
+	// instead of the value of the first one responding.
+	Quorum bool
+	// Repair writes the majority value back to the key/value Services with a stale or missing value
+	// during quorum reads, a value held by less than the majority is never written back.
+	Repair bool
+}
+
 type multi struct {
 	services []kv.Service
+	config   Config
+}
+
+var (
+	_ kv.Lister         = &multi{}
+	_ kv.Deleter        = &multi{}
+	_ kv.ContextService = &multi{}
+	_ Repairer          = &multi{}
+)
+
+// PartialFailureError is returned when an operation failed on some of the key/value Services.
+type PartialFailureError struct {
+	Operation string
+	Key       string
+	// Errors holds the errors by the index of the failed key/value Service
+	Errors map[int]error
+	Total  int
+}
+
+func (e *PartialFailureError) Error() string {
+	indexes := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	messages := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		messages = append(messages, fmt.Sprintf("#%d: %s", i, e.Errors[i]))
+	}
+
+	return fmt.Sprintf("%s key %q failed in %d of %d key/value Services: %s",
+		e.Operation, e.Key, len(e.Errors), e.Total, strings.Join(messages, "; "))
 }
 
 // New creates a new kv.Service backed by multiple kv.Services in a multi-write and single-read fashion.
 func New(services []kv.Service) kv.Service {
-	return &multi{services: services}
+	return NewWithConfig(services, Config{})
+}
+
+// NewWithConfig creates a new kv.Service backed by multiple kv.Services in a multi-write fashion,
+// reading either the first available or the majority value depending on the config.
+func NewWithConfig(services []kv.Service, config Config) kv.Service {
+	return &multi{services: services, config: config}
 }
 
+// Set writes the value to all key/value Services, even if some of them fail,
+// in which case a *PartialFailureError is returned.
 func (f *multi) Set(key string, val []byte) error {
+	return f.SetWithContext(context.Background(), key, val)
+}
+
+func (f *multi) SetWithContext(ctx context.Context, key string, val []byte) error {
 	logrus.Infof("setting key %q in all %d key/value Services", key, len(f.services))
-	for _, service := range f.services {
-		err := service.Set(key, val)
-		if err != nil {
-			return err // nolint:wrapcheck
+
+	return f.forAll("set", key, func(service kv.Service) error {
+		return kv.SetWithContext(ctx, service, key, val)
+	})
+}
+
+func (f *multi) forAll(operation, key string, fn func(kv.Service) error) error {
+	failures := map[int]error{}
+	for i, service := range f.services {
+		if err := fn(service); err != nil {
+			logrus.Warnf("error in %s of key %q in key/value Service #%d: %s", operation, key, i, err)
+			backendErrors.WithLabelValues(operation, strconv.Itoa(i)).Inc()
+			failures[i] = err
 		}
 	}
 
+	if len(failures) > 0 {
+		return &PartialFailureError{Operation: operation, Key: key, Errors: failures, Total: len(f.services)}
+	}
+
 	return nil
 }
 
 func (f *multi) Get(key string) ([]byte, error) {
+	return f.GetWithContext(context.Background(), key)
+}
+
+func (f *multi) GetWithContext(ctx context.Context, key string) ([]byte, error) {
+	if f.config.Quorum {
+		return f.quorumGet(ctx, key, f.config.Repair)
+	}
+
 	multiErr := errors.NewPlain("Can't find key in any of the backends")
 
-	for _, service := range f.services {
-		val, err := service.Get(key)
+	for i, service := range f.services {
+		val, err := kv.GetWithContext(ctx, service, key)
 		if err != nil {
 			// Not found error means that they given object is not present, that is a hard error.
 			if kv.IsNotFoundError(err) {
 				return nil, err // nolint:wrapcheck
 			}
 			logrus.Infof("error finding key %q in key/value Service, trying next one: %s", key, err)
+			backendErrors.WithLabelValues("get", strconv.Itoa(i)).Inc()
 			multiErr = errors.Append(multiErr, err)
 		} else {
 			return val, nil
@@ -61,3 +177,155 @@ func (f *multi) Get(key string) ([]byte, error) {
 
 	return nil, multiErr // nolint:wrapcheck
 }
+
+// quorumGet reads the key from all key/value Services and returns the value held by the majority of them,
+// the key is not found if the majority of them doesn't have it. Only a majority value is written back.
+func (f *multi) quorumGet(ctx context.Context, key string, repair bool) ([]byte, error) {
+	values := make([][]byte, len(f.services))
+	missing := make([]bool, len(f.services))
+	counts := map[string]int{}
+	notFound := 0
+
+	var errs error
+	for i, service := range f.services {
+		val, err := kv.GetWithContext(ctx, service, key)
+		switch {
+		case err == nil:
+			values[i] = val
+			counts[string(val)]++
+		case kv.IsNotFoundError(err):
+			missing[i] = true
+			notFound++
+		default:
+			logrus.Infof("error getting key %q from key/value Service #%d: %s", key, i, err)
+			backendErrors.WithLabelValues("get", strconv.Itoa(i)).Inc()
+			errs = errors.Append(errs, err)
+		}
+	}
+
+	quorum := len(f.services)/2 + 1
+
+	var majority string
+	found := false
+	for val, count := range counts {
+		if count >= quorum {
+			majority, found = val, true
+		}
+	}
+
+	if !found {
+		if notFound >= quorum {
+			return nil, kv.NewNotFoundError("key %q is not present in %d of the %d key/value Services", key, notFound, len(f.services))
+		}
+
+		return nil, errors.Append(
+			errors.Errorf("key %q doesn't have the same value in a majority of the %d key/value Services", key, len(f.services)),
+			errs,
+		)
+	}
+
+	var stale []int
+	for i := range f.services {
+		if missing[i] || (values[i] != nil && string(values[i]) != majority) {
+			stale = append(stale, i)
+		}
+	}
+
+	if len(stale) > 0 {
+		divergentReads.Inc()
+		logrus.Warnf("key %q is stale or missing in key/value Services %v", key, stale)
+
+		if repair {
+			for _, i := range stale {
+				if err := kv.SetWithContext(ctx, f.services[i], key, []byte(majority)); err != nil {
+					logrus.Warnf("error repairing key %q in key/value Service #%d: %s", key, i, err)
+					backendErrors.WithLabelValues("repair", strconv.Itoa(i)).Inc()
+					continue
+				}
+
+				repairs.Inc()
+				logrus.Infof("repaired key %q in key/value Service #%d", key, i)
+			}
+		}
+	}
+
+	return []byte(majority), nil
+}
+
+// Repairer is an optional extension of Service for the multi key/value Services,
+// and the Services wrapping them.
+type Repairer interface {
+	// Repair writes the majority value of every key starting with prefix back to the
+	// key/value Services with a stale or missing value.
+	Repair(ctx context.Context, prefix string) error
+}
+
+// Repair repairs the keys of the Service starting with prefix,
+// or returns ErrNotSupported if the Service doesn't implement Repairer.
+func Repair(ctx context.Context, service kv.Service, prefix string) error {
+	repairer, ok := service.(Repairer)
+	if !ok {
+		return errors.WithStack(kv.ErrNotSupported)
+	}
+
+	return repairer.Repair(ctx, prefix) // nolint:wrapcheck
+}
+
+// Repair reads every key starting with prefix from all key/value Services, and writes the majority value
+// back to the ones with a stale or missing value. The keys without a majority value are reported in the returned error.
+func (f *multi) Repair(ctx context.Context, prefix string) error {
+	keys, err := f.List(prefix)
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, key := range keys {
+		if _, err := f.quorumGet(ctx, key, true); err != nil {
+			errs = errors.Append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// List returns the union of the keys in all key/value Services.
+func (f *multi) List(prefix string) ([]string, error) {
+	keySet := map[string]bool{}
+	for _, service := range f.services {
+		keys, err := kv.List(service, prefix)
+		if err != nil {
+			return nil, err // nolint:wrapcheck
+		}
+
+		for _, key := range keys {
+			keySet[key] = true
+		}
+	}
+
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Delete removes the key from all key/value Services, even if some of them fail,
+// in which case a *PartialFailureError is returned.
+func (f *multi) Delete(key string) error {
+	// Don't delete anything unless it can be deleted everywhere
+	for _, service := range f.services {
+		if _, ok := service.(kv.Deleter); !ok {
+			return errors.WithStack(kv.ErrNotSupported)
+		}
+	}
+
+	logrus.Infof("deleting key %q from all %d key/value Services", key, len(f.services))
+
+	return f.forAll("delete", key, func(service kv.Service) error {
+		return kv.Delete(service, key)
+	})
+}
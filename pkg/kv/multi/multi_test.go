@@ -0,0 +1,175 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multi
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"emperror.dev/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+type inMemoryStorage struct {
+	data map[string][]byte
+}
+
+func (s *inMemoryStorage) Get(key string) ([]byte, error) {
+	data, ok := s.data[key]
+	if !ok {
+		return nil, kv.NewNotFoundError("key not found")
+	}
+
+	return data, nil
+}
+
+func (s *inMemoryStorage) Set(key string, data []byte) error {
+	s.data[key] = data
+	return nil
+}
+
+func (s *inMemoryStorage) List(prefix string) ([]string, error) {
+	var keys []string
+	for key := range s.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *inMemoryStorage) Delete(key string) error {
+	delete(s.data, key)
+	return nil
+}
+
+func TestListDelete(t *testing.T) {
+	first := &inMemoryStorage{map[string][]byte{"vault-unseal-0": nil, "vault-root": nil}}
+	second := &inMemoryStorage{map[string][]byte{"vault-unseal-0": nil, "vault-unseal-1": nil}}
+
+	service := New([]kv.Service{first, second})
+
+	keys, err := kv.List(service, "vault-unseal-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-unseal-0", "vault-unseal-1"}, keys)
+
+	assert.NoError(t, kv.Delete(service, "vault-unseal-0"))
+	assert.NotContains(t, first.data, "vault-unseal-0")
+	assert.NotContains(t, second.data, "vault-unseal-0")
+	assert.Contains(t, first.data, "vault-root")
+}
+
+func TestListNotSupported(t *testing.T) {
+	service := New([]kv.Service{&inMemoryStorage{map[string][]byte{}}, struct{ kv.Service }{}})
+
+	_, err := kv.List(service, "")
+	assert.ErrorIs(t, err, kv.ErrNotSupported)
+}
+
+type failingStorage struct {
+	kv.Service
+}
+
+func (failingStorage) Get(key string) ([]byte, error) {
+	return nil, errors.New("backend unavailable")
+}
+
+func (failingStorage) Set(key string, data []byte) error {
+	return errors.New("backend unavailable")
+}
+
+func TestSetPartialFailure(t *testing.T) {
+	first := &inMemoryStorage{map[string][]byte{}}
+	last := &inMemoryStorage{map[string][]byte{}}
+
+	service := New([]kv.Service{first, failingStorage{}, last})
+
+	err := service.Set("vault-root", []byte("token"))
+
+	var partialErr *PartialFailureError
+	assert.True(t, errors.As(err, &partialErr))
+	assert.Equal(t, 3, partialErr.Total)
+	assert.Contains(t, partialErr.Errors, 1)
+	assert.Len(t, partialErr.Errors, 1)
+
+	// The backends after the failing one are written as well
+	assert.Equal(t, []byte("token"), last.data["vault-root"])
+}
+
+func TestQuorumGet(t *testing.T) {
+	first := &inMemoryStorage{map[string][]byte{"vault-root": []byte("token"), "vault-unseal-0": []byte("a"), "vault-unseal-1": []byte("c")}}
+	second := &inMemoryStorage{map[string][]byte{"vault-root": []byte("stale"), "vault-unseal-0": []byte("b")}}
+	third := &inMemoryStorage{map[string][]byte{"vault-root": []byte("token")}}
+
+	service := NewWithConfig([]kv.Service{first, second, third}, Config{Quorum: true})
+
+	value, err := service.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("token"), value)
+	assert.Equal(t, []byte("stale"), second.data["vault-root"], "repair is disabled")
+
+	_, err = service.Get("vault-unseal-0")
+	assert.Error(t, err, "no majority")
+	assert.False(t, kv.IsNotFoundError(err))
+
+	_, err = service.Get("vault-unseal-2")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	service = NewWithConfig([]kv.Service{first, second, third}, Config{Quorum: true, Repair: true})
+
+	value, err = service.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("token"), value)
+	assert.Equal(t, []byte("token"), second.data["vault-root"])
+
+	// A value held by a single replica is not the majority, so it isn't written back
+	_, err = service.Get("vault-unseal-1")
+	assert.True(t, kv.IsNotFoundError(err))
+	assert.NotContains(t, second.data, "vault-unseal-1")
+	assert.NotContains(t, third.data, "vault-unseal-1")
+}
+
+func TestRepair(t *testing.T) {
+	first := &inMemoryStorage{map[string][]byte{"vault-unseal-0": []byte("a"), "vault-unseal-1": []byte("b")}}
+	second := &inMemoryStorage{map[string][]byte{"vault-unseal-1": []byte("b")}}
+	third := &inMemoryStorage{map[string][]byte{}}
+
+	service := New([]kv.Service{first, second, third})
+
+	err := Repair(context.Background(), service, "vault-unseal-")
+	assert.Error(t, err, "vault-unseal-0 has no majority")
+	assert.Equal(t, []byte("b"), third.data["vault-unseal-1"])
+	assert.NotContains(t, second.data, "vault-unseal-0")
+	assert.NotContains(t, third.data, "vault-unseal-0")
+
+	assert.NoError(t, second.Set("vault-unseal-0", []byte("a")))
+	assert.NoError(t, Repair(context.Background(), service, "vault-unseal-"))
+	assert.Equal(t, first.data, third.data)
+
+	assert.ErrorIs(t, Repair(context.Background(), first, ""), kv.ErrNotSupported)
+}
+
+func TestDeleteNotSupported(t *testing.T) {
+	first := &inMemoryStorage{map[string][]byte{"vault-root": nil}}
+	service := New([]kv.Service{first, struct{ kv.Service }{}})
+
+	assert.ErrorIs(t, kv.Delete(service, "vault-root"), kv.ErrNotSupported)
+	assert.Contains(t, first.data, "vault-root")
+}
@@ -16,27 +16,37 @@ package s3
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strings"
 
 	"emperror.dev/errors"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/awskms"
 )
 
 type s3Storage struct {
-	client   *awss3.S3
+	client   s3iface.S3API
 	bucket   string
 	prefix   string
 	sseAlgo  string
 	sseKeyID string
 }
 
+var (
+	_ kv.Lister         = &s3Storage{}
+	_ kv.Deleter        = &s3Storage{}
+	_ kv.ContextService = &s3Storage{}
+)
+
 // New creates a new kv.Service backed by AWS S3
 func New(region, bucket, prefix, sseAlgo, sseKeyID string) (kv.Service, error) {
 	if region == "" {
@@ -63,6 +73,10 @@ func New(region, bucket, prefix, sseAlgo, sseKeyID string) (kv.Service, error) {
 }
 
 func (s3 *s3Storage) Set(key string, val []byte) error {
+	return s3.SetWithContext(context.Background(), key, val)
+}
+
+func (s3 *s3Storage) SetWithContext(ctx context.Context, key string, val []byte) error {
 	n := objectNameWithPrefix(s3.prefix, key)
 	input := awss3.PutObjectInput{
 		Bucket: aws.String(s3.bucket),
@@ -76,7 +90,7 @@ func (s3 *s3Storage) Set(key string, val []byte) error {
 		}
 	}
 
-	if _, err := s3.client.PutObject(&input); err != nil {
+	if _, err := s3.client.PutObjectWithContext(ctx, &input); err != nil {
 		return errors.Wrapf(err, "error writing key '%s' to s3 bucket '%s'", n, s3.bucket)
 	}
 
@@ -84,6 +98,10 @@ func (s3 *s3Storage) Set(key string, val []byte) error {
 }
 
 func (s3 *s3Storage) Get(key string) ([]byte, error) {
+	return s3.GetWithContext(context.Background(), key)
+}
+
+func (s3 *s3Storage) GetWithContext(ctx context.Context, key string) ([]byte, error) {
 	n := objectNameWithPrefix(s3.prefix, key)
 
 	input := awss3.GetObjectInput{
@@ -91,7 +109,7 @@ func (s3 *s3Storage) Get(key string) ([]byte, error) {
 		Key:    aws.String(n),
 	}
 
-	r, err := s3.client.GetObject(&input)
+	r, err := s3.client.GetObjectWithContext(ctx, &input)
 	if err != nil {
 		var aerr awserr.Error
 		if errors.As(err, &aerr) && aerr.Code() == awss3.ErrCodeNoSuchKey {
@@ -111,6 +129,44 @@ func (s3 *s3Storage) Get(key string) ([]byte, error) {
 	return b, nil
 }
 
+func (s3 *s3Storage) List(prefix string) ([]string, error) {
+	input := awss3.ListObjectsV2Input{
+		Bucket: aws.String(s3.bucket),
+		Prefix: aws.String(objectNameWithPrefix(s3.prefix, prefix)),
+	}
+
+	var keys []string
+	err := s3.client.ListObjectsV2Pages(&input, func(page *awss3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.StringValue(object.Key), s3.prefix))
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing objects with prefix '%s' in s3 bucket '%s'", aws.StringValue(input.Prefix), s3.bucket)
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (s3 *s3Storage) Delete(key string) error {
+	n := objectNameWithPrefix(s3.prefix, key)
+
+	input := awss3.DeleteObjectInput{
+		Bucket: aws.String(s3.bucket),
+		Key:    aws.String(n),
+	}
+
+	if _, err := s3.client.DeleteObject(&input); err != nil {
+		return errors.Wrapf(err, "error deleting key '%s' from s3 bucket '%s'", n, s3.bucket)
+	}
+
+	return nil
+}
+
 func objectNameWithPrefix(prefix, key string) string {
 	return fmt.Sprintf("%s%s", prefix, key)
 }
@@ -0,0 +1,100 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// fakeS3 is an in-memory S3 bucket implementing the calls used by s3Storage
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func (f *fakeS3) PutObjectWithContext(_ aws.Context, input *awss3.PutObjectInput, _ ...request.Option) (*awss3.PutObjectOutput, error) {
+	data, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.objects[aws.StringValue(input.Key)] = data
+
+	return &awss3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObjectWithContext(_ aws.Context, input *awss3.GetObjectInput, _ ...request.Option) (*awss3.GetObjectOutput, error) {
+	data, ok := f.objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(awss3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+
+	return &awss3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (f *fakeS3) ListObjectsV2Pages(input *awss3.ListObjectsV2Input, fn func(*awss3.ListObjectsV2Output, bool) bool) error {
+	// Return every object on a separate page to exercise pagination
+	for key := range f.objects {
+		if strings.HasPrefix(key, aws.StringValue(input.Prefix)) {
+			page := &awss3.ListObjectsV2Output{Contents: []*awss3.Object{{Key: aws.String(key)}}}
+			if !fn(page, false) {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeS3) DeleteObject(input *awss3.DeleteObjectInput) (*awss3.DeleteObjectOutput, error) {
+	delete(f.objects, aws.StringValue(input.Key))
+
+	return &awss3.DeleteObjectOutput{}, nil
+}
+
+func TestListDelete(t *testing.T) {
+	client := &fakeS3{objects: map[string][]byte{"other/vault-unseal-0": nil}}
+	service := &s3Storage{client: client, bucket: "bucket", prefix: "cluster/"}
+
+	for _, key := range []string{"vault-unseal-1", "vault-root", "vault-unseal-0"} {
+		assert.NoError(t, service.Set(key, []byte(key)))
+	}
+
+	keys, err := kv.List(service, "vault-unseal-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-unseal-0", "vault-unseal-1"}, keys)
+
+	assert.NoError(t, kv.Delete(service, "vault-unseal-1"))
+	assert.NotContains(t, client.objects, "cluster/vault-unseal-1")
+
+	_, err = service.Get("vault-unseal-1")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	keys, err = kv.List(service, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-root", "vault-unseal-0"}, keys)
+}
@@ -0,0 +1,231 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shamir
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"sort"
+	"sync"
+
+	"emperror.dev/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+type shamir struct {
+	services  []kv.Service
+	threshold int
+}
+
+var (
+	_ kv.Service        = &shamir{}
+	_ kv.Lister         = &shamir{}
+	_ kv.Deleter        = &shamir{}
+	_ kv.ContextService = &shamir{}
+)
+
+// New creates a new kv.Service which splits every value with Shamir's secret sharing,
+// one share stored in each of the kv.Services, any threshold of them can reconstruct the value.
+func New(services []kv.Service, threshold int) (kv.Service, error) {
+	if threshold < 2 || threshold > len(services) || len(services) > 255 {
+		return nil, errors.Errorf("the threshold must be at least 2 and at most the number of key/value Services [%d], got %d", len(services), threshold)
+	}
+
+	return &shamir{services: services, threshold: threshold}, nil
+}
+
+// Set stores a share of the value in every key/value Service, every Service has to succeed.
+func (s *shamir) Set(key string, val []byte) error {
+	return s.SetWithContext(context.Background(), key, val)
+}
+
+func (s *shamir) SetWithContext(ctx context.Context, key string, val []byte) error {
+	// A checksum is split together with the value, to detect shares of different values
+	checksum := sha256.Sum256(val)
+	shares, err := split(append(append([]byte{}, val...), checksum[:]...), len(s.services), s.threshold)
+	if err != nil {
+		return errors.Wrapf(err, "failed to split key %q", key)
+	}
+
+	logrus.Infof("setting shares of key %q in all %d key/value Services", key, len(s.services))
+
+	var errs error
+	for i, service := range s.services {
+		if err := kv.SetWithContext(ctx, service, key, shares[i]); err != nil {
+			errs = errors.Append(errs, errors.Wrapf(err, "failed to set share of key %q in key/value Service #%d", key, i))
+		}
+	}
+
+	return errs
+}
+
+// Get reads the shares of the value from all the key/value Services in parallel,
+// and reconstructs the value if at least threshold of them respond with shares of the same value.
+func (s *shamir) Get(key string) ([]byte, error) {
+	return s.GetWithContext(context.Background(), key)
+}
+
+func (s *shamir) GetWithContext(ctx context.Context, key string) ([]byte, error) {
+	type result struct {
+		share []byte
+		err   error
+	}
+
+	results := make([]result, len(s.services))
+
+	var wg sync.WaitGroup
+	for i, service := range s.services {
+		wg.Add(1)
+		go func(i int, service kv.Service) {
+			defer wg.Done()
+			share, err := kv.GetWithContext(ctx, service, key)
+			results[i] = result{share: share, err: err}
+		}(i, service)
+	}
+	wg.Wait()
+
+	var shares [][]byte
+	var errs error
+	notFound := 0
+	for i, result := range results {
+		switch {
+		case result.err == nil:
+			shares = append(shares, result.share)
+		case kv.IsNotFoundError(result.err):
+			notFound++
+		default:
+			logrus.Infof("error getting share of key %q from key/value Service #%d: %s", key, i, result.err)
+			errs = errors.Append(errs, result.err)
+		}
+	}
+
+	if len(shares) < s.threshold {
+		if notFound > len(s.services)-s.threshold {
+			return nil, kv.NewNotFoundError("key %q has only %d shares, %d are required", key, len(s.services)-notFound, s.threshold)
+		}
+
+		return nil, errors.Wrapf(errs, "only %d shares of key %q are available, %d are required", len(shares), key, s.threshold)
+	}
+
+	// A stale or corrupt share fails the checksum, so every threshold sized
+	// combination of the shares is tried until one of them reconstructs the value
+	var val []byte
+	var err error
+	combinations(len(shares), s.threshold, func(indexes []int) bool {
+		selected := make([][]byte, 0, len(indexes))
+		for _, i := range indexes {
+			selected = append(selected, shares[i])
+		}
+
+		val, err = combine(selected)
+
+		return err == nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to reconstruct key %q from %d shares", key, len(shares))
+	}
+
+	return val, nil
+}
+
+// combine reconstructs the value from the shares and verifies its checksum
+func combine(shares [][]byte) ([]byte, error) {
+	secret, err := combineShares(shares)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to combine shares")
+	}
+
+	if len(secret) < sha256.Size {
+		return nil, errors.New("shares are too short")
+	}
+
+	val, checksum := secret[:len(secret)-sha256.Size], secret[len(secret)-sha256.Size:]
+	if expected := sha256.Sum256(val); !bytes.Equal(checksum, expected[:]) {
+		return nil, errors.New("shares don't belong to the same value")
+	}
+
+	return val, nil
+}
+
+// combinations calls fn with the indexes of every k sized subset of n elements,
+// in lexicographic order, until fn returns true.
+func combinations(n, k int, fn func(indexes []int) bool) {
+	indexes := make([]int, k)
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	for {
+		if fn(indexes) {
+			return
+		}
+
+		i := k - 1
+		for i >= 0 && indexes[i] == n-k+i {
+			i--
+		}
+		if i < 0 {
+			return
+		}
+
+		indexes[i]++
+		for j := i + 1; j < k; j++ {
+			indexes[j] = indexes[j-1] + 1
+		}
+	}
+}
+
+// List returns the keys which have at least threshold shares in the key/value Services.
+func (s *shamir) List(prefix string) ([]string, error) {
+	counts := map[string]int{}
+	for _, service := range s.services {
+		keys, err := kv.List(service, prefix)
+		if err != nil {
+			return nil, err // nolint:wrapcheck
+		}
+
+		for _, key := range keys {
+			counts[key]++
+		}
+	}
+
+	var keys []string
+	for key, count := range counts {
+		if count >= s.threshold {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Delete removes the shares of the key from all key/value Services.
+func (s *shamir) Delete(key string) error {
+	logrus.Infof("deleting shares of key %q from all %d key/value Services", key, len(s.services))
+
+	var errs error
+	for i, service := range s.services {
+		if err := kv.Delete(service, key); err != nil {
+			errs = errors.Append(errs, errors.Wrapf(err, "failed to delete share of key %q from key/value Service #%d", key, i))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,144 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shamir
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("vault unseal key")
+
+	shares, err := split(secret, 5, 3)
+	assert.NoError(t, err)
+	assert.Len(t, shares, 5)
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var selected [][]byte
+		for _, i := range subset {
+			selected = append(selected, shares[i])
+		}
+
+		combined, err := combineShares(selected)
+		assert.NoError(t, err)
+		assert.Equal(t, secret, combined)
+	}
+
+	combined, err := combineShares(shares[:2])
+	assert.NoError(t, err)
+	assert.NotEqual(t, secret, combined)
+
+	_, err = combineShares([][]byte{shares[0], shares[0]})
+	assert.Error(t, err)
+
+	_, err = split(secret, 3, 1)
+	assert.Error(t, err)
+
+	// Shares of the same secret split by Vault's shamir package
+	var vaultShares [][]byte
+	for _, share := range []string{"2b3b8af28231fc9f6c7117480e6977631f", "24e09c6bf351423abff8bfcf4054b959cc"} {
+		decoded, err := hex.DecodeString(share)
+		assert.NoError(t, err)
+		vaultShares = append(vaultShares, decoded)
+	}
+
+	combined, err = combineShares(vaultShares)
+	assert.NoError(t, err)
+	assert.Equal(t, secret, combined)
+}
+
+func TestCombinations(t *testing.T) {
+	var subsets [][]int
+	combinations(4, 2, func(indexes []int) bool {
+		subsets = append(subsets, append([]int{}, indexes...))
+
+		return false
+	})
+	assert.Equal(t, [][]int{{0, 1}, {0, 2}, {0, 3}, {1, 2}, {1, 3}, {2, 3}}, subsets)
+
+	calls := 0
+	combinations(4, 2, func(indexes []int) bool {
+		calls++
+
+		return true
+	})
+	assert.Equal(t, 1, calls)
+}
+
+func TestShamir(t *testing.T) {
+	var services []kv.Service
+	for i := 0; i < 3; i++ {
+		service, err := file.New(t.TempDir())
+		assert.NoError(t, err)
+		services = append(services, service)
+	}
+
+	service, err := New(services, 2)
+	assert.NoError(t, err)
+
+	_, err = service.Get("vault-root")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	assert.NoError(t, service.Set("vault-root", []byte("root-token")))
+
+	for _, backend := range services {
+		share, err := backend.Get("vault-root")
+		assert.NoError(t, err)
+		assert.NotContains(t, string(share), "root-token")
+	}
+
+	// Losing one share is fine
+	assert.NoError(t, kv.Delete(services[1], "vault-root"))
+
+	value, err := service.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("root-token"), value)
+
+	keys, err := kv.List(service, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-root"}, keys)
+
+	// Shares of different values are detected
+	assert.NoError(t, service.Set("vault-unseal-0", []byte("root-tokeX")))
+	share, err := services[0].Get("vault-unseal-0")
+	assert.NoError(t, err)
+	assert.NoError(t, services[0].Set("vault-root", share))
+
+	_, err = service.Get("vault-root")
+	assert.Error(t, err)
+
+	// A wrong share is skipped when enough other shares are available
+	assert.NoError(t, service.Set("vault-root", []byte("root-token")))
+	assert.NoError(t, services[0].Set("vault-root", share))
+
+	value, err = service.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("root-token"), value)
+
+	assert.NoError(t, kv.Delete(services[0], "vault-root"))
+	assert.NoError(t, kv.Delete(services[1], "vault-root"))
+
+	_, err = service.Get("vault-root")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	_, err = New(services, 4)
+	assert.Error(t, err)
+}
@@ -0,0 +1,154 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shamir
+
+import (
+	"crypto/rand"
+
+	"emperror.dev/errors"
+)
+
+// The secret sharing below follows the shamir package of Vault (github.com/hashicorp/vault/shamir,
+// Copyright HashiCorp, Inc., licensed under MPL-2.0): every byte of the secret is the intercept of a
+// random polynomial over GF(2^8), and a share is the list of the evaluations followed by its x coordinate,
+// so the shares are compatible with the ones of Vault.
+
+// Arithmetic in GF(2^8) with the AES reducing polynomial, using log/exp tables of the generator 3.
+var (
+	expTable [255]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		logTable[x] = byte(i)
+
+		// x *= 3
+		hi := x & 0x80
+		x ^= x << 1
+		if hi != 0 {
+			x ^= 0x1b
+		}
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
+
+// split splits secret into n shares, any threshold of them can reconstruct it.
+// Every share is the evaluation of the random polynomials at a distinct x coordinate,
+// followed by the x coordinate itself.
+func split(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, errors.Errorf("invalid number of shares (%d) or threshold (%d)", n, threshold)
+	}
+
+	// Pick n distinct non-zero x coordinates with a random permutation
+	xs := make([]byte, 255)
+	for i := range xs {
+		xs[i] = byte(i + 1)
+	}
+	random := make([]byte, 255)
+	if _, err := rand.Read(random); err != nil {
+		return nil, errors.Wrap(err, "failed to read random bytes")
+	}
+	for i := len(xs) - 1; i > 0; i-- {
+		j := int(random[i]) % (i + 1)
+		xs[i], xs[j] = xs[j], xs[i]
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = xs[i]
+	}
+
+	coefficients := make([]byte, threshold)
+	for b, value := range secret {
+		coefficients[0] = value
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, errors.Wrap(err, "failed to read random bytes")
+		}
+
+		for i := range shares {
+			// Horner's method
+			x, y := xs[i], byte(0)
+			for c := threshold - 1; c >= 0; c-- {
+				y = mul(y, x) ^ coefficients[c]
+			}
+			shares[i][b] = y
+		}
+	}
+
+	return shares, nil
+}
+
+// combineShares reconstructs the secret from the shares with Lagrange interpolation at x = 0
+func combineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+
+	size := len(shares[0])
+	if size < 2 {
+		return nil, errors.New("shares are too short")
+	}
+
+	xs := make([]byte, len(shares))
+	seen := map[byte]bool{}
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, errors.New("shares must have the same length")
+		}
+
+		xs[i] = share[size-1]
+		if seen[xs[i]] {
+			return nil, errors.New("duplicate share")
+		}
+		seen[xs[i]] = true
+	}
+
+	secret := make([]byte, size-1)
+	for b := range secret {
+		var value byte
+		for i, share := range shares {
+			basis := byte(1)
+			for j := range shares {
+				if i != j {
+					basis = mul(basis, div(xs[j], xs[i]^xs[j]))
+				}
+			}
+			value ^= mul(share[b], basis)
+		}
+		secret[b] = value
+	}
+
+	return secret, nil
+}
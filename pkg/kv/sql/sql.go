@@ -0,0 +1,188 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"emperror.dev/errors"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// dialect holds the statements which differ between the supported databases
+type dialect struct {
+	blobType string
+	upsert   string
+	// placeholder returns the n-th (starting from 1) query parameter placeholder
+	placeholder func(n int) string
+}
+
+func questionMark(int) string {
+	return "?"
+}
+
+func dollar(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+var dialects = map[string]dialect{
+	"postgres": {
+		blobType:    "BYTEA",
+		upsert:      "INSERT INTO %s (name, value) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET value = excluded.value",
+		placeholder: dollar,
+	},
+	"mysql": {
+		blobType:    "LONGBLOB",
+		upsert:      "INSERT INTO %s (name, value) VALUES (?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value)",
+		placeholder: questionMark,
+	},
+	"sqlite3": {
+		blobType:    "BLOB",
+		upsert:      "INSERT INTO %s (name, value) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET value = excluded.value",
+		placeholder: questionMark,
+	},
+}
+
+func init() {
+	dialects["pgx"] = dialects["postgres"]
+	dialects["sqlite"] = dialects["sqlite3"]
+}
+
+var tableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type sqlStorage struct {
+	db      *sql.DB
+	table   string
+	dialect dialect
+}
+
+var (
+	_ kv.Service        = &sqlStorage{}
+	_ kv.Lister         = &sqlStorage{}
+	_ kv.Deleter        = &sqlStorage{}
+	_ kv.ContextService = &sqlStorage{}
+)
+
+// New creates a new kv.Service backed by a table of a SQL database, without any encryption.
+// The driver has to be registered by the caller, the supported ones are postgres (or pgx), mysql and sqlite3.
+func New(driver, dsn, table string) (kv.Service, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s database", driver)
+	}
+
+	service, err := NewWithDB(db, driver, table)
+	if err != nil {
+		db.Close()
+
+		return nil, err
+	}
+
+	return service, nil
+}
+
+// NewWithDB creates a new kv.Service backed by a table of an existing SQL database connection,
+// the table is created if it doesn't exist.
+func NewWithDB(db *sql.DB, driver, table string) (kv.Service, error) {
+	dialect, ok := dialects[driver]
+	if !ok {
+		return nil, errors.Errorf("unsupported SQL driver: '%s'", driver)
+	}
+
+	if !tableNameRegexp.MatchString(table) {
+		return nil, errors.Errorf("invalid SQL table name: '%s'", table)
+	}
+
+	_, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name VARCHAR(255) PRIMARY KEY, value %s NOT NULL)", table, dialect.blobType))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create table '%s'", table)
+	}
+
+	return &sqlStorage{db: db, table: table, dialect: dialect}, nil
+}
+
+func (s *sqlStorage) Set(key string, val []byte) error {
+	return s.SetWithContext(context.Background(), key, val)
+}
+
+func (s *sqlStorage) SetWithContext(ctx context.Context, key string, val []byte) error {
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(s.dialect.upsert, s.table), key, val); err != nil {
+		return errors.Wrapf(err, "failed to set key '%s' in table '%s'", key, s.table)
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) Get(key string) ([]byte, error) {
+	return s.GetWithContext(context.Background(), key)
+}
+
+func (s *sqlStorage) GetWithContext(ctx context.Context, key string) ([]byte, error) {
+	var val []byte
+
+	query := fmt.Sprintf("SELECT value FROM %s WHERE name = %s", s.table, s.dialect.placeholder(1))
+	err := s.db.QueryRowContext(ctx, query, key).Scan(&val)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, kv.NewNotFoundError("key '%s' is not present in table '%s'", key, s.table)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get key '%s' from table '%s'", key, s.table)
+	}
+
+	return val, nil
+}
+
+func (s *sqlStorage) List(prefix string) ([]string, error) {
+	rows, err := s.db.Query(fmt.Sprintf("SELECT name FROM %s", s.table))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list keys in table '%s'", s.table)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, errors.Wrapf(err, "failed to list keys in table '%s'", s.table)
+		}
+
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to list keys in table '%s'", s.table)
+	}
+
+	// The ordering of the database depends on its collation
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (s *sqlStorage) Delete(key string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE name = %s", s.table, s.dialect.placeholder(1))
+	if _, err := s.db.Exec(query, key); err != nil {
+		return errors.Wrapf(err, "failed to delete key '%s' from table '%s'", key, s.table)
+	}
+
+	return nil
+}
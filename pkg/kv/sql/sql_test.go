@@ -0,0 +1,68 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+func TestSQLite(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "bank-vaults.db")
+
+	service, err := New("sqlite3", dsn, "unseal_keys")
+	assert.NoError(t, err)
+
+	_, err = service.Get("vault-root")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	for _, key := range []string{"vault-unseal-1", "vault-root", "vault-unseal-0"} {
+		assert.NoError(t, service.Set(key, []byte(key)))
+	}
+
+	// Overwriting an existing key
+	assert.NoError(t, service.Set("vault-root", []byte("new-token")))
+
+	// The table already exists when opened again
+	service, err = New("sqlite3", dsn, "unseal_keys")
+	assert.NoError(t, err)
+
+	value, err := service.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new-token"), value)
+
+	keys, err := kv.List(service, "vault-unseal-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-unseal-0", "vault-unseal-1"}, keys)
+
+	assert.NoError(t, kv.Delete(service, "vault-unseal-1"))
+	assert.NoError(t, kv.Delete(service, "vault-unseal-1"))
+
+	_, err = service.Get("vault-unseal-1")
+	assert.True(t, kv.IsNotFoundError(err))
+}
+
+func TestInvalidConfig(t *testing.T) {
+	_, err := New("sqlite3", filepath.Join(t.TempDir(), "bank-vaults.db"), "unseal_keys; DROP TABLE users")
+	assert.Error(t, err)
+
+	_, err = New("oracle", "", "unseal_keys")
+	assert.Error(t, err)
+}
@@ -0,0 +1,152 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transit
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/spf13/cast"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/sdk/vault"
+)
+
+type transit struct {
+	store     kv.Service
+	client    *vault.Client
+	mountPath string
+	keyName   string
+}
+
+var (
+	_ kv.Service        = &transit{}
+	_ kv.Lister         = &transit{}
+	_ kv.Deleter        = &transit{}
+	_ kv.ContextService = &transit{}
+)
+
+// New creates a new kv.Service encrypted by the transit secrets engine of a remote Vault,
+// the client is created and authenticated with the given options.
+func New(store kv.Service, mountPath, keyName string, opts ...vault.ClientOption) (kv.Service, error) {
+	client, err := vault.NewClientWithOptions(opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create vault client")
+	}
+
+	return NewWithClient(store, client, mountPath, keyName)
+}
+
+// NewWithClient creates a new kv.Service encrypted by the transit secrets engine of a remote Vault with an existing client
+func NewWithClient(store kv.Service, client *vault.Client, mountPath, keyName string) (kv.Service, error) {
+	if keyName == "" {
+		return nil, errors.New("transit key name is required")
+	}
+
+	if mountPath == "" {
+		mountPath = "transit"
+	}
+
+	return &transit{
+		store:     store,
+		client:    client,
+		mountPath: strings.Trim(mountPath, "/"),
+		keyName:   keyName,
+	}, nil
+}
+
+func (t *transit) encrypt(plainText []byte) ([]byte, error) {
+	secret, err := t.client.RawClient().Logical().Write(
+		fmt.Sprintf("%s/encrypt/%s", t.mountPath, t.keyName),
+		map[string]interface{}{
+			"plaintext": base64.StdEncoding.EncodeToString(plainText),
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encrypt with transit key '%s'", t.keyName)
+	}
+	if secret == nil {
+		return nil, errors.Errorf("no response for encrypting with transit key '%s'", t.keyName)
+	}
+
+	cipherText, err := cast.ToStringE(secret.Data["ciphertext"])
+	if err != nil || cipherText == "" {
+		return nil, errors.Errorf("no ciphertext in the response of transit key '%s'", t.keyName)
+	}
+
+	return []byte(cipherText), nil
+}
+
+func (t *transit) decrypt(cipherText []byte) ([]byte, error) {
+	secret, err := t.client.RawClient().Logical().Write(
+		fmt.Sprintf("%s/decrypt/%s", t.mountPath, t.keyName),
+		map[string]interface{}{
+			"ciphertext": string(cipherText),
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt with transit key '%s'", t.keyName)
+	}
+	if secret == nil {
+		return nil, errors.Errorf("no response for decrypting with transit key '%s'", t.keyName)
+	}
+
+	plainText, err := cast.ToStringE(secret.Data["plaintext"])
+	if err != nil {
+		return nil, errors.Errorf("no plaintext in the response of transit key '%s'", t.keyName)
+	}
+
+	return base64.StdEncoding.DecodeString(plainText)
+}
+
+func (t *transit) Get(key string) ([]byte, error) {
+	return t.GetWithContext(context.Background(), key)
+}
+
+// GetWithContext passes the context to the underlying store, the transit requests can't be canceled.
+func (t *transit) GetWithContext(ctx context.Context, key string) ([]byte, error) {
+	cipherText, err := kv.GetWithContext(ctx, t.store, key)
+	if err != nil {
+		return nil, errors.WrapIf(err, "failed to get data for transit encryption")
+	}
+
+	return t.decrypt(cipherText)
+}
+
+func (t *transit) Set(key string, val []byte) error {
+	return t.SetWithContext(context.Background(), key, val)
+}
+
+// SetWithContext passes the context to the underlying store, the transit requests can't be canceled.
+func (t *transit) SetWithContext(ctx context.Context, key string, val []byte) error {
+	cipherText, err := t.encrypt(val)
+	if err != nil {
+		return err
+	}
+
+	return kv.SetWithContext(ctx, t.store, key, cipherText)
+}
+
+// List lists the keys of the underlying store, only the values are encrypted with transit.
+func (t *transit) List(prefix string) ([]string, error) {
+	return kv.List(t.store, prefix)
+}
+
+func (t *transit) Delete(key string) error {
+	return kv.Delete(t.store, key)
+}
@@ -0,0 +1,90 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+	"github.com/banzaicloud/bank-vaults/pkg/sdk/vault"
+)
+
+// fakeTransit "encrypts" by prefixing the base64 encoded plaintext with the key version
+func fakeTransit(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	var data map[string]string
+	switch r.URL.Path {
+	case "/v1/unseal/encrypt/bank-vaults":
+		data = map[string]string{"ciphertext": "vault:v1:" + body["plaintext"]}
+	case "/v1/unseal/decrypt/bank-vaults":
+		if !strings.HasPrefix(body["ciphertext"], "vault:v1:") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data = map[string]string{"plaintext": strings.TrimPrefix(body["ciphertext"], "vault:v1:")}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func TestTransit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(fakeTransit))
+	defer server.Close()
+
+	rawClient, err := vaultapi.NewClient(&vaultapi.Config{Address: server.URL})
+	assert.NoError(t, err)
+
+	client, err := vault.NewClientFromRawClient(rawClient, vault.ClientToken("root"))
+	assert.NoError(t, err)
+
+	store, err := file.New(t.TempDir())
+	assert.NoError(t, err)
+
+	service, err := NewWithClient(store, client, "/unseal/", "bank-vaults")
+	assert.NoError(t, err)
+
+	assert.NoError(t, service.Set("vault-root", []byte("root-token")))
+
+	cipherText, err := store.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, "vault:v1:cm9vdC10b2tlbg==", string(cipherText))
+
+	value, err := service.Get("vault-root")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("root-token"), value)
+
+	_, err = service.Get("vault-unseal-0")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	assert.NoError(t, store.Set("vault-unseal-0", []byte("plain")))
+	_, err = service.Get("vault-unseal-0")
+	assert.Error(t, err)
+
+	_, err = NewWithClient(store, client, "", "")
+	assert.Error(t, err)
+}
@@ -17,6 +17,8 @@ package vault
 import (
 	"encoding/base64"
 	"fmt"
+	"sort"
+	"strings"
 
 	"emperror.dev/errors"
 	"github.com/spf13/cast"
@@ -30,6 +32,11 @@ type vaultStorage struct {
 	path   string
 }
 
+var (
+	_ kv.Lister  = &vaultStorage{}
+	_ kv.Deleter = &vaultStorage{}
+)
+
 // New creates a new kv.Service backed by Vault KV Version 2
 func New(addr, unsealKeysPath, role, authPath, tokenPath, token string) (kv.Service, error) {
 	client, err := vault.NewClientWithOptions(
@@ -82,3 +89,51 @@ func (v *vaultStorage) Get(key string) ([]byte, error) {
 
 	return base64.StdEncoding.DecodeString(data[key].(string))
 }
+
+func (v *vaultStorage) List(prefix string) ([]string, error) {
+	secret, err := v.client.RawClient().Logical().List(metadataPath(v.path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing keys under path '%s'", v.path)
+	}
+	if secret == nil {
+		return nil, nil
+	}
+
+	allKeys, err := cast.ToStringSliceE(secret.Data["keys"])
+	if err != nil {
+		return nil, errors.Wrapf(err, "error finding keys under path '%s'", v.path)
+	}
+
+	var keys []string
+	for _, key := range allKeys {
+		// Skip the sub-folders
+		if strings.HasPrefix(key, prefix) && !strings.HasSuffix(key, "/") {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Delete removes all versions of the key (including the metadata in case of KV version 2).
+func (v *vaultStorage) Delete(key string) error {
+	path := fmt.Sprintf("%s/%s", metadataPath(v.path), key)
+	if _, err := v.client.RawClient().Logical().Delete(path); err != nil {
+		return errors.Wrapf(err, "error deleting key '%s' from vault addr %s and path '%s'", key, v.client.RawClient().Address(), v.path)
+	}
+
+	return nil
+}
+
+// metadataPath returns the KV version 2 metadata path belonging to a data path
+// (secret/data/unseal-keys => secret/metadata/unseal-keys), other paths are returned unmodified.
+func metadataPath(path string) string {
+	parts := strings.SplitN(strings.Trim(path, "/"), "/", 3)
+	if len(parts) > 1 && parts[1] == "data" {
+		parts[1] = "metadata"
+	}
+
+	return strings.Join(parts, "/")
+}
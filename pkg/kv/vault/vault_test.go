@@ -0,0 +1,121 @@
+// Copyright © 2022 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/sdk/vault"
+)
+
+// fakeKV is a minimal in-memory KV version 2 secrets engine mounted at secret/
+type fakeKV struct {
+	sync.Mutex
+	data map[string]json.RawMessage
+}
+
+func (f *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+	switch {
+	case r.Method == http.MethodPut || r.Method == http.MethodPost:
+		var body struct {
+			Data json.RawMessage `json:"data"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.data[strings.TrimPrefix(path, "secret/data/")] = body.Data
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodGet && r.URL.Query().Get("list") == "true", r.Method == "LIST":
+		prefix := strings.TrimSuffix(strings.TrimPrefix(path, "secret/metadata/"), "/") + "/"
+		var keys []string
+		for key := range f.data {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, strings.TrimPrefix(key, prefix))
+			}
+		}
+		if len(keys) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sort.Strings(keys)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+
+	case r.Method == http.MethodGet:
+		data, ok := f.data[strings.TrimPrefix(path, "secret/data/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": data}})
+
+	case r.Method == http.MethodDelete:
+		delete(f.data, strings.TrimPrefix(path, "secret/metadata/"))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestListDelete(t *testing.T) {
+	server := httptest.NewServer(&fakeKV{data: map[string]json.RawMessage{}})
+	defer server.Close()
+
+	rawClient, err := vaultapi.NewClient(&vaultapi.Config{Address: server.URL})
+	assert.NoError(t, err)
+
+	client, err := vault.NewClientFromRawClient(rawClient, vault.ClientToken("root"))
+	assert.NoError(t, err)
+
+	service := &vaultStorage{client: client, path: "secret/data/unseal-keys"}
+
+	keys, err := kv.List(service, "")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	for _, key := range []string{"vault-unseal-1", "vault-root", "vault-unseal-0"} {
+		assert.NoError(t, service.Set(key, []byte(key)))
+	}
+
+	keys, err = kv.List(service, "vault-unseal-")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vault-unseal-0", "vault-unseal-1"}, keys)
+
+	assert.NoError(t, kv.Delete(service, "vault-unseal-1"))
+
+	_, err = service.Get("vault-unseal-1")
+	assert.True(t, kv.IsNotFoundError(err))
+
+	value, err := service.Get("vault-unseal-0")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("vault-unseal-0"), value)
+}
+
+func TestMetadataPath(t *testing.T) {
+	assert.Equal(t, "secret/metadata/unseal-keys", metadataPath("secret/data/unseal-keys"))
+	assert.Equal(t, "secret/metadata", metadataPath("/secret/data/"))
+	assert.Equal(t, "kv/unseal-keys", metadataPath("kv/unseal-keys"))
+}